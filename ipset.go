@@ -6,41 +6,227 @@
 package ipset
 
 import (
+	"bytes"
+	"context"
 	"encoding/xml"
+	"errors"
 	"fmt"
-	"regexp"
+	"io"
+	"io/ioutil"
+	"net"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	utilexec "k8s.io/utils/exec"
 )
 
 // IPSetEntry defines the XML data structure of each entry.
 type IPSetEntry struct {
-	Element string `xml:"elem"`
-	Comment string `xml:"comment"`
+	Element string `xml:"elem" json:"element"`
+	Comment string `xml:"comment" json:"comment,omitempty"`
+	Timeout int    `xml:"timeout" json:"timeout,omitempty"`
+	Packets uint64 `xml:"packets" json:"packets,omitempty"`
+	Bytes   uint64 `xml:"bytes" json:"bytes,omitempty"`
+
+	// SkbMark is the skbmark of the skbinfo set entry, e.g. 0x1/0xffff, see
+	// SetSkbmark and SkbmarkValue.
+	SkbMark string `xml:"skbmark" json:"skbmark,omitempty"`
+
+	// NoMatch is the nomatch flag of the hash:net entry, the exception of
+	// the larger network of the set.
+	NoMatch bool `xml:"-" json:"nomatch,omitempty"`
+
+	// Before and After place the list:set member relative to another member.
+	Before string `xml:"-" json:"before,omitempty"`
+	After  string `xml:"-" json:"after,omitempty"`
+
+	// ResolvedName is the element with the resolved host name, it is set by
+	// ListEntriesResolved only when the name is resolved.
+	ResolvedName string `xml:"-" json:"resolved,omitempty"`
 }
 
-var removeOuterQuotes = regexp.MustCompile(`^"(.*)"$`)
+// UnmarshalXML decodes the entry, the <nomatch/> flag is the empty element
+// which is set by its presence.
+func (entry *IPSetEntry) UnmarshalXML(d *xml.Decoder,
+	start xml.StartElement) error {
+	type ipsetEntry IPSetEntry
+	var raw struct {
+		ipsetEntry
+		NoMatch *struct{} `xml:"nomatch"`
+	}
+
+	err := d.DecodeElement(&raw, &start)
+	if err != nil {
+		return err
+	}
+
+	*entry = IPSetEntry(raw.ipsetEntry)
+	entry.NoMatch = raw.NoMatch != nil
+
+	return nil
+}
 
-// format does the entry data formatting
+// format does the entry data formatting, the outer quotes of the comment are
+// removed while the whitespaces inside the quotes are kept as is.
 func (entry *IPSetEntry) format() {
-	entry.Comment = removeOuterQuotes.ReplaceAllString(entry.Comment, `$1`)
+	comment := strings.TrimSpace(entry.Comment)
+	if len(comment) >= 2 && strings.HasPrefix(comment, `"`) &&
+		strings.HasSuffix(comment, `"`) {
+		entry.Comment = comment[1 : len(comment)-1]
+	}
+}
+
+// formatFor does the entry data formatting, the element is parsed with the
+// type-specific parser when the set type is supported. The element listed by
+// the kernel is kept as is when the parser does not know it, e.g. the
+// protocol of the port 0 entry, so one unexpected member does not fail the
+// listing of the whole set.
+func (entry *IPSetEntry) formatFor(setType Type) {
+	entry.format()
+
+	parsed, err := ParseEntryElement(entry.Element, setType)
+	if err == nil {
+		entry.Element = parsed.Element
+	}
+}
+
+// Validate checks if a given entry is valid for the set type.
+func (entry *IPSetEntry) Validate(setType Type) error {
+	return ValidateEntryForSet(entry, setType)
+}
+
+// validate checks if a given entry is obviously invalid regardless of the set
+// type, the type-aware check requires the set type which is known only by
+// the caller, see Validate.
+func (entry *IPSetEntry) validate() error {
+	if len(entry.Element) == 0 {
+		return &EntryError{"element", entry.Element, "should not be empty"}
+	}
+
+	if strings.ContainsAny(entry.Element, " \t\n") {
+		return &EntryError{"element", entry.Element,
+			"should not contain whitespace"}
+	}
+
+	if len(entry.Before) > 0 && len(entry.After) > 0 {
+		return &EntryError{"before", entry.Before,
+			"should not be used together with after"}
+	}
+
+	if len(entry.SkbMark) > 0 {
+		if _, _, err := ParseSkbmark(entry.SkbMark); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// element returns the element argument used by the ipset commands.
+func (entry *IPSetEntry) element() string {
+	return entry.Element
+}
+
+// SortEntries sorts the entries by the element, for the ipset which does not
+// support the -sorted flag. The element starting with an IP address is sorted
+// numerically, e.g. 10.0.0.2 before 10.0.0.10, IPv4 before IPv6, then by the
+// prefix length and the rest of the element. The other elements are sorted
+// lexicographically after them.
+func SortEntries(entries []IPSetEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return lessElement(entries[i].Element, entries[j].Element)
+	})
+}
+
+// lessElement compares the elements the way SortEntries does.
+func lessElement(a, b string) bool {
+	ipA, prefixA := elementIP(a)
+	ipB, prefixB := elementIP(b)
+
+	switch {
+	case ipA == nil && ipB == nil:
+		return a < b
+	case ipA == nil || ipB == nil:
+		return ipA != nil
+	}
+
+	if len(ipA) != len(ipB) {
+		return len(ipA) < len(ipB)
+	}
+
+	if cmp := bytes.Compare(ipA, ipB); cmp != 0 {
+		return cmp < 0
+	}
+
+	if prefixA != prefixB {
+		return prefixA < prefixB
+	}
+
+	return a < b
+}
+
+// elementIP returns the IP address of the first part of the element, in its
+// 4-byte form for IPv4, and its prefix length, or nil if it is not an IP.
+func elementIP(element string) (net.IP, int) {
+	addr := strings.SplitN(element, ",", 2)[0]
+
+	prefix := -1
+	if idx := strings.Index(addr, "/"); idx >= 0 {
+		prefix, _ = strconv.Atoi(addr[idx+1:])
+		addr = addr[:idx]
+	}
+
+	ip := net.ParseIP(addr)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	return ip, prefix
 }
 
 // IPSet defines the XML data structure of each set.
 type IPSet struct {
-	Name        string       `xml:"name,attr"`
-	SetType     Type         `xml:"type"`
-	HashFamily  string       `xml:"header>family"`
-	HashSize    int          `xml:"header>hashsize"`
-	MaxElement  int          `xml:"header>maxelem"`
-	WithComment bool         `xml:"header>comment"`
-	Entries     []IPSetEntry `xml:"members>member"`
+	Name         string       `xml:"name,attr" json:"name"`
+	SetType      Type         `xml:"type" json:"type"`
+	HashFamily   string       `xml:"header>family" json:"family,omitempty"`
+	HashSize     int          `xml:"header>hashsize" json:"hashsize,omitempty"`
+	MaxElement   int          `xml:"header>maxelem" json:"maxelem,omitempty"`
+	BucketSize   int          `xml:"header>bucketsize" json:"bucketsize,omitempty"`
+	Netmask      int          `xml:"header>netmask" json:"netmask,omitempty"`
+	Range        string       `xml:"header>range" json:"range,omitempty"`
+	Timeout      int          `xml:"header>timeout" json:"timeout,omitempty"`
+	WithCounters bool         `xml:"header>counters" json:"counters,omitempty"`
+	WithComment  bool         `xml:"header>comment" json:"comment,omitempty"`
+	WithSkbinfo  bool         `xml:"header>skbinfo" json:"skbinfo,omitempty"`
+	MemSize      int          `xml:"header>memsize" json:"memsize,omitempty"`
+	Entries      []IPSetEntry `xml:"members>member" json:"entries,omitempty"`
 }
 
-// Validate checks if a given ipset is valid or not.
+// Validate checks if a given ipset is valid or not, the nil set is invalid.
+// The empty name is ErrEmptySetName, see ValidateSetName.
 func (set *IPSet) Validate() error {
-	if set.SetType == HashIP || set.SetType == HashNet {
+	if set == nil {
+		return fmt.Errorf("set specification must not be nil")
+	}
+
+	if len(set.Name) == 0 {
+		return ErrEmptySetName
+	}
+
+	if err := ValidateSetName(set.Name); err != nil {
+		return err
+	}
+
+	if len(set.HashFamily) > 0 && !set.SetType.allowsFamily(set.HashFamily) {
+		return fmt.Errorf("invalid Hash Family %s for %s, the type is IPv4 "+
+			"only", set.HashFamily, set.SetType)
+	}
+
+	if set.SetType.isHash() && set.SetType.hasFamily() &&
+		!(set.SetType.familyOptional() && len(set.HashFamily) == 0) {
 		if !set.validateHashFamily() {
 			return fmt.Errorf("invalid Hash Family")
 		}
@@ -50,38 +236,198 @@ func (set *IPSet) Validate() error {
 		return fmt.Errorf("invalid Set Type")
 	}
 
-	if set.HashSize <= 0 {
+	// The sizes are the options of the hash types, the bitmap and list:set
+	// ones, e.g. parsed by ParseSave, are left zero.
+	if set.SetType.isHash() && set.HashSize <= 0 {
 		return fmt.Errorf("invalid Hash Size value %d, should be >0",
 			set.HashSize)
 	}
 
-	if set.MaxElement <= 0 {
+	if set.SetType.isHash() && set.MaxElement <= 0 {
 		return fmt.Errorf("invalid Max Element value %d, should be >0",
 			set.MaxElement)
 	}
 
+	if set.BucketSize < 0 {
+		return fmt.Errorf("invalid Bucket Size value %d, should be >=0",
+			set.BucketSize)
+	}
+
+	if set.Netmask != 0 {
+		err := set.validateNetmask()
+		if err != nil {
+			return err
+		}
+	}
+
+	if set.SetType.isBitmap() || len(set.Range) > 0 {
+		err := set.validateRange()
+		if err != nil {
+			return err
+		}
+	}
+
+	if set.Timeout < 0 {
+		return fmt.Errorf("invalid Timeout value %d, should be >=0",
+			set.Timeout)
+	}
+
 	return nil
 }
 
-// checks if given set type is valid
-func (set *IPSet) validateIPSetType() bool {
-	for _, valid := range ValidIPSetTypes {
-		if set.SetType == valid {
-			return true
+// validateNetmask checks the netmask option, it is only supported by the
+// hash:ip type and is the prefix length of the set family.
+func (set *IPSet) validateNetmask() error {
+	if set.SetType != HashIP {
+		return fmt.Errorf("invalid Netmask for %s, the option is %s only",
+			set.SetType, HashIP)
+	}
+
+	max := 32
+	if set.HashFamily == ProtocolFamilyIPv6 {
+		max = 128
+	}
+
+	if set.Netmask < 1 || set.Netmask > max {
+		return fmt.Errorf("invalid Netmask value %d, should be 1-%d",
+			set.Netmask, max)
+	}
+
+	return nil
+}
+
+// validateRange checks the range option, it is required by the bitmap types,
+// e.g. 1024-65535 of the bitmap:port, and is rejected by the other types,
+// e.g. hash:ip, which ipset would refuse to create.
+func (set *IPSet) validateRange() error {
+	if !set.SetType.hasRange() {
+		return fmt.Errorf("invalid Range for %s, the option is bitmap only, "+
+			"the hash set is sized by the max element and hash size",
+			set.SetType)
+	}
+
+	if len(set.Range) == 0 {
+		return fmt.Errorf("invalid Range, should be set for %s", set.SetType)
+	}
+
+	if set.SetType == BitmapPort {
+		if _, err := ParsePortRange(set.Range); err != nil {
+			return fmt.Errorf("invalid Range %s, error: %v", set.Range, err)
 		}
 	}
 
-	return false
+	return nil
+}
+
+// checks if given set type is valid
+func (set *IPSet) validateIPSetType() bool {
+	return ValidateIPSetType(set.SetType)
 }
 
 // checks if given hash family is supported in ipset
 func (set *IPSet) validateHashFamily() bool {
-	if set.HashFamily == ProtocolFamilyIPv4 ||
-		set.HashFamily == ProtocolFamilyIPv6 {
-		return true
+	return ValidateHashFamily(set.HashFamily)
+}
+
+// formatEntries does the data formatting of all set entries, see formatFor.
+func (set *IPSet) formatEntries() {
+	for idx := range set.Entries {
+		set.Entries[idx].formatFor(set.SetType)
+	}
+}
+
+// checks if given hash family is optional for the set type and is the
+// default one, which could be omitted from the create command
+func (set *IPSet) isDefaultOptionalFamily() bool {
+	return set.SetType.familyOptional() &&
+		(len(set.HashFamily) == 0 || set.HashFamily == ProtocolFamilyIPv4)
+}
+
+// UnmarshalXML decodes the set, the header flags, e.g. <comment/>, are the
+// empty elements which are set by their presence.
+func (set *IPSet) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	type ipset IPSet
+	var raw struct {
+		ipset
+		Counters *struct{} `xml:"header>counters"`
+		Comment  *struct{} `xml:"header>comment"`
+		Skbinfo  *struct{} `xml:"header>skbinfo"`
+	}
+
+	err := d.DecodeElement(&raw, &start)
+	if err != nil {
+		return err
+	}
+
+	*set = IPSet(raw.ipset)
+	set.WithCounters = raw.Counters != nil
+	set.WithComment = raw.Comment != nil
+	set.WithSkbinfo = raw.Skbinfo != nil
+
+	return nil
+}
+
+// IPSetHeader defines the XML data structure of the set header.
+type IPSetHeader struct {
+	Name         string `xml:"-" json:"name"`
+	SetType      Type   `xml:"-" json:"type"`
+	HashFamily   string `xml:"family" json:"family,omitempty"`
+	HashSize     int    `xml:"hashsize" json:"hashsize,omitempty"`
+	MaxElement   int    `xml:"maxelem" json:"maxelem,omitempty"`
+	BucketSize   int    `xml:"bucketsize" json:"bucketsize,omitempty"`
+	Netmask      int    `xml:"netmask" json:"netmask,omitempty"`
+	Range        string `xml:"range" json:"range,omitempty"`
+	Timeout      int    `xml:"timeout" json:"timeout,omitempty"`
+	WithCounters bool   `xml:"counters" json:"counters,omitempty"`
+	WithComment  bool   `xml:"comment" json:"comment,omitempty"`
+	WithSkbinfo  bool   `xml:"skbinfo" json:"skbinfo,omitempty"`
+	MemSize      int    `xml:"memsize" json:"memsize"`
+	References   int    `xml:"references" json:"references"`
+	NumEntries   int    `xml:"numentries" json:"numentries"`
+}
+
+// spec returns the set specification of the header.
+func (header *IPSetHeader) spec() *IPSet {
+	return &IPSet{
+		Name:         header.Name,
+		SetType:      header.SetType,
+		HashFamily:   header.HashFamily,
+		HashSize:     header.HashSize,
+		MaxElement:   header.MaxElement,
+		BucketSize:   header.BucketSize,
+		Netmask:      header.Netmask,
+		Range:        header.Range,
+		Timeout:      header.Timeout,
+		WithCounters: header.WithCounters,
+		WithComment:  header.WithComment,
+		WithSkbinfo:  header.WithSkbinfo,
+		MemSize:      header.MemSize,
+	}
+}
+
+// UnmarshalXML decodes the header, the flags, e.g. <comment/>, are the empty
+// elements which are set by their presence.
+func (header *IPSetHeader) UnmarshalXML(d *xml.Decoder,
+	start xml.StartElement) error {
+	type ipsetHeader IPSetHeader
+	var raw struct {
+		ipsetHeader
+		Counters *struct{} `xml:"counters"`
+		Comment  *struct{} `xml:"comment"`
+		Skbinfo  *struct{} `xml:"skbinfo"`
+	}
+
+	err := d.DecodeElement(&raw, &start)
+	if err != nil {
+		return err
 	}
 
-	return false
+	*header = IPSetHeader(raw.ipsetHeader)
+	header.WithCounters = raw.Counters != nil
+	header.WithComment = raw.Comment != nil
+	header.WithSkbinfo = raw.Skbinfo != nil
+
+	return nil
 }
 
 // IPSets defines the XML data structure of sets.
@@ -93,243 +439,1787 @@ type IPSets struct {
 // Implementations must be goroutine-safe.
 type Interface interface {
 	CreateSet(set *IPSet, ignoreExistErr bool) error
-	DestroySet(setname string) error
+	CreateSetWithSpec(set *IPSet, ignoreExistErr bool) (*IPSet, error)
+	CreateSetWithOptions(set *IPSet, opts CreateOptions) error
+	CreateSets(sets []*IPSet, ignoreExistErr bool) error
+	DestroySets(setnames []SetName, ignoreNotExistErr bool) error
+	DestroySet(setname SetName) error
+	DestroySetWithOptions(setname SetName, opts DestroyOptions) error
+	RenameSet(oldName SetName, newName SetName) error
 	ListSets() ([]string, error)
-	ListEntries(setname string) ([]IPSetEntry, error)
-	AddEntry(entry *IPSetEntry, setname string, ignoreExistErr bool) error
-	DelEntry(entryElement string, setname string) error
+	ListSetsNameOnly() ([]string, error)
+	ListSetsMatching(prefix string) ([]string, error)
+	ListSetsFunc(match func(setname string) bool) ([]string, error)
+	ListEntries(setname SetName) ([]IPSetEntry, error)
+	ListEntriesResolved(setname SetName) ([]IPSetEntry, error)
+	ListEntriesSorted(setname SetName) ([]IPSetEntry, error)
+	ListEntriesSet(setname SetName) (map[string]IPSetEntry, error)
+	ListEntriesByComment(setname SetName, substring string) ([]IPSetEntry,
+		error)
+	ListAllEntries() (map[string][]IPSetEntry, error)
+	TotalMemory() (int64, error)
+	IterateEntries(setname SetName, fn func(entry IPSetEntry) error) error
+	GetSetHeader(setname SetName) (*IPSetHeader, error)
+	EnsureSet(set *IPSet) error
+	IsSetExists(setname SetName) (bool, error)
+	IsEmptySet(setname SetName) (bool, error)
+	CommentEnabled(setname SetName) (bool, error)
+	CreateSetIfNotExists(set *IPSet) error
+	SetMembers(listSetName SetName, orderedMembers []string) error
+	SaveSetStream(setname SetName, w io.Writer) error
+	SaveAllStream(w io.Writer) error
+	BackupSet(setname SetName, filepath string) error
+	BackupAll(filepath string) error
+	RestoreSetFromFile(filepath string, ignoreExistErr bool) error
+	RestoreAllFromFile(filepath string, ignoreExistErr bool) error
+	SaveToFile(path string) error
+	RestoreFromFile(path string, ignoreExistErr bool) error
+	AddEntry(entry *IPSetEntry, setname SetName, ignoreExistErr bool) error
+	AddEntryWithOptions(entry *IPSetEntry, setname SetName,
+		opts AddOptions) error
+	AddEntryBatch(setname SetName, entries []IPSetEntry) error
+	RefreshEntry(entry *IPSetEntry, setname SetName) error
+	DelEntry(entryElement string, setname SetName) error
+	DelEntryStruct(entry *IPSetEntry, setname SetName) error
+	DelEntries(elements []string, setname SetName,
+		ignoreNotExistErr bool) error
+	DelEntriesByComment(setname SetName, substring string) (int, error)
+	TestEntry(entryElement string, setname SetName) (bool, error)
+	HasEntry(entryElement string, setname SetName) bool
+	TestEntries(elements []string, setname SetName) (map[string]bool, error)
+	LookupEntry(element string, setname SetName) (*IPSetEntry, error)
+	SelfTest() error
+	TypeSupported(t Type) (bool, error)
+	FlushSet(setname SetName) error
+	FlushSetIfExists(setname SetName) error
+	ClearEntries(setname SetName) error
+	ResizeSet(setname SetName, newHashSize, newMaxElem int) error
+	CreateSetAndAddEntries(set *IPSet, entries []IPSetEntry,
+		ignoreExistErr bool) error
+	ApplyBatch(creates []*IPSet, entriesBySet map[string][]IPSetEntry) error
+	InvalidateCache(setname SetName)
+	GetVersion() (IPSetVersion, error)
 }
 
 // IPSetCmd represents the ipset util. We use ipset command for
 // ipset execute.
 const IPSetCmd = "ipset"
 
-// IPSetCmdMandatoryArgs represents the mandatory ipset command arguments.
-var IPSetCmdMandatoryArgs = []string{"-o", "xml"}
+// SudoCmd represents the sudo util used by WithSudo.
+const SudoCmd = "sudo"
+
+// defaultMandatoryArgs returns the default mandatory ipset command arguments,
+// the XML output is required by the list parsers.
+func defaultMandatoryArgs() []string {
+	return []string{"-o", "xml"}
+}
 
 // IPSetLockfilePath represents the ipset lockfile path
 const IPSetLockfilePath = "/run/ipset.lock"
 
 type ipsetLocker interface {
 	Lock() error
+	LockContext(ctx context.Context) error
 	Unlock()
 }
 
 type runner struct {
-	exec   utilexec.Interface
-	locker ipsetLocker
+	exec    utilexec.Interface
+	locker  ipsetLocker
+	metrics Metrics
+
+	// mu protects the runner configuration below.
+	mu              sync.RWMutex
+	mandatoryArgs   []string
+	testConcurrency int
+	familyCheck     bool
+	sudo            bool
+	commentFallback bool
+	quiet           bool
+	commandLogger   func(args []string)
+	errorLogger     func(err error)
+	defaultFamily   string
+	cache           map[string]IPSetHeader
+	version         *IPSetVersion
 }
 
-// newInternal returns a new Interface which will exec ipset and allows the caller
-// to change the ipset lockfile path.
-func newInternal(exec utilexec.Interface, lockfilePath string) Interface {
-	locker := &locker{
-		lockfilePath: lockfilePath,
-	}
+// DefaultTestConcurrency is the default number of concurrent ipset test
+// commands run by TestEntries.
+const DefaultTestConcurrency = 4
+
+// RunnerOption configures the runner returned by New.
+type RunnerOption func(*runner)
 
-	return &runner{
-		exec:   exec,
-		locker: locker,
+// WithMetrics sets the metrics recorder of every executed ipset command, the
+// nil recorder is ignored and the NopMetrics is kept.
+func WithMetrics(m Metrics) RunnerOption {
+	return func(runner *runner) {
+		if m == nil {
+			return
+		}
+
+		runner.metrics = m
 	}
 }
 
-// New returns a new Interface which will exec ipset.
-func New(exec utilexec.Interface) Interface {
-	return newInternal(exec, IPSetLockfilePath)
-}
+// WithMandatoryArgs overrides the mandatory arguments appended to every ipset
+// command, the default is the XML output which the list methods rely on.
+func WithMandatoryArgs(args []string) RunnerOption {
+	return func(runner *runner) {
+		runner.mu.Lock()
+		defer runner.mu.Unlock()
 
-// cmdArgsBuilder builds the ipset command with mandatory arguments.
-func cmdArgsBuilder(args []string) []string {
-	return append(args, IPSetCmdMandatoryArgs...)
+		runner.mandatoryArgs = append([]string{}, args...)
+	}
 }
 
-// CreateSet creates a new set with provided specification.
-func (runner *runner) CreateSet(set *IPSet, ignoreExistErr bool) error {
-	err := set.Validate()
-	if err != nil {
-		return fmt.Errorf("error creating set: %v, error: %v", set, err)
-	}
+// WithTestConcurrency sets the number of concurrent ipset test commands run
+// by TestEntries, the value < 1 is treated as 1.
+func WithTestConcurrency(n int) RunnerOption {
+	return func(runner *runner) {
+		runner.mu.Lock()
+		defer runner.mu.Unlock()
 
-	err = runner.locker.Lock()
-	if err != nil {
-		return err
+		if n < 1 {
+			n = 1
+		}
+		runner.testConcurrency = n
 	}
-	defer runner.locker.Unlock()
-
-	return runner.createSet(set, ignoreExistErr)
 }
 
-// createSet implements the create new set with validated specification
-func (runner *runner) createSet(set *IPSet, ignoreExistErr bool) error {
-	cmdArgs := []string{"create", set.Name, string(set.SetType)}
+// WithSudo runs every ipset command with sudo, e.g. sudo ipset create ..., for
+// the process without CAP_NET_ADMIN. The sudo must not prompt for a password.
+func WithSudo() RunnerOption {
+	return func(runner *runner) {
+		runner.mu.Lock()
+		defer runner.mu.Unlock()
 
-	if set.SetType == HashIP || set.SetType == HashNet {
-		cmdArgs = append(cmdArgs,
-			"family", set.HashFamily,
-			"hashsize", strconv.Itoa(set.HashSize),
-			"maxelem", strconv.Itoa(set.MaxElement),
-		)
+		runner.sudo = true
 	}
+}
 
-	if set.WithComment {
-		cmdArgs = append(cmdArgs, "comment")
-	}
+// WithFamilyCheck enables the AddEntry check that the entry address family
+// matches the set family, e.g. no IPv6 address in the inet set. The check
+// costs an extra ipset list command of the set header on every add, unless
+// the metadata cache is enabled, see WithMetadataCache.
+func WithFamilyCheck() RunnerOption {
+	return func(runner *runner) {
+		runner.mu.Lock()
+		defer runner.mu.Unlock()
 
-	if ignoreExistErr {
-		cmdArgs = append(cmdArgs, "-exist")
+		runner.familyCheck = true
 	}
+}
 
-	cmdArgs = cmdArgsBuilder(cmdArgs)
-	_, err := runner.exec.
-		Command(IPSetCmd, cmdArgs...).
-		CombinedOutput()
+// WithCommentFallback creates the set without the comment option when the
+// comment extension is not supported, e.g. on the old kernel, instead of
+// failing with ErrCommentUnsupported. The entries must then be added without
+// the comment, the created set header tells whether the option is set.
+func WithCommentFallback() RunnerOption {
+	return func(runner *runner) {
+		runner.mu.Lock()
+		defer runner.mu.Unlock()
 
-	if err != nil {
-		return fmt.Errorf("error creating set: %v, error: %v", set, err)
+		runner.commentFallback = true
 	}
-
-	return nil
 }
 
-// DestroySet destroys the specified set name.
-func (runner *runner) DestroySet(setname string) error {
-	err := runner.locker.Lock()
-	if err != nil {
-		return err
+// WithQuiet runs the add and create commands which ignore the exist error
+// with -q as well, so the expected noise of the bulk idempotent add is not in
+// the command output. The -q suppresses the error messages too, the failure
+// is still reported but without the ipset output detail.
+func WithQuiet() RunnerOption {
+	return func(runner *runner) {
+		runner.mu.Lock()
+		defer runner.mu.Unlock()
+
+		runner.quiet = true
 	}
-	defer runner.locker.Unlock()
+}
 
-	cmdArgs := cmdArgsBuilder([]string{"destroy", setname})
-	_, err = runner.exec.
-		Command(IPSetCmd, cmdArgs...).
-		CombinedOutput()
+// existArgs returns the arguments of the command ignoring the exist error.
+func (runner *runner) existArgs() []string {
+	runner.mu.RLock()
+	defer runner.mu.RUnlock()
 
-	if err != nil {
-		return fmt.Errorf("error destroying set %s, error: %v", setname, err)
+	if runner.quiet {
+		return []string{"-exist", "-q"}
 	}
 
-	return nil
+	return []string{"-exist"}
 }
 
-// ListSets list all set names from kernel.
-func (runner *runner) ListSets() ([]string, error) {
-	err := runner.locker.Lock()
-	if err != nil {
-		return nil, err
+// WithCommandLogger calls the logger with the full command line, e.g.
+// ["ipset", "add", "foo", "172.18.3.2", "-o", "xml"], just before every ipset
+// command is executed, for the audit trail. The arguments, e.g. comment and
+// skbmark, are logged as passed, the redaction is the logger's choice. The
+// logger is called concurrently by TestEntries and must not block.
+func WithCommandLogger(logger func(args []string)) RunnerOption {
+	return func(runner *runner) {
+		runner.mu.Lock()
+		defer runner.mu.Unlock()
+
+		runner.commandLogger = logger
 	}
-	defer runner.locker.Unlock()
+}
 
-	cmdArgs := cmdArgsBuilder([]string{"list", "-n"})
-	out, err := runner.exec.
-		Command(IPSetCmd, cmdArgs...).
-		CombinedOutput()
+// WithErrorLogger calls the logger with the error which is not returned to
+// the caller, e.g. the failure of HasEntry which is reported as false. The
+// logger is called concurrently and must not block.
+func WithErrorLogger(logger func(err error)) RunnerOption {
+	return func(runner *runner) {
+		runner.mu.Lock()
+		defer runner.mu.Unlock()
 
-	if err != nil {
-		return nil, fmt.Errorf("error listing all sets, error: %v", err)
+		runner.errorLogger = logger
 	}
+}
 
-	var sets IPSets
-	err = xml.Unmarshal([]byte(out), &sets)
+// logError passes the error to the error logger, if any.
+func (runner *runner) logError(err error) {
+	runner.mu.RLock()
+	logger := runner.errorLogger
+	runner.mu.RUnlock()
 
-	if err != nil {
-		return nil, fmt.Errorf("error extract data sets, error: %v", err)
+	if logger != nil {
+		logger(err)
 	}
+}
 
-	list := []string{}
-	for _, set := range sets.List {
-		list = append(list, set.Name)
+// WithDefaultFamily sets the hash family of the created hash sets whose
+// family is DefaultFamily or empty, e.g. ProtocolFamilyIPv6 for the IPv6 only
+// host, so the IPSetSpec sets need no IPSetHashFamily. The family other than
+// DefaultFamily set by IPSetHashFamily is kept, the sets are left as is when
+// the option is not given. The family is validated when the set is created.
+func WithDefaultFamily(family string) RunnerOption {
+	return func(runner *runner) {
+		runner.mu.Lock()
+		defer runner.mu.Unlock()
+
+		runner.defaultFamily = family
 	}
+}
 
-	return list, nil
+// withDefaultFamily returns the copy of the set with the runner default
+// family if the set family is the default one, otherwise the set as is.
+func (runner *runner) withDefaultFamily(set *IPSet) *IPSet {
+	runner.mu.RLock()
+	family := runner.defaultFamily
+	runner.mu.RUnlock()
+
+	if set == nil || len(family) == 0 ||
+		!set.SetType.isHash() || !set.SetType.hasFamily() ||
+		(len(set.HashFamily) > 0 && set.HashFamily != DefaultFamily) {
+		return set
+	}
+
+	withFamily := *set
+	withFamily.HashFamily = family
+
+	return &withFamily
 }
 
-// ListSets list all set names from kernel.
-func (runner *runner) ListEntries(setname string) ([]IPSetEntry, error) {
-	err := runner.locker.Lock()
-	if err != nil {
-		return nil, err
+// newInternal returns a new Interface which will exec ipset and allows the caller
+// to change the ipset lockfile path.
+func newInternal(exec utilexec.Interface, lockfilePath string,
+	opts ...RunnerOption) Interface {
+	locker := &locker{
+		lockfilePath: lockfilePath,
 	}
-	defer runner.locker.Unlock()
 
-	cmdArgs := cmdArgsBuilder([]string{"list", setname})
-	out, err := runner.exec.
-		Command(IPSetCmd, cmdArgs...).
-		CombinedOutput()
+	runner := &runner{
+		exec:            exec,
+		locker:          locker,
+		metrics:         NopMetrics{},
+		mandatoryArgs:   defaultMandatoryArgs(),
+		testConcurrency: DefaultTestConcurrency,
+	}
 
-	if err != nil {
-		return nil, fmt.Errorf("error listing all sets, error: %v", err)
+	for _, opt := range opts {
+		opt(runner)
 	}
 
-	var sets IPSets
-	err = xml.Unmarshal([]byte(out), &sets)
+	return runner
+}
 
-	if err != nil {
-		return nil, fmt.Errorf("error extract data sets, error: %v", err)
+// New returns a new Interface which will exec ipset.
+func New(exec utilexec.Interface, opts ...RunnerOption) Interface {
+	return newInternal(exec, IPSetLockfilePath, opts...)
+}
+
+// buildEntryArgs builds the entry element and its options arguments.
+func buildEntryArgs(entry *IPSetEntry) []string {
+	args := []string{entry.element()}
+
+	if len(entry.Before) > 0 {
+		args = append(args, "before", entry.Before)
 	}
 
-	entries := []IPSetEntry{}
-	for _, set := range sets.List {
-		if set.Entries != nil {
-			for idx := range set.Entries {
-				set.Entries[idx].format()
-			}
+	if len(entry.After) > 0 {
+		args = append(args, "after", entry.After)
+	}
 
-			entries = set.Entries
-		}
+	if entry.Timeout > 0 {
+		args = append(args, "timeout", strconv.Itoa(entry.Timeout))
 	}
 
-	return entries, nil
-}
+	if entry.Packets > 0 {
+		args = append(args, "packets", strconv.FormatUint(entry.Packets, 10))
+	}
 
-// AddEntry adds an entry to the specified set name.
-func (runner *runner) AddEntry(entry *IPSetEntry, setname string,
-	ignoreExistErr bool) error {
-	cmdArgs := []string{"add", setname, entry.Element}
+	if entry.Bytes > 0 {
+		args = append(args, "bytes", strconv.FormatUint(entry.Bytes, 10))
+	}
 
 	if len(entry.Comment) > 0 {
-		cmdArgs = append(cmdArgs, "comment", entry.Comment)
+		args = append(args, "comment", entry.Comment)
 	}
 
-	if ignoreExistErr {
-		cmdArgs = append(cmdArgs, "-exist")
+	if len(entry.SkbMark) > 0 {
+		args = append(args, "skbmark", entry.SkbMark)
 	}
 
-	err := runner.locker.Lock()
-	if err != nil {
-		return err
+	if entry.NoMatch {
+		args = append(args, "nomatch")
 	}
-	defer runner.locker.Unlock()
 
-	cmdArgs = cmdArgsBuilder(cmdArgs)
+	return args
+}
 
-	_, err = runner.exec.
-		Command(IPSetCmd, cmdArgs...).
-		CombinedOutput()
+// plainOutputCommands lists the ipset commands that the mandatory arguments
+// must not be applied to, their output is not the XML.
+var plainOutputCommands = map[string]bool{
+	"save":    true,
+	"restore": true,
+	"version": true,
+}
 
-	if err != nil {
-		return fmt.Errorf("error adding entry %+v, error: %v", entry, err)
+// CmdArgsBuilder builds the ipset command arguments with the default
+// mandatory arguments appended at the end, e.g. ["list", "foo", "-o", "xml"],
+// the same way as the runner without WithMandatoryArgs does, for the logging
+// or the dry run printing. The save, restore and version commands have no
+// mandatory arguments. The returned slice is a copy, args is not changed.
+func CmdArgsBuilder(args []string) []string {
+	return cmdArgsBuilder(args, defaultMandatoryArgs())
+}
+
+// cmdArgsBuilder builds the ipset command with mandatory arguments, args is
+// copied rather than appended to.
+func cmdArgsBuilder(args []string, mandatoryArgs []string) []string {
+	cmdArgs := make([]string, 0, len(args)+len(mandatoryArgs))
+	cmdArgs = append(cmdArgs, args...)
+
+	if len(args) > 0 && plainOutputCommands[args[0]] {
+		return cmdArgs
 	}
 
-	return nil
+	return append(cmdArgs, mandatoryArgs...)
 }
 
-// DelEntry deletes an entry from the specified set name.
-func (runner *runner) DelEntry(entryElement string, setname string) error {
-	err := runner.locker.Lock()
-	if err != nil {
-		return err
+// run executes the ipset command with the mandatory arguments and records
+// the operation metrics.
+func (runner *runner) run(args []string) ([]byte, error) {
+	return runner.runWithStdin(args, nil)
+}
+
+// runWithStdin executes the ipset command the same way as run does, the data
+// is fed to the command stdin when it is not nil.
+func (runner *runner) runWithStdin(args []string, data []byte) ([]byte,
+	error) {
+	runner.mu.RLock()
+	cmdArgs := cmdArgsBuilder(args, runner.mandatoryArgs)
+	runner.mu.RUnlock()
+
+	return runner.execute(args[0], cmdArgs, data)
+}
+
+// runList executes the ipset list command the same way as run does, the
+// failure of the ipset older than v6.0, which has no XML output, is
+// ErrXMLUnsupported.
+func (runner *runner) runList(args []string) ([]byte, error) {
+	out, err := runner.run(args)
+	if err != nil && !runner.xmlSupported(out) {
+		return out, fmt.Errorf("%w, error: %v", ErrXMLUnsupported, err)
 	}
-	defer runner.locker.Unlock()
 
-	cmdArgs := cmdArgsBuilder([]string{"del", setname, entryElement})
-	_, err = runner.exec.
-		Command(IPSetCmd, cmdArgs...).
-		CombinedOutput()
+	return out, err
+}
+
+// allSetsName is the set name of the parse error of the all sets listing.
+const allSetsName = "all sets"
 
-	if err != nil {
-		return fmt.Errorf("error deleting entry %s, error: %v",
-			entryElement, err)
+// parseListError wraps the error of parsing the ipset list output of the set
+// name, errors.Unwrap returns the original error, e.g. *xml.SyntaxError.
+func parseListError(setname string, err error) error {
+	return fmt.Errorf("error parsing ipset list output for %s, error: %w",
+		setname, err)
+}
+
+// listAttempts is the number of the ipset list attempts, the list which is
+// interrupted or garbled by the concurrent modification of the sets is
+// retried.
+const listAttempts = 3
+
+// listIPSets runs the ipset list command and parses its XML output of the set
+// name, or allSetsName. The transient failure, the list interrupted with
+// EAGAIN or the malformed XML output, is retried up to listAttempts times and
+// the last error is returned. The caller holds the lock.
+func (runner *runner) listIPSets(args []string, setname string) (*IPSets,
+	error) {
+	var err error
+	for attempt := 0; attempt < listAttempts; attempt++ {
+		var out []byte
+		out, err = runner.runList(args)
+
+		if err != nil {
+			err = fmt.Errorf("error listing all sets, error: %w", err)
+			if listInterrupted(out) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		var sets IPSets
+		err = xml.Unmarshal(out, &sets)
+
+		if err == nil {
+			return &sets, nil
+		}
+
+		err = parseListError(setname, err)
 	}
 
+	return nil, err
+}
+
+// listInterrupted checks if the list command output is the failure of the
+// kernel dump interrupted by the concurrent modification.
+func listInterrupted(out []byte) bool {
+	return strings.Contains(string(out), "Resource temporarily unavailable")
+}
+
+// xmlSupported checks if the ipset supports the XML output, the version is
+// taken from the error output, e.g. "ipset v7.6: ...", or from the ipset
+// version command. The unknown version is assumed to be supported.
+func (runner *runner) xmlSupported(out []byte) bool {
+	version, err := ParseVersion(string(out))
+	if err != nil {
+		version, err = runner.GetVersion()
+	}
+
+	if err != nil {
+		// ipset older than v6.0 has no version command.
+		out, _ = runner.runPlain([]string{"-V"})
+		version, err = ParseVersion(string(out))
+	}
+
+	return err != nil || version.AtLeast(6, 0)
+}
+
+// runPlain executes the ipset command without the mandatory arguments, the
+// output is the ipset plain text.
+func (runner *runner) runPlain(args []string) ([]byte, error) {
+	return runner.execute(args[0], args, nil)
+}
+
+// command returns the ipset command of the arguments, run by sudo if it is
+// enabled, and passes the command line to the command logger.
+func (runner *runner) command(cmdArgs []string) utilexec.Cmd {
+	runner.mu.RLock()
+	sudo := runner.sudo
+	logger := runner.commandLogger
+	runner.mu.RUnlock()
+
+	name := IPSetCmd
+	if sudo {
+		name, cmdArgs = SudoCmd, append([]string{IPSetCmd}, cmdArgs...)
+	}
+
+	if logger != nil {
+		logger(append([]string{name}, cmdArgs...))
+	}
+
+	return runner.exec.Command(name, cmdArgs...)
+}
+
+// execute executes the ipset command arguments as is and records the
+// operation metrics.
+func (runner *runner) execute(op string, cmdArgs []string, data []byte) (
+	[]byte, error) {
+	cmd := runner.command(cmdArgs)
+	if data != nil {
+		cmd.SetStdin(bytes.NewReader(data))
+	}
+
+	start := time.Now()
+
+	out, err := cmd.CombinedOutput()
+
+	runner.metrics.RecordOperation(op,
+		float64(time.Since(start))/float64(time.Millisecond), err)
+
+	return out, err
+}
+
+// stream executes the ipset command the same way as run does, but its stdout
+// is passed to read while the command runs instead of being buffered, e.g.
+// to decode the large list output incrementally. The stderr is kept apart and
+// returned for the error of the failed command.
+func (runner *runner) stream(args []string, read func(stdout io.Reader)) (
+	[]byte, error) {
+	runner.mu.RLock()
+	cmdArgs := cmdArgsBuilder(args, runner.mandatoryArgs)
+	runner.mu.RUnlock()
+
+	var stderr bytes.Buffer
+
+	cmd := runner.command(cmdArgs)
+	cmd.SetStderr(&stderr)
+
+	start := time.Now()
+
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+
+	if err == nil {
+		read(stdout)
+
+		// The rest of the output is drained, so the command is not blocked
+		// on the full pipe when read stops early.
+		io.Copy(ioutil.Discard, stdout)
+
+		err = cmd.Wait()
+	}
+
+	runner.metrics.RecordOperation(args[0],
+		float64(time.Since(start))/float64(time.Millisecond), err)
+
+	return stderr.Bytes(), err
+}
+
+// CreateSet creates a new set with provided specification.
+func (runner *runner) CreateSet(set *IPSet, ignoreExistErr bool) error {
+	set = runner.withDefaultFamily(set)
+
+	err := set.Validate()
+	if err != nil {
+		return fmt.Errorf("error creating set: %v, error: %w", set, err)
+	}
+
+	err = runner.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer runner.locker.Unlock()
+
+	return runner.createSet(set, ignoreExistErr)
+}
+
+// createSet implements the create new set with validated specification
+func (runner *runner) createSet(set *IPSet, ignoreExistErr bool) error {
+	cmdArgs := buildCreateArgs(set)
+
+	if ignoreExistErr {
+		cmdArgs = append(cmdArgs, runner.existArgs()...)
+	}
+
+	out, err := runner.run(cmdArgs)
+
+	if err != nil {
+		if set.WithComment && commentUnsupported(out) {
+			return runner.createSetWithoutComment(set, ignoreExistErr)
+		}
+
+		return fmt.Errorf("error creating set: %v, error: %v", set, err)
+	}
+
+	runner.cacheSet(set)
+
+	return nil
+}
+
+// CreateSetWithSpec creates a new set with provided specification the same
+// way as CreateSet does, then returns the specification of the set created
+// by the kernel, which could differ from the provided one, e.g. the hash size
+// is rounded up to the power of two.
+func (runner *runner) CreateSetWithSpec(set *IPSet, ignoreExistErr bool) (
+	*IPSet, error) {
+	set = runner.withDefaultFamily(set)
+
+	err := set.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("error creating set: %v, error: %w", set, err)
+	}
+
+	err = runner.locker.Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer runner.locker.Unlock()
+
+	err = runner.createSet(set, ignoreExistErr)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := runner.getSetHeader(set.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error getting created set %s, error: %w",
+			set.Name, err)
+	}
+
+	return header.spec(), nil
+}
+
+// createSetWithoutComment creates the set without the comment option when
+// the comment fallback is enabled, otherwise ErrCommentUnsupported is
+// returned.
+func (runner *runner) createSetWithoutComment(set *IPSet,
+	ignoreExistErr bool) error {
+	runner.mu.RLock()
+	fallback := runner.commentFallback
+	runner.mu.RUnlock()
+
+	if !fallback {
+		return fmt.Errorf("error creating set: %v, error: %w", set,
+			ErrCommentUnsupported)
+	}
+
+	withoutComment := *set
+	withoutComment.WithComment = false
+
+	return runner.createSet(&withoutComment, ignoreExistErr)
+}
+
+// commentUnsupported checks if the create command output is the failure of
+// the unsupported comment extension, the ipset older than v6.22 does not
+// know the option and the old kernel module has no set type revision with
+// it.
+func commentUnsupported(out []byte) bool {
+	output := string(out)
+
+	return strings.Contains(output, "Unknown argument: `comment'") ||
+		strings.Contains(output, "Argument `comment' is supported in the "+
+			"kernel module")
+}
+
+// typeCommandBuilders builds the type-specific create command arguments of
+// the set, every type of ValidIPSetTypes registers its builder.
+var typeCommandBuilders = map[Type]func(set *IPSet) []string{
+	HashIP:       buildHashCreateArgs,
+	HashNet:      buildHashCreateArgs,
+	HashNetPort:  buildHashCreateArgs,
+	HashIPPort:   buildHashCreateArgs,
+	HashIPPortIP: buildHashCreateArgs,
+	HashMAC:      buildHashCreateArgs,
+	HashNetNet:   buildHashCreateArgs,
+	HashNetIface: buildHashCreateArgs,
+	BitmapPort:   buildBitmapCreateArgs,
+	ListSet:      buildListCreateArgs,
+}
+
+// buildHashCreateArgs builds the create command arguments of the hash types.
+func buildHashCreateArgs(set *IPSet) []string {
+	cmdArgs := []string{}
+
+	if set.SetType.hasFamily() && !set.isDefaultOptionalFamily() {
+		cmdArgs = append(cmdArgs, "family", set.HashFamily)
+	}
+
+	cmdArgs = append(cmdArgs,
+		"hashsize", strconv.Itoa(set.HashSize),
+		"maxelem", strconv.Itoa(set.MaxElement),
+	)
+
+	if set.BucketSize > 0 {
+		cmdArgs = append(cmdArgs, "bucketsize", strconv.Itoa(set.BucketSize))
+	}
+
+	if set.Netmask > 0 {
+		cmdArgs = append(cmdArgs, "netmask", strconv.Itoa(set.Netmask))
+	}
+
+	return cmdArgs
+}
+
+// buildBitmapCreateArgs builds the create command arguments of the bitmap
+// types.
+func buildBitmapCreateArgs(set *IPSet) []string {
+	if len(set.Range) > 0 {
+		return []string{"range", set.Range}
+	}
+
+	return []string{}
+}
+
+// buildListCreateArgs builds the create command arguments of the list:set,
+// the default size is used.
+func buildListCreateArgs(set *IPSet) []string {
+	return []string{}
+}
+
+// buildCreateArgs builds the create command arguments of the set, the
+// type-specific ones are built by the builder of the type, see
+// typeCommandBuilders, followed by the common options.
+func buildCreateArgs(set *IPSet) []string {
+	cmdArgs := []string{"create", set.Name, string(set.SetType)}
+
+	if build, ok := typeCommandBuilders[set.SetType]; ok {
+		cmdArgs = append(cmdArgs, build(set)...)
+	}
+
+	if set.Timeout > 0 {
+		cmdArgs = append(cmdArgs, "timeout", strconv.Itoa(set.Timeout))
+	}
+
+	if set.WithCounters {
+		cmdArgs = append(cmdArgs, "counters")
+	}
+
+	if set.WithComment {
+		cmdArgs = append(cmdArgs, "comment")
+	}
+
+	if set.WithSkbinfo {
+		cmdArgs = append(cmdArgs, "skbinfo")
+	}
+
+	return cmdArgs
+}
+
+// DestroySet destroys the specified set name, ErrSetInUse is returned when the
+// set is still referenced, e.g. by an iptables rule, and ErrSetNotFound when
+// the set does not exist.
+func (runner *runner) DestroySet(setname SetName) error {
+	if len(setname) == 0 {
+		return ErrEmptySetName
+	}
+
+	err := runner.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer runner.locker.Unlock()
+
+	return runner.destroySet(string(setname))
+}
+
+// destroySet implements the destroy set, the caller holds the lock.
+func (runner *runner) destroySet(setname string) error {
+	out, err := runner.run([]string{"destroy", setname})
+
+	if err != nil {
+		if strings.Contains(string(out), "in use by a kernel component") {
+			return fmt.Errorf("error destroying set %s, error: %w", setname,
+				ErrSetInUse)
+		}
+
+		if strings.Contains(string(out), "does not exist") {
+			return fmt.Errorf("error destroying set %s, error: %w", setname,
+				ErrSetNotFound)
+		}
+
+		return fmt.Errorf("error destroying set %s, error: %v", setname, err)
+	}
+
+	runner.invalidateCache(setname)
+
+	return nil
+}
+
+// RenameSet renames the set, both names are validated before the command is
+// issued.
+func (runner *runner) RenameSet(oldName SetName, newName SetName) error {
+	if len(oldName) == 0 || len(newName) == 0 {
+		return ErrEmptySetName
+	}
+
+	for _, name := range []SetName{oldName, newName} {
+		err := name.Validate()
+		if err != nil {
+			return fmt.Errorf("error renaming set %s to %s, error: %w",
+				oldName, newName, err)
+		}
+	}
+
+	if oldName == newName {
+		return fmt.Errorf("error renaming set %s to %s, error: %w",
+			oldName, newName, ErrSameSetName)
+	}
+
+	err := runner.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer runner.locker.Unlock()
+
+	_, err = runner.run([]string{"rename", string(oldName), string(newName)})
+
+	if err != nil {
+		return fmt.Errorf("error renaming set %s to %s, error: %v",
+			oldName, newName, err)
+	}
+
+	runner.invalidateCache(string(oldName))
+	runner.invalidateCache(string(newName))
+
+	return nil
+}
+
+// ListSets list all set names from kernel.
+func (runner *runner) ListSets() ([]string, error) {
+	err := runner.locker.Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer runner.locker.Unlock()
+
+	sets, err := runner.listIPSets([]string{"list", "-n"}, allSetsName)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []string{}
+	for _, set := range sets.List {
+		list = append(list, set.Name)
+	}
+
+	return list, nil
+}
+
+// ListSetsNameOnly list all set names from kernel, the plain text output is
+// split into lines which avoids the XML parsing of ListSets.
+func (runner *runner) ListSetsNameOnly() ([]string, error) {
+	err := runner.locker.Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer runner.locker.Unlock()
+
+	return runner.listSetNames()
+}
+
+// listSetNames implements the list set names, the caller holds the lock.
+func (runner *runner) listSetNames() ([]string, error) {
+	out, err := runner.runPlain([]string{"list", "-n"})
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing all sets, error: %v", err)
+	}
+
+	list := []string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		name := strings.TrimSpace(line)
+		if len(name) > 0 {
+			list = append(list, name)
+		}
+	}
+
+	return list, nil
+}
+
+// ListSetsMatching list the set names starting with the prefix from kernel.
+func (runner *runner) ListSetsMatching(prefix string) ([]string, error) {
+	return runner.ListSetsFunc(func(setname string) bool {
+		return strings.HasPrefix(setname, prefix)
+	})
+}
+
+// ListSetsFunc list the set names satisfying the match from kernel.
+func (runner *runner) ListSetsFunc(match func(setname string) bool) ([]string,
+	error) {
+	all, err := runner.ListSets()
+	if err != nil {
+		return nil, err
+	}
+
+	list := []string{}
+	for _, setname := range all {
+		if match(setname) {
+			list = append(list, setname)
+		}
+	}
+
+	return list, nil
+}
+
+// ListEntries list all entries of the specified set name from kernel. The
+// entries are the snapshot of a single ipset list held under the ipset lock,
+// which serializes the users of the lock only. The other processes could
+// still modify the set during the list, which is dumped by the kernel in
+// parts, so the entries added or deleted meanwhile could be missing. The list
+// interrupted or garbled by the modification is retried, see listAttempts.
+func (runner *runner) ListEntries(setname SetName) ([]IPSetEntry, error) {
+	if len(setname) == 0 {
+		return nil, ErrEmptySetName
+	}
+
+	return runner.listEntries(string(setname))
+}
+
+// ListEntriesSorted list all entries of the specified set name from kernel
+// sorted by ipset with the -sorted flag, which is supported since ipset
+// v6.30 and is faster for the huge set. The entries of the older or unknown
+// ipset version are sorted by SortEntries instead, the order could differ
+// slightly from the ipset one, e.g. of the non-IP elements.
+func (runner *runner) ListEntriesSorted(setname SetName) ([]IPSetEntry, error) {
+	if len(setname) == 0 {
+		return nil, ErrEmptySetName
+	}
+
+	if runner.sortedListSupported() {
+		return runner.listEntries(string(setname), "-sorted")
+	}
+
+	entries, err := runner.listEntries(string(setname))
+	if err != nil {
+		return nil, err
+	}
+
+	SortEntries(entries)
+
+	return entries, nil
+}
+
+// ListEntriesSet list all entries of the specified set name from kernel keyed
+// by element, see EntriesByElement, the element is the one listed by ipset,
+// e.g. the host network without the prefix length.
+func (runner *runner) ListEntriesSet(setname SetName) (map[string]IPSetEntry,
+	error) {
+	entries, err := runner.ListEntries(setname)
+	if err != nil {
+		return nil, err
+	}
+
+	return EntriesByElement(entries), nil
+}
+
+// listEntries implements the list entries with the additional list flags.
+func (runner *runner) listEntries(setname string, flags ...string) (
+	[]IPSetEntry, error) {
+	err := runner.locker.Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer runner.locker.Unlock()
+
+	set, err := runner.listSet(setname, flags...)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []IPSetEntry{}
+	if set.Entries != nil {
+		entries = set.Entries
+	}
+
+	return entries, nil
+}
+
+// ListEntriesResolved list all entries of the specified set name from kernel
+// with the IP addresses resolved to the host names, the Element and the
+// ResolvedName hold the name reported by ipset when it is resolved. The
+// reverse lookup of every entry is slow and could block on the DNS, the
+// ipset lock is held meanwhile, so it is kept out of ListEntries.
+func (runner *runner) ListEntriesResolved(setname SetName) ([]IPSetEntry,
+	error) {
+	if len(setname) == 0 {
+		return nil, ErrEmptySetName
+	}
+
+	err := runner.locker.Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer runner.locker.Unlock()
+
+	out, err := runner.runList([]string{"list", string(setname), "-resolve"})
+
+	if err != nil {
+		return nil, fmt.Errorf("error listing all sets, error: %w", err)
+	}
+
+	var sets IPSets
+	err = xml.Unmarshal([]byte(out), &sets)
+
+	if err != nil {
+		return nil, parseListError(string(setname), err)
+	}
+
+	entries := []IPSetEntry{}
+	for _, set := range sets.List {
+		for _, entry := range set.Entries {
+			entry.format()
+
+			_, err := ParseEntryElement(entry.Element, set.SetType)
+			if err != nil && !errors.Is(err, ErrUnsupportedType) {
+				entry.ResolvedName = entry.Element
+			}
+
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// listSet implements the list of the specified set name with its header and
+// entries, the caller holds the lock.
+func (runner *runner) listSet(setname string, flags ...string) (*IPSet,
+	error) {
+	sets, err := runner.listIPSets(append([]string{"list", setname},
+		flags...), setname)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &IPSet{Name: setname}
+	for idx := range sets.List {
+		set = &sets.List[idx]
+		set.formatEntries()
+	}
+
+	return set, nil
+}
+
+// IterateEntries calls fn for each entry of the specified set name, the
+// entries are decoded one at a time as the ipset output is read instead of
+// being collected into a slice.
+// The iteration stops at the first error returned by fn. The fn is called
+// with the ipset lock held, so it must not call the other runner methods.
+func (runner *runner) IterateEntries(setname SetName,
+	fn func(entry IPSetEntry) error) error {
+	if len(setname) == 0 {
+		return ErrEmptySetName
+	}
+
+	err := runner.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer runner.locker.Unlock()
+
+	var iterErr error
+
+	out, err := runner.stream([]string{"list", string(setname)},
+		func(stdout io.Reader) {
+			iterErr = decodeEntries(stdout, string(setname), fn)
+		})
+
+	if err != nil {
+		if !runner.xmlSupported(out) {
+			err = fmt.Errorf("%w, error: %v", ErrXMLUnsupported, err)
+		}
+
+		return fmt.Errorf("error listing set %s, error: %w", setname, err)
+	}
+
+	return iterErr
+}
+
+// decodeEntries decodes the ipset list output of the set name from r and
+// calls fn for each entry, see IterateEntries.
+func decodeEntries(r io.Reader, setname string,
+	fn func(entry IPSetEntry) error) error {
+	var setType Type
+	decoder := xml.NewDecoder(r)
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return parseListError(setname, err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "type":
+			err = decoder.DecodeElement(&setType, &start)
+			if err != nil {
+				return parseListError(setname, err)
+			}
+		case "member":
+			var entry IPSetEntry
+			err = decoder.DecodeElement(&entry, &start)
+			if err != nil {
+				return parseListError(setname, err)
+			}
+
+			entry.formatFor(setType)
+
+			err = fn(entry)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// GetSetHeader returns the header of the specified set name, the set is
+// listed with -t, without the members. ErrSetNotFound
+// is returned when the set does not exist.
+func (runner *runner) GetSetHeader(setname SetName) (*IPSetHeader, error) {
+	if len(setname) == 0 {
+		return nil, ErrEmptySetName
+	}
+
+	err := runner.locker.Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer runner.locker.Unlock()
+
+	return runner.getSetHeader(string(setname))
+}
+
+// getSetHeader implements the get set header, the caller holds the lock.
+func (runner *runner) getSetHeader(setname string) (*IPSetHeader, error) {
+	out, err := runner.runList([]string{"list", setname, "-t"})
+
+	if err != nil {
+		if strings.Contains(string(out), "does not exist") {
+			return nil, fmt.Errorf("error listing set %s, error: %w", setname,
+				ErrSetNotFound)
+		}
+
+		return nil, fmt.Errorf("error listing set %s, error: %w", setname, err)
+	}
+
+	var setType Type
+	decoder := xml.NewDecoder(bytes.NewReader(out))
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, parseListError(setname, err)
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "type":
+			err = decoder.DecodeElement(&setType, &start)
+			if err != nil {
+				return nil, parseListError(setname, err)
+			}
+		case "header":
+			header := &IPSetHeader{}
+			err = decoder.DecodeElement(header, &start)
+			if err != nil {
+				return nil, parseListError(setname, err)
+			}
+
+			header.Name = setname
+			header.SetType = setType
+
+			runner.cacheHeader(header)
+
+			return header, nil
+		}
+	}
+
+	return nil, parseListError(setname, errors.New("header not found"))
+}
+
+// ListAllEntries list all sets with their entries from kernel, keyed by
+// set name, with a single ipset list instead of ListEntries per set. The set
+// without entries maps to the empty slice, not nil.
+func (runner *runner) ListAllEntries() (map[string][]IPSetEntry, error) {
+	err := runner.locker.Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer runner.locker.Unlock()
+
+	sets, err := runner.listIPSets([]string{"list"}, allSetsName)
+	if err != nil {
+		return nil, err
+	}
+
+	all := map[string][]IPSetEntry{}
+	for _, set := range sets.List {
+		set.formatEntries()
+
+		entries := []IPSetEntry{}
+		entries = append(entries, set.Entries...)
+
+		all[set.Name] = entries
+	}
+
+	return all, nil
+}
+
+// TotalMemory returns the sum of the memory size in bytes of all sets from
+// kernel, only the set headers are listed. The memory size of each set is
+// the IPSet.MemSize or the IPSetHeader.MemSize of the listed set.
+func (runner *runner) TotalMemory() (int64, error) {
+	err := runner.locker.Lock()
+	if err != nil {
+		return 0, err
+	}
+	defer runner.locker.Unlock()
+
+	sets, err := runner.listIPSets([]string{"list", "-t"}, allSetsName)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, set := range sets.List {
+		total += int64(set.MemSize)
+	}
+
+	return total, nil
+}
+
+// AddEntry adds an entry to the specified set name. The host address added
+// to the hash:ip set created with the netmask option, e.g. 192.168.1.100 to
+// the netmask 24 set, is stored by ipset as its network, e.g. 192.168.1.0.
+// The entry is validated for the set type before ipset add is run when the
+// set is in the metadata cache, see WithMetadataCache, the set header is only
+// listed for the family check, see WithFamilyCheck.
+func (runner *runner) AddEntry(entry *IPSetEntry, setname SetName,
+	ignoreExistErr bool) error {
+	if len(setname) == 0 {
+		return ErrEmptySetName
+	}
+
+	if entry == nil {
+		return fmt.Errorf("error adding entry to set %s, error: nil entry",
+			setname)
+	}
+
+	err := entry.validate()
+	if err != nil {
+		return fmt.Errorf("error adding entry %+v, error: %v", entry, err)
+	}
+
+	err = runner.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer runner.locker.Unlock()
+
+	err = runner.checkEntry(entry, string(setname))
+	if err != nil {
+		return fmt.Errorf("error adding entry %+v, error: %w", entry, err)
+	}
+
+	return runner.addEntry(entry, string(setname), ignoreExistErr)
+}
+
+// checkEntry checks the entry against the set type, and the set family when
+// the family check is enabled, see WithFamilyCheck, before the entry is
+// added. The set header is fetched from the kernel only for the family check
+// of the set not cached, see WithMetadataCache, otherwise the set not cached
+// is not checked. The caller holds the lock.
+func (runner *runner) checkEntry(entry *IPSetEntry, setname string) error {
+	runner.mu.RLock()
+	familyCheck := runner.familyCheck
+	runner.mu.RUnlock()
+
+	header, ok := runner.cachedSetHeader(setname)
+	if !ok && !familyCheck {
+		return nil
+	}
+
+	if !ok {
+		var err error
+		header, err = runner.getSetHeader(setname)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := ValidateEntryForSet(entry, header.SetType)
+	if err != nil && !errors.Is(err, ErrUnsupportedType) {
+		return err
+	}
+
+	if familyCheck {
+		return checkHeaderFamily(entry, header)
+	}
+
+	return nil
+}
+
+// RefreshEntry re-adds the entry to the specified set name with -exist to
+// reset its timeout, e.g. to extend the lease of an active connection. The
+// entry timeout, or the set default timeout when it is 0, is the new one.
+// It only has effect on the set created with the timeout option, the entry
+// of the other sets is left as is, and the missing entry is added.
+func (runner *runner) RefreshEntry(entry *IPSetEntry, setname SetName) error {
+	if len(setname) == 0 {
+		return ErrEmptySetName
+	}
+
+	if entry == nil {
+		return fmt.Errorf("error refreshing entry of set %s, error: nil entry",
+			setname)
+	}
+
+	err := entry.validate()
+	if err != nil {
+		return fmt.Errorf("error refreshing entry %+v, error: %v", entry, err)
+	}
+
+	err = runner.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer runner.locker.Unlock()
+
+	err = runner.addEntry(entry, string(setname), true)
+	if err != nil {
+		return fmt.Errorf("error refreshing entry %s in set %s, error: %v",
+			entry.Element, setname, err)
+	}
+
+	return nil
+}
+
+// checkHeaderFamily checks that the address family of the entry matches the
+// family of the set header.
+func checkHeaderFamily(entry *IPSetEntry, header *IPSetHeader) error {
+	family := elementFamily(entry.Element)
+	if len(header.HashFamily) == 0 || len(family) == 0 ||
+		family == header.HashFamily {
+		return nil
+	}
+
+	return &EntryError{"family", entry.Element,
+		fmt.Sprintf("should be %s address", header.HashFamily)}
+}
+
+// addEntry implements the add entry, the caller holds the lock.
+func (runner *runner) addEntry(entry *IPSetEntry, setname string,
+	ignoreExistErr bool) error {
+	cmdArgs := append([]string{"add", setname}, buildEntryArgs(entry)...)
+
+	if ignoreExistErr {
+		cmdArgs = append(cmdArgs, runner.existArgs()...)
+	}
+
+	_, err := runner.run(cmdArgs)
+
+	if err != nil {
+		return fmt.Errorf("error adding entry %+v, error: %v", entry, err)
+	}
+
+	return nil
+}
+
+// DelEntry deletes an entry from the specified set name.
+func (runner *runner) DelEntry(entryElement string, setname SetName) error {
+	if len(setname) == 0 {
+		return ErrEmptySetName
+	}
+
+	err := runner.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer runner.locker.Unlock()
+
+	return runner.delEntry(entryElement, string(setname))
+}
+
+// delEntry implements the delete entry, the caller holds the lock.
+func (runner *runner) delEntry(entryElement string, setname string) error {
+	_, err := runner.run([]string{"del", setname, entryElement})
+
+	if err != nil {
+		return fmt.Errorf("error deleting entry %s, error: %v",
+			entryElement, err)
+	}
+
+	return nil
+}
+
+// DelEntryStruct deletes an entry from the specified set name, the element is
+// formatted from the entry the same way as AddEntry does. Only the element is
+// passed, the entry options, e.g. comment, are rejected by ipset del.
+func (runner *runner) DelEntryStruct(entry *IPSetEntry, setname SetName) error {
+	if entry == nil {
+		return fmt.Errorf("error deleting entry from set %s, error: nil entry",
+			setname)
+	}
+
+	return runner.DelEntry(entry.element(), setname)
+}
+
+// TestEntry tests whether an entry is in the specified set name.
+func (runner *runner) TestEntry(entryElement string, setname SetName) (bool,
+	error) {
+	if len(setname) == 0 {
+		return false, ErrEmptySetName
+	}
+
+	err := runner.locker.Lock()
+	if err != nil {
+		return false, err
+	}
+	defer runner.locker.Unlock()
+
+	return runner.testEntry(entryElement, string(setname))
+}
+
+// testEntry implements the entry membership test, the caller holds the lock.
+func (runner *runner) testEntry(entryElement string, setname string) (bool,
+	error) {
+	out, err := runner.run([]string{"test", setname, entryElement})
+
+	if err != nil {
+		if strings.Contains(string(out), "is NOT in set") {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("error testing entry %s, error: %v",
+			entryElement, err)
+	}
+
+	return true, nil
+}
+
+// HasEntry checks whether an entry is in the specified set name the same way
+// as TestEntry does, for the quick membership check. Any error, e.g. the set
+// does not exist, is reported as false and passed to the error logger, see
+// WithErrorLogger, use TestEntry to tell them apart.
+func (runner *runner) HasEntry(entryElement string, setname SetName) bool {
+	found, err := runner.TestEntry(entryElement, setname)
+	if err != nil {
+		runner.logError(err)
+		return false
+	}
+
+	return found
+}
+
+// TestEntries tests whether the entries are in the specified set name, the
+// tests are run concurrently within the runner concurrency limit. The first
+// failed test stops the remaining ones and its error is returned.
+func (runner *runner) TestEntries(elements []string, setname SetName) (
+	map[string]bool, error) {
+	if len(setname) == 0 {
+		return nil, ErrEmptySetName
+	}
+
+	err := runner.locker.Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer runner.locker.Unlock()
+
+	runner.mu.RLock()
+	concurrency := runner.testConcurrency
+	runner.mu.RUnlock()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var stopOnce sync.Once
+	var firstErr error
+
+	results := make(map[string]bool, len(elements))
+	jobs := make(chan string)
+	stop := make(chan struct{})
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for element := range jobs {
+				select {
+				case <-stop:
+					continue
+				default:
+				}
+
+				found, err := runner.testEntry(element, string(setname))
+
+				mu.Lock()
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					stopOnce.Do(func() { close(stop) })
+				} else {
+					results[element] = found
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, element := range elements {
+		select {
+		case jobs <- element:
+		case <-stop:
+			break feed
+		}
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, fmt.Errorf("error testing entries in set %s, error: %v",
+			setname, firstErr)
+	}
+
+	return results, nil
+}
+
+// LookupEntry returns the entry of the element in the specified set name with
+// its metadata, e.g. comment, timeout and counters, ErrEntryNotFound is
+// returned when the element is not in the set. The whole set is listed to
+// find the entry, use TestEntry for the membership only, or IterateEntries
+// to stop early for the large set.
+func (runner *runner) LookupEntry(element string, setname SetName) (
+	*IPSetEntry, error) {
+	entries, err := runner.ListEntries(setname)
+	if err != nil {
+		return nil, fmt.Errorf("error looking up entry %s, error: %w",
+			element, err)
+	}
+
+	for idx := range entries {
+		if entries[idx].Element == element {
+			return &entries[idx], nil
+		}
+	}
+
+	return nil, fmt.Errorf("error looking up entry %s in set %s, error: %w",
+		element, setname, ErrEntryNotFound)
+}
+
+// SelfTest verifies the ipset is functional end-to-end by creating a
+// temporary hash:ip set, adding and testing an entry, then destroying it.
+func (runner *runner) SelfTest() error {
+	setname := SetName(tempSetName("ipset-selftest-"))
+	element := "127.0.0.1"
+
+	err := runner.CreateSet(IPSetSpec(
+		IPSetName(string(setname)),
+		IPSetType(HashIP),
+	), false)
+	if err != nil {
+		return fmt.Errorf("error self-testing, error: %v", err)
+	}
+
+	err = runner.selfTestEntry(element, setname)
+
+	destroyErr := runner.DestroySet(setname)
+	if err == nil {
+		err = destroyErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("error self-testing, error: %v", err)
+	}
+
+	return nil
+}
+
+// tempSetName returns a unique set name with the prefix for the temporary
+// set.
+func tempSetName(prefix string) string {
+	return prefix + strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// selfTestEntry adds and tests an entry of the self-test set.
+func (runner *runner) selfTestEntry(element string, setname SetName) error {
+	err := runner.AddEntry(&IPSetEntry{Element: element}, setname, false)
+	if err != nil {
+		return err
+	}
+
+	found, err := runner.TestEntry(element, setname)
+	if err != nil {
+		return err
+	}
+
+	if !found {
+		return fmt.Errorf("entry %s is not in set %s", element, setname)
+	}
+
+	return nil
+}
+
+// typeProbeArgs are the minimal options required to create a set of the type,
+// e.g. the bitmap:port set could not be created without its range.
+var typeProbeArgs = map[Type][]string{
+	BitmapPort: {"range", "0-1"},
+}
+
+// TypeSupported checks if a given set type is supported by the running kernel
+// by creating and destroying a throwaway set of the type with the default
+// options, and the minimal required ones, see typeProbeArgs.
+func (runner *runner) TypeSupported(t Type) (bool, error) {
+	setname := tempSetName("ipset-typetest-")
+
+	err := runner.locker.Lock()
+	if err != nil {
+		return false, err
+	}
+	defer runner.locker.Unlock()
+
+	args := append([]string{"create", setname, string(t)}, typeProbeArgs[t]...)
+
+	out, err := runner.run(args)
+	if err != nil {
+		if strings.Contains(string(out), "set type not supported") ||
+			strings.Contains(string(out), "is unknown") {
+			return false, nil
+		}
+
+		return false, fmt.Errorf("error checking set type %s, error: %v",
+			t, err)
+	}
+
+	_, err = runner.run([]string{"destroy", setname})
+	if err != nil {
+		return true, fmt.Errorf("error destroying set %s, error: %v",
+			setname, err)
+	}
+
+	return true, nil
+}
+
+// ClearEntries removes all entries from the specified set name,
+// ErrSetNotFound is returned when the set does not exist.
+func (runner *runner) ClearEntries(setname SetName) error {
+	if len(setname) == 0 {
+		return ErrEmptySetName
+	}
+
+	err := runner.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer runner.locker.Unlock()
+
+	out, err := runner.run([]string{"flush", string(setname)})
+
+	if err != nil {
+		if strings.Contains(string(out), "does not exist") {
+			return fmt.Errorf("error flushing set %s, error: %w", setname,
+				ErrSetNotFound)
+		}
+
+		return fmt.Errorf("error flushing set %s, error: %v", setname, err)
+	}
+
+	return nil
+}
+
+// FlushSet flushes the specified set name, it is an alias of ClearEntries.
+func (runner *runner) FlushSet(setname SetName) error {
+	return runner.ClearEntries(setname)
+}
+
+// FlushSetIfExists flushes the specified set name the same way as FlushSet,
+// the set which does not exist is not an error, e.g. for the flush and
+// destroy teardown of the set which could be already gone, see
+// DestroySetWithOptions.
+func (runner *runner) FlushSetIfExists(setname SetName) error {
+	err := runner.ClearEntries(setname)
+	if errors.Is(err, ErrSetNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+// ResizeSet changes the hash size and the maximum elements of the specified
+// set name. The set could not be resized in place, so a temporary set is
+// created with the new sizes, filled with the entries, swapped with the set
+// and then destroyed.
+func (runner *runner) ResizeSet(setname SetName, newHashSize,
+	newMaxElem int) error {
+	if len(setname) == 0 {
+		return ErrEmptySetName
+	}
+
+	err := runner.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer runner.locker.Unlock()
+
+	set, err := runner.listSet(string(setname))
+	if err != nil {
+		return fmt.Errorf("error resizing set %s, error: %v", setname, err)
+	}
+
+	temp := *set
+	temp.Name = tempSetName("ipset-resize-")
+	temp.HashSize = newHashSize
+	temp.MaxElement = newMaxElem
+	temp.Entries = nil
+
+	err = temp.Validate()
+	if err != nil {
+		return fmt.Errorf("error resizing set %s, error: %v", setname, err)
+	}
+
+	err = runner.createSet(&temp, false)
+	if err != nil {
+		return fmt.Errorf("error resizing set %s, error: %v", setname, err)
+	}
+
+	err = runner.fillAndSwapSet(set, temp.Name)
+
+	destroyErr := runner.destroySet(temp.Name)
+	if err == nil {
+		err = destroyErr
+	}
+
+	if err != nil {
+		return fmt.Errorf("error resizing set %s, error: %v", setname, err)
+	}
+
+	return nil
+}
+
+// fillAndSwapSet adds the set entries to the temporary set and swaps them,
+// the caller holds the lock.
+func (runner *runner) fillAndSwapSet(set *IPSet, tempname string) error {
+	for idx := range set.Entries {
+		err := runner.addEntry(&set.Entries[idx], tempname, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := runner.run([]string{"swap", set.Name, tempname})
+	if err != nil {
+		return fmt.Errorf("error swapping set %s with %s, error: %v",
+			set.Name, tempname, err)
+	}
+
+	runner.invalidateCache(set.Name)
+	runner.invalidateCache(tempname)
+
 	return nil
 }
@@ -7,6 +7,8 @@ package ipset
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -15,7 +17,8 @@ import (
 	fakeexec "k8s.io/utils/exec/testing"
 )
 
-const testHashIPIPSetLockfilePath = "ipset.lock"
+var testHashIPIPSetLockfilePath = filepath.Join(os.TempDir(),
+	"go-ipset-test.lock")
 
 func TestHashIPIPSetSpec(t *testing.T) {
 	cases := []struct {
@@ -99,10 +102,12 @@ func TestHashIPCreateSet(t *testing.T) {
 			),
 			combinedOutputLog: [][]string{
 				{"ipset", "create", "foo", string(HashIP), "family", "inet",
-					"hashsize", "1024", "maxelem", "65536",
+					"hashsize", testDefaultHashSize,
+					"maxelem", testDefaultMaxElement,
 					"-o", "xml"},
 				{"ipset", "create", "foo", string(HashIP), "family", "inet",
-					"hashsize", "1024", "maxelem", "65536",
+					"hashsize", testDefaultHashSize,
+					"maxelem", testDefaultMaxElement,
 					"-exist", "-o", "xml"},
 			},
 		},
@@ -215,7 +220,7 @@ func TestHashIPCreateSet(t *testing.T) {
 func TestHashIPDestroySet(t *testing.T) {
 	cases := []struct {
 		name              string
-		setname           string
+		setname           SetName
 		combinedOutputLog [][]string
 	}{
 		{
@@ -373,7 +378,7 @@ func TestHashIPListSets(t *testing.T) {
 func TestHashIPListEntries(t *testing.T) {
 	cases := []struct {
 		name     string
-		setname  string
+		setname  SetName
 		output   []byte
 		expected []IPSetEntry
 	}{
@@ -507,7 +512,7 @@ func TestHashIPListEntries(t *testing.T) {
 func TestHashIPAddEntry(t *testing.T) {
 	cases := []struct {
 		name              string
-		setname           string
+		setname           SetName
 		entry             IPSetEntry
 		combinedOutputLog [][]string
 	}{
@@ -616,7 +621,7 @@ func TestHashIPAddEntry(t *testing.T) {
 func TestHashIPDelEntry(t *testing.T) {
 	cases := []struct {
 		name              string
-		setname           string
+		setname           SetName
 		entryElement      string
 		combinedOutputLog [][]string
 	}{
@@ -688,3 +693,95 @@ func TestHashIPDelEntry(t *testing.T) {
 		}
 	}
 }
+
+func TestHashIPNetmask(t *testing.T) {
+	cases := []struct {
+		name          string
+		set           *IPSet
+		expectedError bool
+	}{
+		{
+			name: "IPv4 netmask",
+			set:  IPSetSpec(IPSetName("foo"), IPSetNetmask(24)),
+		},
+		{
+			name: "IPv6 netmask",
+			set: IPSetSpec(IPSetName("foo"),
+				IPSetHashFamily(ProtocolFamilyIPv6), IPSetNetmask(64)),
+		},
+		{
+			name:          "IPv4 netmask out of range",
+			set:           IPSetSpec(IPSetName("foo"), IPSetNetmask(33)),
+			expectedError: true,
+		},
+		{
+			name: "Netmask of hash:net",
+			set: IPSetSpec(IPSetName("foo"), IPSetType(HashNet),
+				IPSetNetmask(24)),
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		err := c.set.Validate()
+		if c.expectedError && err == nil {
+			t.Errorf("[%s] expected failure, got: nil", c.name)
+		}
+
+		if !c.expectedError && err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+	}
+
+	fcmd := fakeexec.FakeCmd{
+		CombinedOutputScript: []fakeexec.FakeAction{
+			// Create
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			// Add
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+		},
+	}
+
+	fexec := fakeexec.FakeExec{
+		CommandScript: []fakeexec.FakeCommandAction{
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			},
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			},
+		},
+	}
+
+	runner := newInternal(&fexec, testHashIPIPSetLockfilePath)
+
+	err := runner.CreateSet(IPSetSpec(IPSetName("foo"), IPSetNetmask(24)),
+		false)
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	entry := &IPSetEntry{Element: "192.168.1.100"}
+
+	err = ValidateEntryForSet(entry, HashIP)
+	if err != nil {
+		t.Errorf("expected host address valid, got: %v", err)
+	}
+
+	err = runner.AddEntry(entry, "foo", false)
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	expected := [][]string{
+		{"ipset", "create", "foo", "hash:ip", "family", "inet",
+			"hashsize", testDefaultHashSize,
+			"maxelem", testDefaultMaxElement, "netmask", "24",
+			"-o", "xml"},
+		{"ipset", "add", "foo", "192.168.1.100", "-o", "xml"},
+	}
+	if !reflect.DeepEqual(fcmd.CombinedOutputLog, expected) {
+		t.Errorf("wrong CombinedOutput() log, got: %s",
+			fcmd.CombinedOutputLog)
+	}
+}
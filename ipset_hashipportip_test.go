@@ -0,0 +1,102 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/utils/exec"
+	fakeexec "k8s.io/utils/exec/testing"
+)
+
+func TestHashIPPortIPSetCycle(t *testing.T) {
+	fcmd := fakeexec.FakeCmd{
+		CombinedOutputScript: []fakeexec.FakeAction{
+			// Create
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			// Add
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			// List
+			func() ([]byte, []byte, error) {
+				return []byte(`
+				<ipsets>
+					<ipset name="foo">
+						<type>hash:ip,port,ip</type>
+						<revision>5</revision>
+						<header>
+							<family>inet</family>
+							<hashsize>1024</hashsize>
+							<maxelem>65536</maxelem>
+							<timeout>300</timeout>
+							<memsize>296</memsize>
+							<references>0</references>
+							<numentries>1</numentries>
+						</header>
+						<members>
+							<member>
+								<elem>1.1.1.1,tcp:80,2.2.2.2</elem>
+								<timeout>299</timeout>
+							</member>
+						</members>
+					</ipset>
+				</ipsets>
+				`), nil, nil
+			},
+		},
+	}
+
+	fexec := fakeexec.FakeExec{}
+	for range fcmd.CombinedOutputScript {
+		fexec.CommandScript = append(fexec.CommandScript,
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			})
+	}
+
+	runner := newInternal(&fexec, testIPSetLockfilePath)
+
+	err := runner.CreateSet(IPSetSpec(
+		IPSetName("foo"),
+		IPSetType(HashIPPortIP),
+		IPSetTimeout(300),
+	), false)
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	entry, err := IPPortIPEntry("1.1.1.1", "tcp:80", "2.2.2.2")
+	if err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+
+	err = runner.AddEntry(entry, "foo", false)
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	entries, err := runner.ListEntries("foo")
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	expectedLog := [][]string{
+		{"ipset", "create", "foo", "hash:ip,port,ip", "family", "inet",
+			"hashsize", testDefaultHashSize,
+			"maxelem", testDefaultMaxElement, "timeout", "300", "-o", "xml"},
+		{"ipset", "add", "foo", "1.1.1.1,tcp:80,2.2.2.2", "-o", "xml"},
+		{"ipset", "list", "foo", "-o", "xml"},
+	}
+	if !reflect.DeepEqual(fcmd.CombinedOutputLog, expectedLog) {
+		t.Errorf("wrong CombinedOutput() log, got: %s", fcmd.CombinedOutputLog)
+	}
+
+	expected := []IPSetEntry{
+		{Element: "1.1.1.1,tcp:80,2.2.2.2", Timeout: 299},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected entries: %+v, got: %+v", expected, entries)
+	}
+}
@@ -7,6 +7,8 @@ package ipset
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -15,7 +17,8 @@ import (
 	fakeexec "k8s.io/utils/exec/testing"
 )
 
-const testHashNetIPSetLockfilePath = "ipset.lock"
+var testHashNetIPSetLockfilePath = filepath.Join(os.TempDir(),
+	"go-ipset-test.lock")
 
 func TestHashNetIPSetSpec(t *testing.T) {
 	cases := []struct {
@@ -75,6 +78,33 @@ func TestHashNetIPSetSpec(t *testing.T) {
 			),
 			expectedError: fmt.Errorf("invalid Max Element value 0, should be >0"),
 		},
+		{
+			name: "Set with invalid bucket size specification",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetType(HashNet),
+				IPSetBucketSize(-1),
+			),
+			expectedError: fmt.Errorf("invalid Bucket Size value -1, should be >=0"),
+		},
+		{
+			name: "Set without family specification",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetType(HashNet),
+				IPSetHashFamily(""),
+			),
+			expectedError: nil,
+		},
+		{
+			name: "Set with invalid family specification",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetType(HashNet),
+				IPSetHashFamily("inet4"),
+			),
+			expectedError: fmt.Errorf("invalid Hash Family"),
+		},
 	}
 
 	for _, c := range cases {
@@ -98,11 +128,13 @@ func TestHashNetCreateSet(t *testing.T) {
 				IPSetType(HashNet),
 			),
 			combinedOutputLog: [][]string{
-				{"ipset", "create", "foo", string(HashNet), "family", "inet",
-					"hashsize", "1024", "maxelem", "65536",
+				{"ipset", "create", "foo", string(HashNet),
+					"hashsize", testDefaultHashSize,
+					"maxelem", testDefaultMaxElement,
 					"-o", "xml"},
-				{"ipset", "create", "foo", string(HashNet), "family", "inet",
-					"hashsize", "1024", "maxelem", "65536",
+				{"ipset", "create", "foo", string(HashNet),
+					"hashsize", testDefaultHashSize,
+					"maxelem", testDefaultMaxElement,
 					"-exist", "-o", "xml"},
 			},
 		},
@@ -115,10 +147,10 @@ func TestHashNetCreateSet(t *testing.T) {
 				IPSetMaxElement(128),
 			),
 			combinedOutputLog: [][]string{
-				{"ipset", "create", "foo", string(HashNet), "family", "inet",
+				{"ipset", "create", "foo", string(HashNet),
 					"hashsize", "256", "maxelem", "128",
 					"-o", "xml"},
-				{"ipset", "create", "foo", string(HashNet), "family", "inet",
+				{"ipset", "create", "foo", string(HashNet),
 					"hashsize", "256", "maxelem", "128",
 					"-exist", "-o", "xml"},
 			},
@@ -133,14 +165,50 @@ func TestHashNetCreateSet(t *testing.T) {
 				IPSetWithComment(),
 			),
 			combinedOutputLog: [][]string{
-				{"ipset", "create", "foo", string(HashNet), "family", "inet",
+				{"ipset", "create", "foo", string(HashNet),
 					"hashsize", "256", "maxelem", "128", "comment",
 					"-o", "xml"},
-				{"ipset", "create", "foo", string(HashNet), "family", "inet",
+				{"ipset", "create", "foo", string(HashNet),
 					"hashsize", "256", "maxelem", "128", "comment",
 					"-exist", "-o", "xml"},
 			},
 		},
+		{
+			name: "Create set foo hash:net with IPv6 family",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetType(HashNet),
+				IPSetHashFamily(ProtocolFamilyIPv6),
+			),
+			combinedOutputLog: [][]string{
+				{"ipset", "create", "foo", string(HashNet), "family", "inet6",
+					"hashsize", testDefaultHashSize,
+					"maxelem", testDefaultMaxElement,
+					"-o", "xml"},
+				{"ipset", "create", "foo", string(HashNet), "family", "inet6",
+					"hashsize", testDefaultHashSize,
+					"maxelem", testDefaultMaxElement,
+					"-exist", "-o", "xml"},
+			},
+		},
+		{
+			name: "Create set foo hash:net with bucket size option",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetType(HashNet),
+				IPSetHashSize(256),
+				IPSetMaxElement(128),
+				IPSetBucketSize(4),
+			),
+			combinedOutputLog: [][]string{
+				{"ipset", "create", "foo", string(HashNet),
+					"hashsize", "256", "maxelem", "128", "bucketsize", "4",
+					"-o", "xml"},
+				{"ipset", "create", "foo", string(HashNet),
+					"hashsize", "256", "maxelem", "128", "bucketsize", "4",
+					"-exist", "-o", "xml"},
+			},
+		},
 	}
 
 	for _, c := range cases {
@@ -215,7 +283,7 @@ func TestHashNetCreateSet(t *testing.T) {
 func TestHashNetDestroySet(t *testing.T) {
 	cases := []struct {
 		name              string
-		setname           string
+		setname           SetName
 		combinedOutputLog [][]string
 	}{
 		{
@@ -373,7 +441,7 @@ func TestHashNetListSets(t *testing.T) {
 func TestHashNetListEntries(t *testing.T) {
 	cases := []struct {
 		name     string
-		setname  string
+		setname  SetName
 		output   []byte
 		expected []IPSetEntry
 	}{
@@ -507,7 +575,7 @@ func TestHashNetListEntries(t *testing.T) {
 func TestHashNetAddEntry(t *testing.T) {
 	cases := []struct {
 		name              string
-		setname           string
+		setname           SetName
 		entry             IPSetEntry
 		combinedOutputLog [][]string
 	}{
@@ -616,7 +684,7 @@ func TestHashNetAddEntry(t *testing.T) {
 func TestHashNetDelEntry(t *testing.T) {
 	cases := []struct {
 		name              string
-		setname           string
+		setname           SetName
 		entryElement      string
 		combinedOutputLog [][]string
 	}{
@@ -0,0 +1,126 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/utils/exec"
+	fakeexec "k8s.io/utils/exec/testing"
+)
+
+func TestHashNetIfaceSetCycle(t *testing.T) {
+	fcmd := fakeexec.FakeCmd{
+		CombinedOutputScript: []fakeexec.FakeAction{
+			// Create
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			// Add
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			// Add
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			// Del
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			// List
+			func() ([]byte, []byte, error) {
+				return []byte(`
+				<ipsets>
+					<ipset name="foo">
+						<type>hash:net,iface</type>
+						<revision>7</revision>
+						<header>
+							<family>inet</family>
+							<hashsize>1024</hashsize>
+							<maxelem>65536</maxelem>
+							<memsize>1424</memsize>
+							<references>0</references>
+							<numentries>2</numentries>
+						</header>
+						<members>
+							<member>
+								<elem>10.0.0.0/24,eth0</elem>
+							</member>
+							<member>
+								<elem>10.0.1.1,eth0.100</elem>
+							</member>
+						</members>
+					</ipset>
+				</ipsets>
+				`), nil, nil
+			},
+		},
+	}
+
+	fexec := fakeexec.FakeExec{}
+	for range fcmd.CombinedOutputScript {
+		fexec.CommandScript = append(fexec.CommandScript,
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			})
+	}
+
+	runner := newInternal(&fexec, testIPSetLockfilePath)
+
+	err := runner.CreateSet(IPSetSpec(
+		IPSetName("foo"),
+		IPSetType(HashNetIface),
+	), false)
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	for _, element := range []string{
+		FormatNetIfaceElement("10.0.0.0/24", "eth0"),
+		FormatNetIfaceElement("10.0.1.1", "eth0.100"),
+	} {
+		err = runner.AddEntry(&IPSetEntry{Element: element}, "foo", false)
+		if err != nil {
+			t.Errorf("expected success, got: %v", err)
+		}
+	}
+
+	err = runner.DelEntry(FormatNetIfaceElement("10.0.2.0/24", "eth1"), "foo")
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	entries, err := runner.ListEntries("foo")
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	expectedLog := [][]string{
+		{"ipset", "create", "foo", "hash:net,iface", "family", "inet",
+			"hashsize", testDefaultHashSize,
+			"maxelem", testDefaultMaxElement, "-o", "xml"},
+		{"ipset", "add", "foo", "10.0.0.0/24,eth0", "-o", "xml"},
+		{"ipset", "add", "foo", "10.0.1.1,eth0.100", "-o", "xml"},
+		{"ipset", "del", "foo", "10.0.2.0/24,eth1", "-o", "xml"},
+		{"ipset", "list", "foo", "-o", "xml"},
+	}
+	if !reflect.DeepEqual(fcmd.CombinedOutputLog, expectedLog) {
+		t.Errorf("wrong CombinedOutput() log, got: %s", fcmd.CombinedOutputLog)
+	}
+
+	expected := [][2]string{
+		{"10.0.0.0/24", "eth0"},
+		{"10.0.1.1", "eth0.100"},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got: %+v", len(expected), entries)
+	}
+
+	for idx, entry := range entries {
+		cidr, iface, err := ParseNetIfaceElement(entry.Element)
+		if err != nil {
+			t.Errorf("expected success, got: %v", err)
+		}
+
+		if cidr != expected[idx][0] || iface != expected[idx][1] {
+			t.Errorf("expected %s %s, got: %s %s", expected[idx][0],
+				expected[idx][1], cidr, iface)
+		}
+	}
+}
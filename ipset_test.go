@@ -0,0 +1,4105 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"k8s.io/utils/exec"
+	fakeexec "k8s.io/utils/exec/testing"
+)
+
+var testIPSetLockfilePath = filepath.Join(os.TempDir(),
+	"go-ipset-test.lock")
+
+// withCachedSets enables the metadata cache holding the sets, so AddEntry
+// checks the entry against the cached set type without listing the set.
+func withCachedSets(sets ...*IPSet) RunnerOption {
+	return func(runner *runner) {
+		WithMetadataCache()(runner)
+
+		for _, set := range sets {
+			runner.cacheSet(set)
+		}
+	}
+}
+
+// testDefaultHashSize and testDefaultMaxElement are the IPSetSpec default
+// sizes as the create command arguments.
+var (
+	testDefaultHashSize   = strconv.Itoa(DefaultHashSize)
+	testDefaultMaxElement = strconv.Itoa(DefaultMaxElement)
+)
+
+func TestListAllEntries(t *testing.T) {
+	cases := []struct {
+		name          string
+		output        []byte
+		failed        bool
+		expected      map[string][]IPSetEntry
+		expectedError bool
+	}{
+		{
+			name: "foo and bar sets",
+			output: []byte(`
+			<ipsets>
+				<ipset name="foo">
+					<type>hash:ip</type>
+					<revision>4</revision>
+					<header>
+						<family>inet</family>
+						<hashsize>1024</hashsize>
+						<maxelem>65536</maxelem>
+						<comment/>
+						<memsize>472</memsize>
+						<references>0</references>
+						<numentries>2</numentries>
+					</header>
+					<members>
+						<member>
+							<elem>172.18.3.3</elem>
+							<comment>"ContainerID: deadbeafbeaf"</comment>
+						</member>
+						<member>
+							<elem>172.18.3.2</elem>
+							<comment>"ContainerID: deadbeaf"</comment>
+						</member>
+					</members>
+				</ipset>
+				<ipset name="bar">
+					<type>hash:net</type>
+					<revision>6</revision>
+					<header>
+						<family>inet</family>
+						<hashsize>1024</hashsize>
+						<maxelem>65536</maxelem>
+						<memsize>408</memsize>
+						<references>0</references>
+						<numentries>1</numentries>
+					</header>
+					<members>
+						<member>
+							<elem>172.18.4.0/24</elem>
+						</member>
+					</members>
+				</ipset>
+				<ipset name="baz">
+					<type>hash:net</type>
+					<revision>6</revision>
+					<header>
+						<family>inet</family>
+						<hashsize>1024</hashsize>
+						<maxelem>65536</maxelem>
+						<memsize>200</memsize>
+						<references>0</references>
+						<numentries>0</numentries>
+					</header>
+					<members>
+					</members>
+				</ipset>
+			</ipsets>
+			`),
+			expected: map[string][]IPSetEntry{
+				"foo": {
+					{Element: "172.18.3.3", Comment: "ContainerID: deadbeafbeaf"},
+					{Element: "172.18.3.2", Comment: "ContainerID: deadbeaf"},
+				},
+				"bar": {
+					{Element: "172.18.4.0/24"},
+				},
+				"baz": {},
+			},
+		},
+		{
+			name:     "empty sets",
+			output:   []byte(`<ipsets></ipsets>`),
+			expected: map[string][]IPSetEntry{},
+		},
+		{
+			name: "list failure",
+			output: []byte("ipset v7.6: Kernel error received: " +
+				"Operation not permitted"),
+			failed:        true,
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				func() ([]byte, []byte, error) {
+					if c.failed {
+						return []byte(c.output), nil,
+							&fakeexec.FakeExitError{Status: 1}
+					}
+
+					return []byte(c.output), nil, nil
+				},
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		all, err := runner.ListAllEntries()
+		if c.expectedError && err == nil {
+			t.Errorf("[%s] expected failure, got: nil", c.name)
+		}
+
+		if !c.expectedError && err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if fcmd.CombinedOutputCalls != 1 {
+			t.Errorf("[%s] expected 1 CombinedOutput() calls, got: %d",
+				c.name, fcmd.CombinedOutputCalls)
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog[0],
+			[]string{"ipset", "list", "-o", "xml"}) {
+			t.Errorf("wrong CombinedOutput() log, got: %s",
+				fcmd.CombinedOutputLog[0])
+		}
+
+		if !reflect.DeepEqual(all, c.expected) {
+			t.Errorf("[%s] expected entries: %v, got: %v", c.name, c.expected,
+				all)
+		}
+	}
+}
+
+func TestListEntriesSet(t *testing.T) {
+	fcmd := fakeexec.FakeCmd{
+		CombinedOutputScript: []fakeexec.FakeAction{
+			// Success
+			func() ([]byte, []byte, error) {
+				return []byte(testCommentListOutput), nil, nil
+			},
+			// Failure
+			func() ([]byte, []byte, error) {
+				return []byte("ipset v7.6: The set with the given name does not exist"), nil, &fakeexec.FakeExitError{Status: 1}
+			},
+		},
+	}
+
+	fexec := fakeexec.FakeExec{}
+	for range fcmd.CombinedOutputScript {
+		fexec.CommandScript = append(fexec.CommandScript,
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			})
+	}
+
+	runner := newInternal(&fexec, testIPSetLockfilePath)
+
+	entries, err := runner.ListEntriesSet("foo")
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	expected := map[string]IPSetEntry{
+		"172.18.3.2": {Element: "172.18.3.2", Comment: "ContainerID: deadbeaf"},
+		"172.18.3.3": {Element: "172.18.3.3", Comment: "ContainerID: cafebabe"},
+		"172.18.3.4": {Element: "172.18.3.4"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected entries: %+v, got: %+v", expected, entries)
+	}
+
+	_, err = runner.ListEntriesSet("bar")
+	if err == nil {
+		t.Errorf("expected failure, got: nil")
+	}
+}
+
+func TestDelEntryStruct(t *testing.T) {
+	cases := []struct {
+		name              string
+		setname           SetName
+		entry             *IPSetEntry
+		combinedOutputLog []string
+		expectedError     bool
+	}{
+		{
+			name:    "Delete hash:ip entry",
+			setname: "foo",
+			entry: &IPSetEntry{
+				Element: "172.18.3.2",
+				Comment: "ContainerID: deadbeaf",
+			},
+			combinedOutputLog: []string{
+				"ipset", "del", "foo", "172.18.3.2", "-o", "xml",
+			},
+		},
+		{
+			name:    "Delete entry with options",
+			setname: "foo",
+			entry: &IPSetEntry{
+				Element: "172.18.3.2",
+				Comment: "ContainerID: deadbeaf",
+				Timeout: 300,
+				Packets: 10,
+				Bytes:   840,
+			},
+			combinedOutputLog: []string{
+				"ipset", "del", "foo", "172.18.3.2", "-o", "xml",
+			},
+		},
+		{
+			name:    "Delete list:set entry with position",
+			setname: "baz",
+			entry: &IPSetEntry{
+				Element: "foo",
+				Comment: "first",
+				Before:  "bar",
+			},
+			combinedOutputLog: []string{
+				"ipset", "del", "baz", "foo", "-o", "xml",
+			},
+		},
+		{
+			name:    "Delete hash:net entry",
+			setname: "bar",
+			entry: &IPSetEntry{
+				Element: "172.18.3.0/24",
+			},
+			combinedOutputLog: []string{
+				"ipset", "del", "bar", "172.18.3.0/24", "-o", "xml",
+			},
+		},
+		{
+			name:          "Delete nil entry",
+			setname:       "foo",
+			entry:         nil,
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				// Success
+				func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		err := runner.DelEntryStruct(c.entry, c.setname)
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+
+			if fcmd.CombinedOutputCalls != 0 {
+				t.Errorf("[%s] expected 0 CombinedOutput() calls, got: %d",
+					c.name, fcmd.CombinedOutputCalls)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog[0], c.combinedOutputLog) {
+			t.Errorf("wrong CombinedOutput() log, got: %s",
+				fcmd.CombinedOutputLog[0])
+		}
+	}
+}
+
+func TestBuildEntryArgs(t *testing.T) {
+	cases := []struct {
+		name     string
+		entry    *IPSetEntry
+		expected []string
+	}{
+		{
+			name:     "Element only",
+			entry:    &IPSetEntry{Element: "172.18.3.2"},
+			expected: []string{"172.18.3.2"},
+		},
+		{
+			name: "Element with comment",
+			entry: &IPSetEntry{
+				Element: "172.18.3.2",
+				Comment: "ContainerID: deadbeaf",
+			},
+			expected: []string{"172.18.3.2", "comment", "ContainerID: deadbeaf"},
+		},
+		{
+			name:     "Element with nomatch",
+			entry:    &IPSetEntry{Element: "10.1.0.0/16", NoMatch: true},
+			expected: []string{"10.1.0.0/16", "nomatch"},
+		},
+		{
+			name: "Element with all options",
+			entry: &IPSetEntry{
+				Element: "172.18.3.0/24",
+				Comment: "ContainerID: deadbeaf",
+				Timeout: 300,
+				Packets: 10,
+				Bytes:   840,
+			},
+			expected: []string{
+				"172.18.3.0/24", "timeout", "300", "packets", "10",
+				"bytes", "840", "comment", "ContainerID: deadbeaf",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		args := buildEntryArgs(c.entry)
+		if !reflect.DeepEqual(args, c.expected) {
+			t.Errorf("[%s] expected args: %v, got: %v", c.name, c.expected,
+				args)
+		}
+	}
+}
+
+func TestCmdArgsBuilder(t *testing.T) {
+	cases := []struct {
+		name     string
+		args     []string
+		expected []string
+	}{
+		{
+			name:     "list command",
+			args:     []string{"list", "foo"},
+			expected: []string{"list", "foo", "-o", "xml"},
+		},
+		{
+			name:     "save command",
+			args:     []string{"save", "foo"},
+			expected: []string{"save", "foo"},
+		},
+		{
+			name:     "restore command",
+			args:     []string{"restore", "-exist"},
+			expected: []string{"restore", "-exist"},
+		},
+		{
+			name:     "version command",
+			args:     []string{"version"},
+			expected: []string{"version"},
+		},
+	}
+
+	for _, c := range cases {
+		args := cmdArgsBuilder(c.args, defaultMandatoryArgs())
+		if !reflect.DeepEqual(args, c.expected) {
+			t.Errorf("[%s] expected args: %v, got: %v", c.name, c.expected,
+				args)
+		}
+
+		exported := CmdArgsBuilder(c.args)
+		if !reflect.DeepEqual(exported, c.expected) {
+			t.Errorf("[%s] expected exported args: %v, got: %v", c.name,
+				c.expected, exported)
+		}
+	}
+}
+
+func TestCmdArgsBuilderCopy(t *testing.T) {
+	cases := []struct {
+		name string
+		args []string
+	}{
+		{
+			name: "list command",
+			args: []string{"list", "foo"},
+		},
+		{
+			name: "save command",
+			args: []string{"save", "foo"},
+		},
+	}
+
+	for _, c := range cases {
+		// The spare capacity would be written to by the in-place append.
+		backing := make([]string, len(c.args), len(c.args)+4)
+		copy(backing, c.args)
+		spare := backing[:cap(backing)]
+
+		args := CmdArgsBuilder(backing)
+		if len(backing) != len(c.args) {
+			t.Errorf("[%s] expected args length %d, got: %d", c.name,
+				len(c.args), len(backing))
+		}
+
+		for _, arg := range spare[len(c.args):] {
+			if arg != "" {
+				t.Errorf("[%s] expected args backing array unchanged, got: %v",
+					c.name, spare)
+				break
+			}
+		}
+
+		args[0] = "changed"
+		if backing[0] != c.args[0] {
+			t.Errorf("[%s] expected args copy, got args changed: %v", c.name,
+				backing)
+		}
+	}
+
+	args := CmdArgsBuilder([]string{"add", "foo", "172.18.3.2", "-exist"})
+	tail := args[len(args)-len(defaultMandatoryArgs()):]
+	if !reflect.DeepEqual(tail, defaultMandatoryArgs()) {
+		t.Errorf("expected mandatory args at the end, got: %v", args)
+	}
+}
+
+func TestAddEntryTypeCheck(t *testing.T) {
+	header := func() ([]byte, []byte, error) {
+		return []byte(`<ipsets><ipset name="foo"><type>hash:ip</type>` +
+			`<header><family>inet</family></header></ipset></ipsets>`), nil, nil
+	}
+	failure := func() ([]byte, []byte, error) {
+		return []byte("ipset v7.6: Syntax error: resolving to IPv4 address " +
+			"failed to parse not-an-ip"), nil, &fakeexec.FakeExitError{Status: 1}
+	}
+
+	cases := []struct {
+		name               string
+		opts               []RunnerOption
+		script             []fakeexec.FakeAction
+		expectedCmds       []string
+		expectedEntryError bool
+	}{
+		{
+			name: "Cached set type",
+			opts: []RunnerOption{
+				withCachedSets(IPSetSpec(IPSetName("foo"))),
+			},
+			expectedEntryError: true,
+		},
+		{
+			name:         "Set not cached is not listed",
+			script:       []fakeexec.FakeAction{failure},
+			expectedCmds: []string{"add"},
+		},
+		{
+			name:               "Set not cached is listed for family check",
+			opts:               []RunnerOption{WithFamilyCheck()},
+			script:             []fakeexec.FakeAction{header},
+			expectedCmds:       []string{"list"},
+			expectedEntryError: true,
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{CombinedOutputScript: c.script}
+
+		fexec := fakeexec.FakeExec{}
+		for range c.script {
+			fexec.CommandScript = append(fexec.CommandScript,
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				})
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath, c.opts...)
+
+		err := runner.AddEntry(&IPSetEntry{Element: "not-an-ip"}, "foo", false)
+		if err == nil {
+			t.Errorf("[%s] expected failure, got: nil", c.name)
+		}
+
+		var entryErr *EntryError
+		if c.expectedEntryError &&
+			(!errors.As(err, &entryErr) || entryErr.Field != "ip") {
+			t.Errorf("[%s] expected ip entry error, got: %v", c.name, err)
+		}
+
+		if fexec.CommandCalls != len(c.expectedCmds) {
+			t.Errorf("[%s] expected %d Command() calls, got: %d", c.name,
+				len(c.expectedCmds), fexec.CommandCalls)
+			continue
+		}
+
+		for i, cmd := range c.expectedCmds {
+			if fcmd.CombinedOutputLog[i][1] != cmd {
+				t.Errorf("[%s] expected command: %s, got: %s", c.name, cmd,
+					fcmd.CombinedOutputLog[i])
+			}
+		}
+	}
+}
+
+func TestAddEntryInvalid(t *testing.T) {
+	cases := []struct {
+		name  string
+		entry IPSetEntry
+	}{
+		{
+			name:  "Add empty element",
+			entry: IPSetEntry{Element: ""},
+		},
+		{
+			name:  "Add element with whitespace",
+			entry: IPSetEntry{Element: "172.18.3.2 172.18.3.3"},
+		},
+		{
+			name:  "Add element with malformed skbmark",
+			entry: IPSetEntry{Element: "172.18.3.2", SkbMark: "0x1/mask"},
+		},
+	}
+
+	for _, c := range cases {
+		fexec := fakeexec.FakeExec{}
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		err := runner.AddEntry(&c.entry, "foo", false)
+		if err == nil {
+			t.Errorf("[%s] expected failure, got: nil", c.name)
+		}
+
+		if fexec.CommandCalls != 0 {
+			t.Errorf("[%s] expected 0 Command() calls, got: %d", c.name,
+				fexec.CommandCalls)
+		}
+	}
+}
+
+func TestCreateSet(t *testing.T) {
+	cases := []struct {
+		name              string
+		set               *IPSet
+		combinedOutputLog []string
+		expectedError     bool
+	}{
+		{
+			name: "Create set foo hash:ip requires family",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetType(HashIP),
+			),
+			combinedOutputLog: []string{
+				"ipset", "create", "foo", string(HashIP), "family", "inet",
+				"hashsize", testDefaultHashSize,
+				"maxelem", testDefaultMaxElement, "-o", "xml",
+			},
+		},
+		{
+			name: "Create set foo hash:net omits default family",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetType(HashNet),
+			),
+			combinedOutputLog: []string{
+				"ipset", "create", "foo", string(HashNet),
+				"hashsize", testDefaultHashSize,
+				"maxelem", testDefaultMaxElement, "-o", "xml",
+			},
+		},
+		{
+			name: "Create set foo hash:net emits non-default family",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetType(HashNet),
+				IPSetHashFamily(ProtocolFamilyIPv6),
+			),
+			combinedOutputLog: []string{
+				"ipset", "create", "foo", string(HashNet), "family", "inet6",
+				"hashsize", testDefaultHashSize,
+				"maxelem", testDefaultMaxElement, "-o", "xml",
+			},
+		},
+		{
+			name: "Create set foo list:set",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetType(ListSet),
+			),
+			combinedOutputLog: []string{
+				"ipset", "create", "foo", string(ListSet), "-o", "xml",
+			},
+		},
+		{
+			name: "Create set foo hash:ip,port without family",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetType(HashIPPort),
+				IPSetHashFamily(""),
+			),
+			expectedError: true,
+		},
+		{
+			name: "Create set foo hash:ip,port",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetType(HashIPPort),
+			),
+			combinedOutputLog: []string{
+				"ipset", "create", "foo", string(HashIPPort), "family", "inet",
+				"hashsize", testDefaultHashSize,
+				"maxelem", testDefaultMaxElement, "-o", "xml",
+			},
+		},
+		{
+			name: "Create set foo hash:mac",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetType(HashMAC),
+			),
+			combinedOutputLog: []string{
+				"ipset", "create", "foo", string(HashMAC),
+				"hashsize", testDefaultHashSize,
+				"maxelem", testDefaultMaxElement, "-o", "xml",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				// Success
+				func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		err := runner.CreateSet(c.set, false)
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog[0], c.combinedOutputLog) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog[0])
+		}
+	}
+}
+
+func TestTestEntry(t *testing.T) {
+	fcmd := fakeexec.FakeCmd{
+		CombinedOutputScript: []fakeexec.FakeAction{
+			// Success
+			func() ([]byte, []byte, error) {
+				return []byte("172.18.3.2 is in set foo."), nil, nil
+			},
+			// Not found
+			func() ([]byte, []byte, error) {
+				return []byte("ipset v7.6: 172.18.3.3 is NOT in set foo."), nil, &fakeexec.FakeExitError{Status: 1}
+			},
+			// Failure
+			func() ([]byte, []byte, error) {
+				return []byte("ipset v7.6: The set with the given name does not exist"), nil, &fakeexec.FakeExitError{Status: 1}
+			},
+		},
+	}
+
+	fexec := fakeexec.FakeExec{
+		CommandScript: []fakeexec.FakeCommandAction{
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			},
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			},
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			},
+		},
+	}
+
+	runner := newInternal(&fexec, testIPSetLockfilePath)
+
+	found, err := runner.TestEntry("172.18.3.2", "foo")
+	if err != nil || !found {
+		t.Errorf("expected found, got: %v, error: %v", found, err)
+	}
+
+	if !reflect.DeepEqual(fcmd.CombinedOutputLog[0],
+		[]string{"ipset", "test", "foo", "172.18.3.2", "-o", "xml"}) {
+		t.Errorf("wrong CombinedOutput() log, got: %s",
+			fcmd.CombinedOutputLog[0])
+	}
+
+	found, err = runner.TestEntry("172.18.3.3", "foo")
+	if err != nil || found {
+		t.Errorf("expected not found, got: %v, error: %v", found, err)
+	}
+
+	_, err = runner.TestEntry("172.18.3.2", "bar")
+	if err == nil {
+		t.Errorf("expected failure, got: nil")
+	}
+}
+
+func TestHasEntry(t *testing.T) {
+	fcmd := fakeexec.FakeCmd{
+		CombinedOutputScript: []fakeexec.FakeAction{
+			// Success
+			func() ([]byte, []byte, error) {
+				return []byte("172.18.3.2 is in set foo."), nil, nil
+			},
+			// Not found
+			func() ([]byte, []byte, error) {
+				return []byte("ipset v7.6: 172.18.3.3 is NOT in set foo."), nil, &fakeexec.FakeExitError{Status: 1}
+			},
+			// Failure
+			func() ([]byte, []byte, error) {
+				return []byte("ipset v7.6: The set with the given name does not exist"), nil, &fakeexec.FakeExitError{Status: 1}
+			},
+		},
+	}
+
+	fexec := fakeexec.FakeExec{}
+	for range fcmd.CombinedOutputScript {
+		fexec.CommandScript = append(fexec.CommandScript,
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			})
+	}
+
+	logged := []error{}
+	runner := newInternal(&fexec, testIPSetLockfilePath,
+		WithErrorLogger(func(err error) { logged = append(logged, err) }))
+
+	if !runner.HasEntry("172.18.3.2", "foo") {
+		t.Errorf("expected found, got: not found")
+	}
+
+	if runner.HasEntry("172.18.3.3", "foo") {
+		t.Errorf("expected not found, got: found")
+	}
+
+	if runner.HasEntry("172.18.3.2", "bar") {
+		t.Errorf("expected not found of failure, got: found")
+	}
+
+	if fcmd.CombinedOutputCalls != 3 {
+		t.Errorf("expected 3 CombinedOutput() calls, got: %d",
+			fcmd.CombinedOutputCalls)
+	}
+
+	if len(logged) != 1 ||
+		!strings.Contains(logged[0].Error(), "error testing") {
+		t.Errorf("expected the failure logged, got: %v", logged)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	success := func() ([]byte, []byte, error) { return []byte{}, nil, nil }
+	failure := func() ([]byte, []byte, error) {
+		return []byte("ipset v7.6: Kernel error received: Operation not permitted"), nil, &fakeexec.FakeExitError{Status: 1}
+	}
+
+	cases := []struct {
+		name           string
+		script         []fakeexec.FakeAction
+		expectedCmds   []string
+		expectedFailed bool
+	}{
+		{
+			name:         "Self-test succeeded",
+			script:       []fakeexec.FakeAction{success, success, success, success},
+			expectedCmds: []string{"create", "add", "test", "destroy"},
+		},
+		{
+			name:           "Self-test failed on create",
+			script:         []fakeexec.FakeAction{failure},
+			expectedCmds:   []string{"create"},
+			expectedFailed: true,
+		},
+		{
+			name:           "Self-test failed on add, set is destroyed",
+			script:         []fakeexec.FakeAction{success, failure, success},
+			expectedCmds:   []string{"create", "add", "destroy"},
+			expectedFailed: true,
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: c.script,
+		}
+
+		fexec := fakeexec.FakeExec{}
+		for range c.script {
+			fexec.CommandScript = append(fexec.CommandScript,
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				})
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		err := runner.SelfTest()
+		if c.expectedFailed && err == nil {
+			t.Errorf("[%s] expected failure, got: nil", c.name)
+		}
+
+		if !c.expectedFailed && err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if fcmd.CombinedOutputCalls != len(c.expectedCmds) {
+			t.Errorf("[%s] expected %d CombinedOutput() calls, got: %d",
+				c.name, len(c.expectedCmds), fcmd.CombinedOutputCalls)
+			continue
+		}
+
+		setname := fcmd.CombinedOutputLog[0][2]
+		for idx, cmd := range c.expectedCmds {
+			log := fcmd.CombinedOutputLog[idx]
+			if log[1] != cmd || log[2] != setname {
+				t.Errorf("[%s] expected %s %s command, got: %s", c.name, cmd,
+					setname, log)
+			}
+		}
+	}
+}
+
+func TestTypeSupported(t *testing.T) {
+	success := func() ([]byte, []byte, error) { return []byte{}, nil, nil }
+
+	cases := []struct {
+		name          string
+		setType       Type
+		script        []fakeexec.FakeAction
+		expected      bool
+		expectedError bool
+		expectedArgs  []string
+	}{
+		{
+			name:     "Supported type",
+			setType:  HashNet,
+			script:   []fakeexec.FakeAction{success, success},
+			expected: true,
+		},
+		{
+			name:         "Supported bitmap:port",
+			setType:      BitmapPort,
+			script:       []fakeexec.FakeAction{success, success},
+			expected:     true,
+			expectedArgs: []string{"range", "0-1"},
+		},
+		{
+			name:    "Kernel module is not loaded",
+			setType: HashMAC,
+			script: []fakeexec.FakeAction{
+				func() ([]byte, []byte, error) {
+					return []byte("ipset v6.29: Kernel error received: set type not supported"), nil, &fakeexec.FakeExitError{Status: 1}
+				},
+			},
+			expected: false,
+		},
+		{
+			name:    "Unknown type",
+			setType: "hash:foo",
+			script: []fakeexec.FakeAction{
+				func() ([]byte, []byte, error) {
+					return []byte("ipset v7.6: Syntax error: typename 'hash:foo' is unknown"), nil, &fakeexec.FakeExitError{Status: 2}
+				},
+			},
+			expected: false,
+		},
+		{
+			name:    "Permission denied",
+			setType: HashIP,
+			script: []fakeexec.FakeAction{
+				func() ([]byte, []byte, error) {
+					return []byte("ipset v7.6: Kernel error received: Operation not permitted"), nil, &fakeexec.FakeExitError{Status: 1}
+				},
+			},
+			expected:      false,
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: c.script,
+		}
+
+		fexec := fakeexec.FakeExec{}
+		for range c.script {
+			fexec.CommandScript = append(fexec.CommandScript,
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				})
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		supported, err := runner.TypeSupported(c.setType)
+		if c.expectedError && err == nil {
+			t.Errorf("[%s] expected failure, got: nil", c.name)
+		}
+
+		if !c.expectedError && err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if supported != c.expected {
+			t.Errorf("[%s] expected supported: %v, got: %v", c.name,
+				c.expected, supported)
+		}
+
+		if fcmd.CombinedOutputCalls != len(c.script) {
+			t.Errorf("[%s] expected %d CombinedOutput() calls, got: %d",
+				c.name, len(c.script), fcmd.CombinedOutputCalls)
+		}
+
+		args := fcmd.CombinedOutputLog[0][4 : 4+len(c.expectedArgs)]
+		if fcmd.CombinedOutputLog[0][3] != string(c.setType) ||
+			strings.Join(args, " ") != strings.Join(c.expectedArgs, " ") {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog[0])
+		}
+	}
+}
+
+func TestWithMandatoryArgs(t *testing.T) {
+	cases := []struct {
+		name          string
+		opts          []RunnerOption
+		mandatoryArgs []string
+	}{
+		{
+			name:          "Default mandatory args",
+			mandatoryArgs: []string{"-o", "xml"},
+		},
+		{
+			name:          "Custom mandatory args",
+			opts:          []RunnerOption{WithMandatoryArgs([]string{"-o", "plain"})},
+			mandatoryArgs: []string{"-o", "plain"},
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				// Success
+				func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+				// Success
+				func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+				// Success
+				func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath, c.opts...)
+
+		runner.CreateSet(IPSetSpec(IPSetName("foo")), false)
+		runner.AddEntry(&IPSetEntry{Element: "172.18.3.2"}, "foo", false)
+		runner.DestroySet("foo")
+
+		if fcmd.CombinedOutputCalls != 3 {
+			t.Errorf("[%s] expected 3 CombinedOutput() calls, got: %d",
+				c.name, fcmd.CombinedOutputCalls)
+		}
+
+		for _, log := range fcmd.CombinedOutputLog {
+			tail := log[len(log)-len(c.mandatoryArgs):]
+			if !reflect.DeepEqual(tail, c.mandatoryArgs) {
+				t.Errorf("[%s] expected mandatory args %v, got: %s", c.name,
+					c.mandatoryArgs, log)
+			}
+		}
+	}
+}
+
+func TestWithSudo(t *testing.T) {
+	fcmd := fakeexec.FakeCmd{
+		CombinedOutputScript: []fakeexec.FakeAction{
+			// Success
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			// Success
+			func() ([]byte, []byte, error) {
+				return []byte("ipset v7.6, protocol version: 7\n"), nil, nil
+			},
+		},
+	}
+
+	fexec := fakeexec.FakeExec{}
+	for range fcmd.CombinedOutputScript {
+		fexec.CommandScript = append(fexec.CommandScript,
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			})
+	}
+
+	runner := newInternal(&fexec, testIPSetLockfilePath, WithSudo())
+
+	err := runner.CreateSet(IPSetSpec(IPSetName("foo")), false)
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	_, err = runner.GetVersion()
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	expected := [][]string{
+		{"sudo", "ipset", "create", "foo", "hash:ip", "family", "inet",
+			"hashsize", testDefaultHashSize,
+			"maxelem", testDefaultMaxElement, "-o", "xml"},
+		{"sudo", "ipset", "version"},
+	}
+	if !reflect.DeepEqual(fcmd.CombinedOutputLog, expected) {
+		t.Errorf("wrong CombinedOutput() log, got: %s", fcmd.CombinedOutputLog)
+	}
+}
+
+func TestWithQuiet(t *testing.T) {
+	fcmd := fakeexec.FakeCmd{
+		CombinedOutputScript: []fakeexec.FakeAction{
+			// Success
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			// Success
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			// Success
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+		},
+	}
+
+	fexec := fakeexec.FakeExec{}
+	for range fcmd.CombinedOutputScript {
+		fexec.CommandScript = append(fexec.CommandScript,
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			})
+	}
+
+	runner := newInternal(&fexec, testIPSetLockfilePath, WithQuiet())
+
+	err := runner.CreateSet(IPSetSpec(IPSetName("foo")), true)
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	err = runner.AddEntry(&IPSetEntry{Element: "172.18.3.2"}, "foo", true)
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	err = runner.AddEntry(&IPSetEntry{Element: "172.18.3.3"}, "foo", false)
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	expected := [][]string{
+		{"ipset", "create", "foo", "hash:ip", "family", "inet",
+			"hashsize", testDefaultHashSize,
+			"maxelem", testDefaultMaxElement, "-exist", "-q", "-o", "xml"},
+		{"ipset", "add", "foo", "172.18.3.2", "-exist", "-q", "-o", "xml"},
+		{"ipset", "add", "foo", "172.18.3.3", "-o", "xml"},
+	}
+	if !reflect.DeepEqual(fcmd.CombinedOutputLog, expected) {
+		t.Errorf("wrong CombinedOutput() log, got: %s", fcmd.CombinedOutputLog)
+	}
+}
+
+func TestWithDefaultFamily(t *testing.T) {
+	cases := []struct {
+		name     string
+		set      *IPSet
+		expected []string
+	}{
+		{
+			name: "Spec default family",
+			set:  IPSetSpec(IPSetName("foo")),
+			expected: []string{"ipset", "create", "foo", "hash:ip",
+				"family", "inet6", "hashsize", testDefaultHashSize,
+				"maxelem", testDefaultMaxElement, "-o", "xml"},
+		},
+		{
+			name: "Spec explicit default family",
+			set: IPSetSpec(IPSetName("foo"),
+				IPSetHashFamily(DefaultFamily)),
+			expected: []string{"ipset", "create", "foo", "hash:ip",
+				"family", "inet6", "hashsize", testDefaultHashSize,
+				"maxelem", testDefaultMaxElement, "-o", "xml"},
+		},
+		{
+			name: "Empty family",
+			set: &IPSet{Name: "foo", SetType: HashNet, HashSize: 1024,
+				MaxElement: 65536},
+			expected: []string{"ipset", "create", "foo", "hash:net",
+				"family", "inet6", "hashsize", testDefaultHashSize,
+				"maxelem", testDefaultMaxElement, "-o", "xml"},
+		},
+		{
+			name: "Without family",
+			set: IPSetSpec(IPSetName("foo"), IPSetType(BitmapPort),
+				IPSetRange("1024-65535")),
+			expected: []string{"ipset", "create", "foo", "bitmap:port",
+				"range", "1024-65535", "-o", "xml"},
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath,
+			WithDefaultFamily(ProtocolFamilyIPv6))
+
+		family := c.set.HashFamily
+
+		err := runner.CreateSet(c.set, false)
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog,
+			[][]string{c.expected}) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog)
+		}
+
+		if c.set.HashFamily != family {
+			t.Errorf("[%s] expected set family unchanged %s, got: %s",
+				c.name, family, c.set.HashFamily)
+		}
+	}
+}
+
+func TestWithDefaultFamilyCache(t *testing.T) {
+	cases := []struct {
+		name   string
+		create func(runner Interface, set *IPSet) error
+	}{
+		{
+			name: "CreateSet",
+			create: func(runner Interface, set *IPSet) error {
+				return runner.CreateSet(set, false)
+			},
+		},
+		{
+			name: "CreateSets",
+			create: func(runner Interface, set *IPSet) error {
+				return runner.CreateSets([]*IPSet{set}, false)
+			},
+		},
+		{
+			name: "CreateSetAndAddEntries",
+			create: func(runner Interface, set *IPSet) error {
+				return runner.CreateSetAndAddEntries(set, nil, false)
+			},
+		},
+		{
+			name: "ApplyBatch",
+			create: func(runner Interface, set *IPSet) error {
+				return runner.ApplyBatch([]*IPSet{set}, nil)
+			},
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		r := newInternal(&fexec, testIPSetLockfilePath,
+			WithDefaultFamily(ProtocolFamilyIPv6), WithMetadataCache())
+
+		err := c.create(r, IPSetSpec(IPSetName("foo")))
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		cached := r.(*runner).cache["foo"]
+		if cached.HashFamily != ProtocolFamilyIPv6 {
+			t.Errorf("[%s] expected cached family %s, got: %s", c.name,
+				ProtocolFamilyIPv6, cached.HashFamily)
+		}
+	}
+}
+
+func TestWithCommandLogger(t *testing.T) {
+	fcmd := fakeexec.FakeCmd{
+		CombinedOutputScript: []fakeexec.FakeAction{
+			// Success
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			// Failure
+			func() ([]byte, []byte, error) {
+				return []byte("ipset v7.6: The set with the given name does not exist"),
+					nil, &fakeexec.FakeExitError{Status: 1}
+			},
+		},
+	}
+
+	fexec := fakeexec.FakeExec{}
+	for range fcmd.CombinedOutputScript {
+		fexec.CommandScript = append(fexec.CommandScript,
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			})
+	}
+
+	logged := [][]string{}
+	runner := newInternal(&fexec, testIPSetLockfilePath,
+		WithCommandLogger(func(args []string) {
+			logged = append(logged, args)
+		}))
+
+	err := runner.AddEntry(&IPSetEntry{Element: "172.18.3.2",
+		Comment: "ContainerID: deadbeaf"}, "foo", false)
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	err = runner.DestroySet("bar")
+	if err == nil {
+		t.Errorf("expected failure, got: nil")
+	}
+
+	if !reflect.DeepEqual(logged, fcmd.CombinedOutputLog) {
+		t.Errorf("expected logged commands: %s, got: %s",
+			fcmd.CombinedOutputLog, logged)
+	}
+}
+
+func TestCommentRoundTrip(t *testing.T) {
+	cases := []struct {
+		name    string
+		comment string
+	}{
+		{name: "Comment with spaces", comment: "ContainerID  deadbeaf"},
+		{name: "Comment with leading space", comment: " ContainerID"},
+		{name: "Comment with trailing space", comment: "ContainerID "},
+		{name: "Comment with colons", comment: "pod:ns:deadbeaf"},
+		{name: "Comment with unicode", comment: "คอนเทนเนอร์ ✓"},
+		{name: "Comment with quotes", comment: `"quoted"`},
+	}
+
+	for _, c := range cases {
+		var listOutput []byte
+
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				// Success
+				func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+				// Success
+				func() ([]byte, []byte, error) { return listOutput, nil, nil },
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		err := runner.AddEntry(&IPSetEntry{
+			Element: "172.18.3.2",
+			Comment: c.comment,
+		}, "foo", false)
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		added := fcmd.CombinedOutputLog[0]
+		if added[len(added)-3] != c.comment {
+			t.Errorf("[%s] expected comment arg %q, got: %q", c.name,
+				c.comment, added[len(added)-3])
+		}
+
+		var escaped strings.Builder
+		xml.EscapeText(&escaped, []byte(`"`+c.comment+`"`))
+
+		listOutput = []byte(`
+			<ipsets>
+				<ipset name="foo">
+					<type>hash:ip</type>
+					<members>
+						<member>
+							<elem>172.18.3.2</elem>
+							<comment>` + escaped.String() + `</comment>
+						</member>
+					</members>
+				</ipset>
+			</ipsets>
+			`)
+
+		entries, err := runner.ListEntries("foo")
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if len(entries) != 1 || entries[0].Comment != c.comment {
+			t.Errorf("[%s] expected comment %q, got: %+v", c.name, c.comment,
+				entries)
+		}
+	}
+}
+
+func TestClearEntries(t *testing.T) {
+	cases := []struct {
+		name           string
+		clear          func(runner Interface, setname SetName) error
+		ignoreNotFound bool
+	}{
+		{
+			name: "ClearEntries",
+			clear: func(runner Interface, setname SetName) error {
+				return runner.ClearEntries(setname)
+			},
+		},
+		{
+			name: "FlushSet",
+			clear: func(runner Interface, setname SetName) error {
+				return runner.FlushSet(setname)
+			},
+		},
+		{
+			name: "FlushSetIfExists",
+			clear: func(runner Interface, setname SetName) error {
+				return runner.FlushSetIfExists(setname)
+			},
+			ignoreNotFound: true,
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				// Success
+				func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+				// Failure
+				func() ([]byte, []byte, error) {
+					return []byte("ipset v7.6: The set with the given name does not exist"), nil, &fakeexec.FakeExitError{Status: 1}
+				},
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		err := c.clear(runner, "foo")
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog[0],
+			[]string{"ipset", "flush", "foo", "-o", "xml"}) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog[0])
+		}
+
+		err = c.clear(runner, "foo")
+		if c.ignoreNotFound {
+			if err != nil {
+				t.Errorf("[%s] expected success, got: %v", c.name, err)
+			}
+
+			continue
+		}
+
+		if !errors.Is(err, ErrSetNotFound) {
+			t.Errorf("[%s] expected ErrSetNotFound, got: %v", c.name, err)
+		}
+	}
+}
+
+func TestAddEntryListSet(t *testing.T) {
+	cases := []struct {
+		name              string
+		entry             IPSetEntry
+		combinedOutputLog []string
+		expectedError     bool
+	}{
+		{
+			name:  "Add member",
+			entry: IPSetEntry{Element: "foo"},
+			combinedOutputLog: []string{
+				"ipset", "add", "policy", "foo", "-o", "xml",
+			},
+		},
+		{
+			name:  "Add member before another member",
+			entry: IPSetEntry{Element: "foo", Before: "bar"},
+			combinedOutputLog: []string{
+				"ipset", "add", "policy", "foo", "before", "bar", "-o", "xml",
+			},
+		},
+		{
+			name:  "Add member after another member",
+			entry: IPSetEntry{Element: "foo", After: "bar"},
+			combinedOutputLog: []string{
+				"ipset", "add", "policy", "foo", "after", "bar", "-o", "xml",
+			},
+		},
+		{
+			name:          "Add member both before and after",
+			entry:         IPSetEntry{Element: "foo", Before: "bar", After: "baz"},
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				// Success
+				func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		err := runner.AddEntry(&c.entry, "policy", false)
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog[0], c.combinedOutputLog) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog[0])
+		}
+	}
+}
+
+// syncFakeExec serializes the fake commands creation for the concurrent
+// callers.
+type syncFakeExec struct {
+	mu sync.Mutex
+	fakeexec.FakeExec
+}
+
+func (f *syncFakeExec) Command(cmd string, args ...string) exec.Cmd {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.FakeExec.Command(cmd, args...)
+}
+
+func TestTestEntries(t *testing.T) {
+	cases := []struct {
+		name          string
+		elements      []string
+		members       map[string]bool
+		broken        string
+		concurrency   int
+		expected      map[string]bool
+		expectedError bool
+	}{
+		{
+			name:     "All entries in set",
+			elements: []string{"172.18.3.2", "172.18.3.3"},
+			members:  map[string]bool{"172.18.3.2": true, "172.18.3.3": true},
+			expected: map[string]bool{"172.18.3.2": true, "172.18.3.3": true},
+		},
+		{
+			name: "Some entries in set",
+			elements: []string{"172.18.3.2", "172.18.3.3", "172.18.3.4",
+				"172.18.3.5", "172.18.3.6"},
+			members: map[string]bool{"172.18.3.3": true, "172.18.3.5": true},
+			expected: map[string]bool{
+				"172.18.3.2": false,
+				"172.18.3.3": true,
+				"172.18.3.4": false,
+				"172.18.3.5": true,
+				"172.18.3.6": false,
+			},
+		},
+		{
+			name:        "Some entries in set, single test at a time",
+			elements:    []string{"172.18.3.2", "172.18.3.3"},
+			members:     map[string]bool{"172.18.3.3": true},
+			concurrency: 1,
+			expected:    map[string]bool{"172.18.3.2": false, "172.18.3.3": true},
+		},
+		{
+			name:          "Exec error",
+			elements:      []string{"172.18.3.2", "172.18.3.3", "172.18.3.4"},
+			members:       map[string]bool{"172.18.3.2": true},
+			broken:        "172.18.3.3",
+			concurrency:   1,
+			expectedError: true,
+		},
+		{
+			name:     "No entries",
+			elements: []string{},
+			expected: map[string]bool{},
+		},
+	}
+
+	for _, c := range cases {
+		fexec := &syncFakeExec{}
+		for range c.elements {
+			fexec.CommandScript = append(fexec.CommandScript,
+				func(cmd string, args ...string) exec.Cmd {
+					element := args[2]
+					fcmd := &fakeexec.FakeCmd{
+						CombinedOutputScript: []fakeexec.FakeAction{
+							func() ([]byte, []byte, error) {
+								if element == c.broken {
+									return []byte("ipset v7.6: Kernel error received: Operation not permitted"), nil, &fakeexec.FakeExitError{Status: 1}
+								}
+
+								if c.members[element] {
+									return []byte{}, nil, nil
+								}
+
+								return []byte("ipset v7.6: " + element + " is NOT in set foo."), nil, &fakeexec.FakeExitError{Status: 1}
+							},
+						},
+					}
+
+					return fakeexec.InitFakeCmd(fcmd, cmd, args...)
+				})
+		}
+
+		opts := []RunnerOption{}
+		if c.concurrency > 0 {
+			opts = append(opts, WithTestConcurrency(c.concurrency))
+		}
+
+		runner := newInternal(fexec, testIPSetLockfilePath, opts...)
+
+		results, err := runner.TestEntries(c.elements, "foo")
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+
+			if fexec.CommandCalls == len(c.elements) {
+				t.Errorf("[%s] expected tests to stop on failure, got: %d "+
+					"Command() calls", c.name, fexec.CommandCalls)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !reflect.DeepEqual(results, c.expected) {
+			t.Errorf("[%s] expected results: %v, got: %v", c.name, c.expected,
+				results)
+		}
+	}
+}
+
+func TestResizeSet(t *testing.T) {
+	listOutput := []byte(`
+	<ipsets>
+		<ipset name="foo">
+			<type>hash:ip</type>
+			<revision>4</revision>
+			<header>
+				<family>inet</family>
+				<hashsize>1024</hashsize>
+				<maxelem>65536</maxelem>
+				<comment/>
+				<memsize>472</memsize>
+				<references>0</references>
+				<numentries>2</numentries>
+			</header>
+			<members>
+				<member>
+					<elem>172.18.3.3</elem>
+					<comment>"ContainerID: deadbeafbeaf"</comment>
+				</member>
+				<member>
+					<elem>172.18.3.2</elem>
+					<comment>"ContainerID: deadbeaf"</comment>
+				</member>
+			</members>
+		</ipset>
+	</ipsets>
+	`)
+	list := func() ([]byte, []byte, error) { return listOutput, nil, nil }
+	success := func() ([]byte, []byte, error) { return []byte{}, nil, nil }
+	failure := func() ([]byte, []byte, error) {
+		return []byte("ipset v7.6: Hash is full, cannot add more elements"), nil, &fakeexec.FakeExitError{Status: 1}
+	}
+
+	cases := []struct {
+		name           string
+		script         []fakeexec.FakeAction
+		expectedCmds   []string
+		expectedFailed bool
+	}{
+		{
+			name: "Resize set",
+			script: []fakeexec.FakeAction{list, success, success, success,
+				success, success},
+			expectedCmds: []string{"list", "create", "add", "add", "swap",
+				"destroy"},
+		},
+		{
+			name:           "Resize set failed on add, temporary set is destroyed",
+			script:         []fakeexec.FakeAction{list, success, failure, success},
+			expectedCmds:   []string{"list", "create", "add", "destroy"},
+			expectedFailed: true,
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: c.script,
+		}
+
+		fexec := fakeexec.FakeExec{}
+		for range c.script {
+			fexec.CommandScript = append(fexec.CommandScript,
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				})
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		err := runner.ResizeSet("foo", 4096, 262144)
+		if c.expectedFailed && err == nil {
+			t.Errorf("[%s] expected failure, got: nil", c.name)
+		}
+
+		if !c.expectedFailed && err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if fcmd.CombinedOutputCalls != len(c.expectedCmds) {
+			t.Errorf("[%s] expected %d CombinedOutput() calls, got: %d",
+				c.name, len(c.expectedCmds), fcmd.CombinedOutputCalls)
+			continue
+		}
+
+		for idx, cmd := range c.expectedCmds {
+			if fcmd.CombinedOutputLog[idx][1] != cmd {
+				t.Errorf("[%s] expected %s command, got: %s", c.name, cmd,
+					fcmd.CombinedOutputLog[idx])
+			}
+		}
+
+		tempname := fcmd.CombinedOutputLog[1][2]
+		created := fcmd.CombinedOutputLog[1]
+		expectedCreate := []string{
+			"ipset", "create", tempname, string(HashIP), "family", "inet",
+			"hashsize", "4096", "maxelem", "262144", "comment", "-o", "xml",
+		}
+		if !reflect.DeepEqual(created, expectedCreate) {
+			t.Errorf("[%s] expected create command %s, got: %s", c.name,
+				expectedCreate, created)
+		}
+
+		added := fcmd.CombinedOutputLog[2]
+		expectedAdd := []string{
+			"ipset", "add", tempname, "172.18.3.3",
+			"comment", "ContainerID: deadbeafbeaf", "-o", "xml",
+		}
+		if !reflect.DeepEqual(added, expectedAdd) {
+			t.Errorf("[%s] expected add command %s, got: %s", c.name,
+				expectedAdd, added)
+		}
+
+		if !c.expectedFailed {
+			swapped := fcmd.CombinedOutputLog[4]
+			if !reflect.DeepEqual(swapped[2:4], []string{"foo", tempname}) {
+				t.Errorf("[%s] expected swap foo with %s, got: %s", c.name,
+					tempname, swapped)
+			}
+		}
+
+		destroyed := fcmd.CombinedOutputLog[len(c.expectedCmds)-1]
+		if destroyed[2] != tempname {
+			t.Errorf("[%s] expected destroy %s, got: %s", c.name, tempname,
+				destroyed)
+		}
+	}
+}
+
+// fakeStreamCmd is the fake command whose stdout is read through StdoutPipe,
+// the stderr is written when the command is waited.
+type fakeStreamCmd struct {
+	*fakeexec.FakeCmd
+	stderr []byte
+}
+
+func (cmd *fakeStreamCmd) Wait() error {
+	if cmd.Stderr != nil {
+		cmd.Stderr.Write(cmd.stderr)
+	}
+
+	return cmd.FakeCmd.Wait()
+}
+
+// fakeStreamCommand returns the command action of the fake command streaming
+// the stdout and the stderr, the command exits with err.
+func fakeStreamCommand(fcmd *fakeexec.FakeCmd, stdout, stderr []byte,
+	err error) fakeexec.FakeCommandAction {
+	return func(cmd string, args ...string) exec.Cmd {
+		fakeexec.InitFakeCmd(fcmd, cmd, args...)
+		fcmd.StdoutPipeResponse = fakeexec.FakeStdIOPipeResponse{
+			ReadCloser: ioutil.NopCloser(bytes.NewReader(stdout)),
+		}
+		fcmd.WaitResponse = err
+
+		return &fakeStreamCmd{FakeCmd: fcmd, stderr: stderr}
+	}
+}
+
+func TestIterateEntries(t *testing.T) {
+	listOutput := []byte(`
+	<ipsets>
+		<ipset name="foo">
+			<type>hash:net</type>
+			<revision>6</revision>
+			<header>
+				<family>inet</family>
+				<hashsize>1024</hashsize>
+				<maxelem>65536</maxelem>
+				<comment/>
+				<memsize>472</memsize>
+				<references>0</references>
+				<numentries>3</numentries>
+			</header>
+			<members>
+				<member>
+					<elem>172.18.3.0/24</elem>
+					<comment>"ContainerID: deadbeafbeaf"</comment>
+				</member>
+				<member>
+					<elem>172.18.4.0/24</elem>
+					<comment>"ContainerID: deadbeaf"</comment>
+				</member>
+				<member>
+					<elem>172.18.5.2</elem>
+				</member>
+			</members>
+		</ipset>
+	</ipsets>
+	`)
+
+	stopErr := fmt.Errorf("stop")
+
+	cases := []struct {
+		name          string
+		stdout        []byte
+		stderr        []byte
+		exitErr       error
+		stopAt        int
+		expected      []IPSetEntry
+		expectedError error
+	}{
+		{
+			name:   "Iterate all entries",
+			stdout: listOutput,
+			expected: []IPSetEntry{
+				{Element: "172.18.3.0/24", Comment: "ContainerID: deadbeafbeaf"},
+				{Element: "172.18.4.0/24", Comment: "ContainerID: deadbeaf"},
+				{Element: "172.18.5.2"},
+			},
+		},
+		{
+			name:   "Warning kept out of the output",
+			stdout: listOutput,
+			stderr: []byte("ipset v7.6: Warning: the set is being resized"),
+			expected: []IPSetEntry{
+				{Element: "172.18.3.0/24", Comment: "ContainerID: deadbeafbeaf"},
+				{Element: "172.18.4.0/24", Comment: "ContainerID: deadbeaf"},
+				{Element: "172.18.5.2"},
+			},
+		},
+		{
+			name:   "Iteration stopped by fn error",
+			stdout: listOutput,
+			stopAt: 2,
+			expected: []IPSetEntry{
+				{Element: "172.18.3.0/24", Comment: "ContainerID: deadbeafbeaf"},
+				{Element: "172.18.4.0/24", Comment: "ContainerID: deadbeaf"},
+			},
+			expectedError: stopErr,
+		},
+		{
+			name:          "Subprocess error",
+			stderr:        []byte("ipset v7.6: The set with the given name does not exist"),
+			exitErr:       &fakeexec.FakeExitError{Status: 1},
+			expected:      []IPSetEntry{},
+			expectedError: fmt.Errorf("error listing set foo"),
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{}
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				fakeStreamCommand(&fcmd, c.stdout, c.stderr, c.exitErr),
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		entries := []IPSetEntry{}
+		err := runner.IterateEntries("foo", func(entry IPSetEntry) error {
+			entries = append(entries, entry)
+			if len(entries) == c.stopAt {
+				return stopErr
+			}
+
+			return nil
+		})
+
+		if c.expectedError == nil && err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if c.expectedError != nil && (err == nil ||
+			!strings.HasPrefix(err.Error(), c.expectedError.Error())) {
+			t.Errorf("[%s] expected error: %v, got: %v", c.name,
+				c.expectedError, err)
+		}
+
+		if !reflect.DeepEqual(entries, c.expected) {
+			t.Errorf("[%s] expected entries: %v, got: %v", c.name, c.expected,
+				entries)
+		}
+	}
+}
+
+func TestListEntriesHashNetNet(t *testing.T) {
+	fcmd := fakeexec.FakeCmd{
+		CombinedOutputScript: []fakeexec.FakeAction{
+			// Success
+			func() ([]byte, []byte, error) {
+				return []byte(`
+				<ipsets>
+					<ipset name="foo">
+						<type>hash:net,net</type>
+						<revision>2</revision>
+						<header>
+							<family>inet</family>
+							<hashsize>1024</hashsize>
+							<maxelem>65536</maxelem>
+							<memsize>408</memsize>
+							<references>0</references>
+							<numentries>2</numentries>
+						</header>
+						<members>
+							<member>
+								<elem>10.0.0.0/24,192.168.0.0/16</elem>
+							</member>
+							<member>
+								<elem>10.0.1.0/24,172.16.0.0/12</elem>
+								<nomatch/>
+							</member>
+						</members>
+					</ipset>
+				</ipsets>
+				`), nil, nil
+			},
+		},
+	}
+
+	fexec := fakeexec.FakeExec{
+		CommandScript: []fakeexec.FakeCommandAction{
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			},
+		},
+	}
+
+	runner := newInternal(&fexec, testIPSetLockfilePath)
+
+	entries, err := runner.ListEntries("foo")
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	expected := []IPSetEntry{
+		{Element: "10.0.0.0/24,192.168.0.0/16"},
+		{Element: "10.0.1.0/24,172.16.0.0/12", NoMatch: true},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected entries: %v, got: %v", expected, entries)
+	}
+}
+
+func TestListEntriesUnexpectedMember(t *testing.T) {
+	output := []byte(`
+	<ipsets>
+		<ipset name="foo">
+			<type>hash:ip,port</type>
+			<revision>5</revision>
+			<header>
+				<family>inet</family>
+				<hashsize>1024</hashsize>
+				<maxelem>65536</maxelem>
+				<memsize>344</memsize>
+				<references>0</references>
+				<numentries>3</numentries>
+			</header>
+			<members>
+				<member>
+					<elem>192.168.1.1,tcp:80</elem>
+				</member>
+				<member>
+					<elem>192.168.1.1,gre:0</elem>
+				</member>
+				<member>
+					<elem>192.168.1.1,unknown:80</elem>
+				</member>
+			</members>
+		</ipset>
+	</ipsets>
+	`)
+
+	fcmd := fakeexec.FakeCmd{
+		CombinedOutputScript: []fakeexec.FakeAction{
+			func() ([]byte, []byte, error) { return output, nil, nil },
+		},
+	}
+
+	fexec := fakeexec.FakeExec{
+		CommandScript: []fakeexec.FakeCommandAction{
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			},
+			fakeStreamCommand(&fcmd, output, nil, nil),
+		},
+	}
+
+	runner := newInternal(&fexec, testIPSetLockfilePath)
+
+	expected := []IPSetEntry{
+		{Element: "192.168.1.1,tcp:80"},
+		{Element: "192.168.1.1,gre:0"},
+		{Element: "192.168.1.1,unknown:80"},
+	}
+
+	entries, err := runner.ListEntries("foo")
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected entries: %v, got: %v", expected, entries)
+	}
+
+	iterated := []IPSetEntry{}
+	err = runner.IterateEntries("foo", func(entry IPSetEntry) error {
+		iterated = append(iterated, entry)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	if !reflect.DeepEqual(iterated, expected) {
+		t.Errorf("expected iterated entries: %v, got: %v", expected, iterated)
+	}
+}
+
+func TestGetSetHeader(t *testing.T) {
+	cases := []struct {
+		name          string
+		output        []byte
+		expected      *IPSetHeader
+		expectedError bool
+	}{
+		{
+			name: "foo set header",
+			output: []byte(`
+			<ipsets>
+				<ipset name="foo">
+					<type>hash:net</type>
+					<revision>6</revision>
+					<header>
+						<family>inet6</family>
+						<hashsize>4096</hashsize>
+						<maxelem>262144</maxelem>
+						<bucketsize>12</bucketsize>
+						<timeout>300</timeout>
+						<counters/>
+						<comment/>
+						<memsize>1048</memsize>
+						<references>1</references>
+						<numentries>2</numentries>
+					</header>
+					<members>
+						<member>
+							<elem>2001:db8::/64</elem>
+						</member>
+					</members>
+				</ipset>
+			</ipsets>
+			`),
+			expected: &IPSetHeader{
+				Name:         "foo",
+				SetType:      HashNet,
+				HashFamily:   ProtocolFamilyIPv6,
+				HashSize:     4096,
+				MaxElement:   262144,
+				BucketSize:   12,
+				Timeout:      300,
+				WithCounters: true,
+				WithComment:  true,
+				MemSize:      1048,
+				References:   1,
+				NumEntries:   2,
+			},
+		},
+		{
+			name: "members are not parsed",
+			output: []byte(`
+			<ipsets>
+				<ipset name="foo">
+					<type>hash:ip</type>
+					<header>
+						<family>inet</family>
+						<hashsize>1024</hashsize>
+						<maxelem>65536</maxelem>
+						<numentries>1</numentries>
+					</header>
+					<members>
+						<member><elem>broken
+			`),
+			expected: &IPSetHeader{
+				Name:       "foo",
+				SetType:    HashIP,
+				HashFamily: ProtocolFamilyIPv4,
+				HashSize:   1024,
+				MaxElement: 65536,
+				NumEntries: 1,
+			},
+		},
+		{
+			name:          "header not found",
+			output:        []byte(`<ipsets></ipsets>`),
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				// Success
+				func() ([]byte, []byte, error) {
+					return []byte(c.output), nil, nil
+				},
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		header, err := runner.GetSetHeader("foo")
+
+		expectedCmd := []string{"ipset", "list", "foo", "-t", "-o", "xml"}
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog[0], expectedCmd) {
+			t.Errorf("[%s] expected command: %v, got: %v", c.name, expectedCmd,
+				fcmd.CombinedOutputLog[0])
+		}
+
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !reflect.DeepEqual(header, c.expected) {
+			t.Errorf("[%s] expected header: %+v, got: %+v", c.name, c.expected,
+				header)
+		}
+	}
+}
+
+func TestJSONMarshal(t *testing.T) {
+	set := IPSetSpec(
+		IPSetName("foo"),
+		IPSetType(HashIP),
+		IPSetWithComment(),
+	)
+	set.Entries = []IPSetEntry{
+		{Element: "172.18.3.2", Comment: "ContainerID: deadbeaf"},
+		{Element: "172.18.3.3", Timeout: 300},
+	}
+
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+
+	expected := `{"name":"foo","type":"hash:ip","family":"inet",` +
+		`"hashsize":1024,"maxelem":65536,"comment":true,"entries":[` +
+		`{"element":"172.18.3.2","comment":"ContainerID: deadbeaf"},` +
+		`{"element":"172.18.3.3","timeout":300}]}`
+	if string(data) != expected {
+		t.Errorf("expected JSON: %s, got: %s", expected, data)
+	}
+
+	var decoded IPSet
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+
+	if !reflect.DeepEqual(&decoded, set) {
+		t.Errorf("expected decoded set: %+v, got: %+v", set, decoded)
+	}
+}
+
+func TestRenameSet(t *testing.T) {
+	cases := []struct {
+		name          string
+		oldName       SetName
+		newName       SetName
+		expectedError error
+		invalid       bool
+	}{
+		{
+			name:    "Rename foo to bar",
+			oldName: "foo",
+			newName: "bar",
+		},
+		{
+			name:    "Empty old name",
+			oldName: "",
+			newName: "bar",
+			invalid: true,
+		},
+		{
+			name:    "Too long new name",
+			oldName: "foo",
+			newName: "a-very-long-set-name-over-31-char",
+			invalid: true,
+		},
+		{
+			name:    "New name with whitespace",
+			oldName: "foo",
+			newName: "foo bar",
+			invalid: true,
+		},
+		{
+			name:    "Old name looks like an option",
+			oldName: "-exist",
+			newName: "foo",
+			invalid: true,
+		},
+		{
+			name:          "Same names",
+			oldName:       "foo",
+			newName:       "foo",
+			expectedError: ErrSameSetName,
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				// Success
+				func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		err := runner.RenameSet(c.oldName, c.newName)
+		if c.invalid || c.expectedError != nil {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+
+			if c.expectedError != nil && !errors.Is(err, c.expectedError) {
+				t.Errorf("[%s] expected error: %v, got: %v", c.name,
+					c.expectedError, err)
+			}
+
+			if fexec.CommandCalls != 0 {
+				t.Errorf("[%s] expected 0 Command() calls, got: %d", c.name,
+					fexec.CommandCalls)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog[0],
+			[]string{"ipset", "rename", string(c.oldName), string(c.newName),
+				"-o", "xml"}) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog[0])
+		}
+	}
+}
+
+func TestListSetsMatching(t *testing.T) {
+	output := []byte(`
+	<ipsets>
+		<ipset name="myctl-foo"/>
+		<ipset name="other-bar"/>
+		<ipset name="myctl-baz"/>
+	</ipsets>
+	`)
+
+	cases := []struct {
+		name     string
+		list     func(runner Interface) ([]string, error)
+		expected []string
+	}{
+		{
+			name: "Sets matching prefix",
+			list: func(runner Interface) ([]string, error) {
+				return runner.ListSetsMatching("myctl-")
+			},
+			expected: []string{"myctl-foo", "myctl-baz"},
+		},
+		{
+			name: "No sets matching prefix",
+			list: func(runner Interface) ([]string, error) {
+				return runner.ListSetsMatching("none-")
+			},
+			expected: []string{},
+		},
+		{
+			name: "Sets matching predicate",
+			list: func(runner Interface) ([]string, error) {
+				return runner.ListSetsFunc(func(setname string) bool {
+					return strings.HasSuffix(setname, "bar")
+				})
+			},
+			expected: []string{"other-bar"},
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				// Success
+				func() ([]byte, []byte, error) { return output, nil, nil },
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		list, err := c.list(runner)
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !reflect.DeepEqual(list, c.expected) {
+			t.Errorf("[%s] expected sets: %v, got: %v", c.name, c.expected,
+				list)
+		}
+	}
+}
+
+func TestListEntriesResolved(t *testing.T) {
+	fcmd := fakeexec.FakeCmd{
+		CombinedOutputScript: []fakeexec.FakeAction{
+			// Success
+			func() ([]byte, []byte, error) {
+				return []byte(`
+				<ipsets>
+					<ipset name="foo">
+						<type>hash:ip</type>
+						<revision>4</revision>
+						<header>
+							<family>inet</family>
+							<hashsize>1024</hashsize>
+							<maxelem>65536</maxelem>
+							<comment/>
+							<memsize>472</memsize>
+							<references>0</references>
+							<numentries>2</numentries>
+						</header>
+						<members>
+							<member>
+								<elem>localhost</elem>
+								<comment>"ContainerID: deadbeaf"</comment>
+							</member>
+							<member>
+								<elem>172.18.3.3</elem>
+							</member>
+						</members>
+					</ipset>
+				</ipsets>
+				`), nil, nil
+			},
+		},
+	}
+
+	fexec := fakeexec.FakeExec{
+		CommandScript: []fakeexec.FakeCommandAction{
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			},
+		},
+	}
+
+	runner := newInternal(&fexec, testIPSetLockfilePath)
+
+	entries, err := runner.ListEntriesResolved("foo")
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	if !reflect.DeepEqual(fcmd.CombinedOutputLog[0],
+		[]string{"ipset", "list", "foo", "-resolve", "-o", "xml"}) {
+		t.Errorf("wrong CombinedOutput() log, got: %s",
+			fcmd.CombinedOutputLog[0])
+	}
+
+	expected := []IPSetEntry{
+		{Element: "localhost", Comment: "ContainerID: deadbeaf",
+			ResolvedName: "localhost"},
+		{Element: "172.18.3.3"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected entries: %+v, got: %+v", expected, entries)
+	}
+}
+
+func TestDestroySetInUse(t *testing.T) {
+	cases := []struct {
+		name          string
+		output        string
+		expectedError error
+	}{
+		{
+			name: "Set in use",
+			output: "ipset v7.6: Set cannot be destroyed: it is in use by a " +
+				"kernel component",
+			expectedError: ErrSetInUse,
+		},
+		{
+			name:   "Set does not exist",
+			output: "ipset v7.6: The set with the given name does not exist",
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				// Failure
+				func() ([]byte, []byte, error) {
+					return []byte(c.output), nil,
+						&fakeexec.FakeExitError{Status: 1}
+				},
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		err := runner.DestroySet("foo")
+		if err == nil {
+			t.Errorf("[%s] expected failure, got: nil", c.name)
+		}
+
+		if errors.Is(err, ErrSetInUse) != (c.expectedError != nil) {
+			t.Errorf("[%s] expected error: %v, got: %v", c.name,
+				c.expectedError, err)
+		}
+	}
+}
+
+func TestListEntriesSorted(t *testing.T) {
+	listOutput := `
+	<ipsets>
+		<ipset name="foo">
+			<type>hash:ip</type>
+			<revision>4</revision>
+			<header>
+				<family>inet</family>
+				<hashsize>1024</hashsize>
+				<maxelem>65536</maxelem>
+				<memsize>472</memsize>
+				<references>0</references>
+				<numentries>2</numentries>
+			</header>
+			<members>
+				<member>
+					<elem>172.18.3.10</elem>
+				</member>
+				<member>
+					<elem>172.18.3.2</elem>
+				</member>
+			</members>
+		</ipset>
+	</ipsets>
+	`
+
+	cases := []struct {
+		name              string
+		version           string
+		versionFailed     bool
+		combinedOutputLog [][]string
+		expected          []IPSetEntry
+	}{
+		{
+			name:    "Sorted by ipset",
+			version: "ipset v7.6, protocol version: 7",
+			combinedOutputLog: [][]string{
+				{"ipset", "version"},
+				{"ipset", "list", "foo", "-sorted", "-o", "xml"},
+				{"ipset", "list", "foo", "-sorted", "-o", "xml"},
+			},
+			// The fake output is as is, the kernel would sort it.
+			expected: []IPSetEntry{
+				{Element: "172.18.3.10"},
+				{Element: "172.18.3.2"},
+			},
+		},
+		{
+			name:    "Sorted by SortEntries for old ipset",
+			version: "ipset v6.20, protocol version: 6",
+			combinedOutputLog: [][]string{
+				{"ipset", "version"},
+				{"ipset", "list", "foo", "-o", "xml"},
+				{"ipset", "list", "foo", "-o", "xml"},
+			},
+			expected: []IPSetEntry{
+				{Element: "172.18.3.2"},
+				{Element: "172.18.3.10"},
+			},
+		},
+		{
+			name:          "Sorted by SortEntries for unknown version",
+			version:       "ipset: invalid option -- 'version'",
+			versionFailed: true,
+			combinedOutputLog: [][]string{
+				{"ipset", "version"},
+				{"ipset", "list", "foo", "-o", "xml"},
+				{"ipset", "version"},
+				{"ipset", "list", "foo", "-o", "xml"},
+			},
+			expected: []IPSetEntry{
+				{Element: "172.18.3.2"},
+				{Element: "172.18.3.10"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		version := func() ([]byte, []byte, error) {
+			if c.versionFailed {
+				return []byte(c.version), nil, &fakeexec.FakeExitError{Status: 1}
+			}
+
+			return []byte(c.version), nil, nil
+		}
+		list := func() ([]byte, []byte, error) {
+			return []byte(listOutput), nil, nil
+		}
+
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{version, list, list},
+		}
+		if c.versionFailed {
+			fcmd.CombinedOutputScript = []fakeexec.FakeAction{
+				version, list, version, list,
+			}
+		}
+
+		fexec := fakeexec.FakeExec{}
+		for range fcmd.CombinedOutputScript {
+			fexec.CommandScript = append(fexec.CommandScript,
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				})
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		for i := 0; i < 2; i++ {
+			entries, err := runner.ListEntriesSorted("foo")
+			if err != nil {
+				t.Errorf("[%s] expected success, got: %v", c.name, err)
+			}
+
+			if !reflect.DeepEqual(entries, c.expected) {
+				t.Errorf("[%s] expected entries: %+v, got: %+v", c.name,
+					c.expected, entries)
+			}
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog, c.combinedOutputLog) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog)
+		}
+	}
+}
+
+func TestSortEntries(t *testing.T) {
+	cases := []struct {
+		name     string
+		entries  []IPSetEntry
+		expected []IPSetEntry
+	}{
+		{
+			name: "IPv4 addresses sorted numerically",
+			entries: []IPSetEntry{
+				{Element: "172.18.3.3"},
+				{Element: "10.0.0.10"},
+				{Element: "10.0.0.2", Comment: "first"},
+				{Element: "10.0.0.2", Comment: "second"},
+			},
+			expected: []IPSetEntry{
+				{Element: "10.0.0.2", Comment: "first"},
+				{Element: "10.0.0.2", Comment: "second"},
+				{Element: "10.0.0.10"},
+				{Element: "172.18.3.3"},
+			},
+		},
+		{
+			name: "Networks, IPv6 and ports",
+			entries: []IPSetEntry{
+				{Element: "2001:db8::10"},
+				{Element: "10.0.0.0/24"},
+				{Element: "2001:db8::2,tcp:80"},
+				{Element: "10.0.0.0/16"},
+				{Element: "9.0.0.0/8"},
+				{Element: "2001:db8::2,tcp:443"},
+			},
+			expected: []IPSetEntry{
+				{Element: "9.0.0.0/8"},
+				{Element: "10.0.0.0/16"},
+				{Element: "10.0.0.0/24"},
+				{Element: "2001:db8::2,tcp:443"},
+				{Element: "2001:db8::2,tcp:80"},
+				{Element: "2001:db8::10"},
+			},
+		},
+		{
+			name: "Non IP elements after IP elements",
+			entries: []IPSetEntry{
+				{Element: "foo"},
+				{Element: "00:11:22:33:44:55"},
+				{Element: "10.0.0.1"},
+			},
+			expected: []IPSetEntry{
+				{Element: "10.0.0.1"},
+				{Element: "00:11:22:33:44:55"},
+				{Element: "foo"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		SortEntries(c.entries)
+
+		if !reflect.DeepEqual(c.entries, c.expected) {
+			t.Errorf("[%s] expected entries: %+v, got: %+v", c.name,
+				c.expected, c.entries)
+		}
+	}
+}
+
+func TestAddEntryFamilyCheck(t *testing.T) {
+	cases := []struct {
+		name     string
+		setType  Type
+		family   string
+		element  string
+		mismatch bool
+	}{
+		{
+			name:    "IPv4 address in inet set",
+			setType: HashIP,
+			family:  ProtocolFamilyIPv4,
+			element: "172.18.3.2",
+		},
+		{
+			name:     "IPv6 address in inet set",
+			setType:  HashIP,
+			family:   ProtocolFamilyIPv4,
+			element:  "2001:db8::1",
+			mismatch: true,
+		},
+		{
+			name:     "IPv4 network in inet6 set",
+			setType:  HashNet,
+			family:   ProtocolFamilyIPv6,
+			element:  "172.18.3.0/24",
+			mismatch: true,
+		},
+		{
+			name:    "IPv6 address with port in inet6 set",
+			setType: HashIPPort,
+			family:  ProtocolFamilyIPv6,
+			element: "2001:db8::1,tcp:80",
+		},
+		{
+			name:    "MAC address in set without family",
+			setType: HashMAC,
+			element: "00:11:22:33:44:55",
+		},
+	}
+
+	for _, c := range cases {
+		header := fmt.Sprintf(`
+			<ipsets>
+				<ipset name="foo">
+					<type>%s</type>
+					<header>
+						<family>%s</family>
+						<hashsize>1024</hashsize>
+						<maxelem>65536</maxelem>
+					</header>
+					<members>
+					</members>
+				</ipset>
+			</ipsets>
+			`, c.setType, c.family)
+
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				// Header
+				func() ([]byte, []byte, error) { return []byte(header), nil, nil },
+				// Success
+				func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			},
+		}
+
+		fexec := fakeexec.FakeExec{}
+		for range fcmd.CombinedOutputScript {
+			fexec.CommandScript = append(fexec.CommandScript,
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				})
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath, WithFamilyCheck())
+
+		err := runner.AddEntry(&IPSetEntry{Element: c.element}, "foo", false)
+		if c.mismatch {
+			var entryErr *EntryError
+			if !errors.As(err, &entryErr) || entryErr.Field != "family" {
+				t.Errorf("[%s] expected family error, got: %v", c.name, err)
+			}
+
+			if fexec.CommandCalls != 1 {
+				t.Errorf("[%s] expected 1 Command() calls, got: %d", c.name,
+					fexec.CommandCalls)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog[1],
+			[]string{"ipset", "add", "foo", c.element, "-o", "xml"}) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog[1])
+		}
+	}
+}
+
+func TestListSetsNameOnly(t *testing.T) {
+	cases := []struct {
+		name     string
+		output   string
+		expected []string
+	}{
+		{
+			name:     "Set names",
+			output:   "foo\nbar\n",
+			expected: []string{"foo", "bar"},
+		},
+		{
+			name:     "Empty output",
+			output:   "",
+			expected: []string{},
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				// Success
+				func() ([]byte, []byte, error) {
+					return []byte(c.output), nil, nil
+				},
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		list, err := runner.ListSetsNameOnly()
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog[0],
+			[]string{"ipset", "list", "-n"}) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog[0])
+		}
+
+		if !reflect.DeepEqual(list, c.expected) {
+			t.Errorf("[%s] expected set names: %v, got: %v", c.name,
+				c.expected, list)
+		}
+	}
+}
+
+// benchmarkListSets runs the list function against the fixed output of 1000
+// set names.
+func benchmarkListSets(b *testing.B, output []byte,
+	list func(runner Interface) ([]string, error)) {
+	fcmd := fakeexec.FakeCmd{}
+	fexec := fakeexec.FakeExec{}
+	for i := 0; i < b.N; i++ {
+		fcmd.CombinedOutputScript = append(fcmd.CombinedOutputScript,
+			func() ([]byte, []byte, error) { return output, nil, nil })
+		fexec.CommandScript = append(fexec.CommandScript,
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			})
+	}
+
+	runner := newInternal(&fexec, testIPSetLockfilePath)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := list(runner); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkListSets(b *testing.B) {
+	var output strings.Builder
+	output.WriteString("<ipsets>\n")
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&output, "<ipset name=\"set%d\">\n</ipset>\n", i)
+	}
+	output.WriteString("</ipsets>\n")
+
+	benchmarkListSets(b, []byte(output.String()), Interface.ListSets)
+}
+
+func BenchmarkListSetsNameOnly(b *testing.B) {
+	var output strings.Builder
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&output, "set%d\n", i)
+	}
+
+	benchmarkListSets(b, []byte(output.String()), Interface.ListSetsNameOnly)
+}
+
+func TestIPSetAutoHashSize(t *testing.T) {
+	cases := []struct {
+		name     string
+		set      *IPSet
+		expected int
+	}{
+		{
+			name:     "Default max elements",
+			set:      IPSetSpec(IPSetName("foo"), IPSetAutoHashSize()),
+			expected: 32768,
+		},
+		{
+			name: "Max elements of non power of two",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetMaxElement(3000),
+				IPSetAutoHashSize(),
+			),
+			expected: 2048,
+		},
+		{
+			name: "Small max elements clamped",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetAutoHashSize(),
+				IPSetMaxElement(10),
+			),
+			expected: 64,
+		},
+		{
+			name: "Large max elements clamped",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetAutoHashSize(),
+				IPSetMaxElement(1<<24),
+			),
+			expected: 1 << 20,
+		},
+		{
+			name: "Explicit hash size is authoritative",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetAutoHashSize(),
+				IPSetHashSize(256),
+				IPSetMaxElement(1<<24),
+			),
+			expected: 256,
+		},
+		{
+			name: "Explicit default hash size before auto hash size",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetHashSize(DefaultHashSize),
+				IPSetAutoHashSize(),
+				IPSetMaxElement(1<<24),
+			),
+			expected: DefaultHashSize,
+		},
+		{
+			name:     "Without auto hash size",
+			set:      IPSetSpec(IPSetName("foo"), IPSetMaxElement(1<<24)),
+			expected: 1024,
+		},
+	}
+
+	for _, c := range cases {
+		if c.set.HashSize != c.expected {
+			t.Errorf("[%s] expected hash size %d, got: %d", c.name, c.expected,
+				c.set.HashSize)
+		}
+
+		if err := c.set.Validate(); err != nil {
+			t.Errorf("[%s] expected valid set, got: %v", c.name, err)
+		}
+	}
+}
+
+func TestIPSetSpecE(t *testing.T) {
+	cases := []struct {
+		name    string
+		setters []IPSetSpecFunc
+		invalid bool
+	}{
+		{
+			name:    "Valid specification",
+			setters: []IPSetSpecFunc{IPSetName("foo")},
+		},
+		{
+			name:    "Invalid type",
+			setters: []IPSetSpecFunc{IPSetName("foo"), IPSetType("invalid")},
+			invalid: true,
+		},
+		{
+			name:    "Invalid hash size",
+			setters: []IPSetSpecFunc{IPSetName("foo"), IPSetHashSize(0)},
+			invalid: true,
+		},
+		{
+			name:    "Invalid max elements",
+			setters: []IPSetSpecFunc{IPSetName("foo"), IPSetMaxElement(-1)},
+			invalid: true,
+		},
+	}
+
+	for _, c := range cases {
+		set, err := IPSetSpecE(c.setters...)
+		expectedErr := IPSetSpec(c.setters...).Validate()
+
+		if !c.invalid {
+			if err != nil || set == nil {
+				t.Errorf("[%s] expected success, got: %v", c.name, err)
+			}
+
+			continue
+		}
+
+		if err == nil || set != nil {
+			t.Errorf("[%s] expected failure, got: %+v", c.name, set)
+			continue
+		}
+
+		if err.Error() != expectedErr.Error() {
+			t.Errorf("[%s] expected error: %v, got: %v", c.name, expectedErr,
+				err)
+		}
+	}
+}
+
+func TestRunWithStdin(t *testing.T) {
+	var stdin []byte
+
+	fcmd := fakeexec.FakeCmd{}
+	fcmd.CombinedOutputScript = []fakeexec.FakeAction{
+		func() ([]byte, []byte, error) {
+			stdin, _ = ioutil.ReadAll(fcmd.Stdin)
+			return []byte{}, nil, nil
+		},
+		func() ([]byte, []byte, error) {
+			if fcmd.Stdin != nil {
+				return nil, nil, fmt.Errorf("unexpected stdin")
+			}
+
+			return []byte{}, nil, nil
+		},
+	}
+
+	fexec := fakeexec.FakeExec{}
+	for range fcmd.CombinedOutputScript {
+		fexec.CommandScript = append(fexec.CommandScript,
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			})
+	}
+
+	r := newInternal(&fexec, testIPSetLockfilePath).(*runner)
+
+	data := []byte("add foo 172.18.3.2\n")
+	_, err := r.runWithStdin([]string{"restore"}, data)
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	if !reflect.DeepEqual(stdin, data) {
+		t.Errorf("expected stdin %q consumed, got: %q", data, stdin)
+	}
+
+	fcmd.Stdin = nil
+	_, err = r.run([]string{"list", "foo"})
+	if err != nil {
+		t.Errorf("expected success without stdin, got: %v", err)
+	}
+
+	if !reflect.DeepEqual(fcmd.CombinedOutputLog[0], []string{"ipset", "restore"}) {
+		t.Errorf("wrong CombinedOutput() log, got: %s",
+			fcmd.CombinedOutputLog[0])
+	}
+}
+
+func TestListXMLUnsupported(t *testing.T) {
+	cases := []struct {
+		name              string
+		outputs           []string
+		combinedOutputLog [][]string
+		unsupported       bool
+	}{
+		{
+			name:        "Old ipset with version in error",
+			outputs:     []string{"ipset v4.5: Unknown arg `list'"},
+			unsupported: true,
+		},
+		{
+			name: "Old ipset without version command",
+			outputs: []string{
+				"ipset: invalid option -- 'o'",
+				"ipset: invalid option -- 'version'",
+				"ipset v4.5, protocol version 4.",
+			},
+			combinedOutputLog: [][]string{
+				{"ipset", "list", "foo", "-o", "xml"},
+				{"ipset", "version"},
+				{"ipset", "-V"},
+			},
+			unsupported: true,
+		},
+		{
+			name:    "Set does not exist",
+			outputs: []string{"ipset v7.6: The set with the given name does not exist"},
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{}
+		for _, output := range c.outputs {
+			output := output
+			fcmd.CombinedOutputScript = append(fcmd.CombinedOutputScript,
+				func() ([]byte, []byte, error) {
+					return []byte(output), nil, &fakeexec.FakeExitError{Status: 1}
+				})
+		}
+
+		fexec := fakeexec.FakeExec{}
+		for range fcmd.CombinedOutputScript {
+			fexec.CommandScript = append(fexec.CommandScript,
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				})
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		_, err := runner.ListEntries("foo")
+		if err == nil {
+			t.Errorf("[%s] expected failure, got: nil", c.name)
+		}
+
+		if errors.Is(err, ErrXMLUnsupported) != c.unsupported {
+			t.Errorf("[%s] expected unsupported %v, got: %v", c.name,
+				c.unsupported, err)
+		}
+
+		if c.combinedOutputLog != nil &&
+			!reflect.DeepEqual(fcmd.CombinedOutputLog, c.combinedOutputLog) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog)
+		}
+	}
+}
+
+func TestListMalformedXML(t *testing.T) {
+	cases := []struct {
+		name     string
+		setname  string
+		attempts int
+		stream   bool
+		list     func(runner Interface) error
+	}{
+		{
+			name:     "ListEntries",
+			setname:  "foo",
+			attempts: listAttempts,
+			list: func(runner Interface) error {
+				_, err := runner.ListEntries("foo")
+				return err
+			},
+		},
+		{
+			name:    "IterateEntries",
+			setname: "foo",
+			stream:  true,
+			list: func(runner Interface) error {
+				return runner.IterateEntries("foo",
+					func(entry IPSetEntry) error { return nil })
+			},
+		},
+		{
+			name:     "GetSetHeader",
+			setname:  "foo",
+			attempts: 1,
+			list: func(runner Interface) error {
+				_, err := runner.GetSetHeader("foo")
+				return err
+			},
+		},
+		{
+			name:     "ListSets",
+			setname:  "all sets",
+			attempts: listAttempts,
+			list: func(runner Interface) error {
+				_, err := runner.ListSets()
+				return err
+			},
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{}
+		fexec := fakeexec.FakeExec{}
+		for i := 0; i < c.attempts; i++ {
+			fcmd.CombinedOutputScript = append(fcmd.CombinedOutputScript,
+				func() ([]byte, []byte, error) {
+					return []byte(`<ipsets><ipset name="foo"><type>hash:ip`),
+						nil, nil
+				})
+			fexec.CommandScript = append(fexec.CommandScript,
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				})
+		}
+
+		if c.stream {
+			fexec.CommandScript = append(fexec.CommandScript,
+				fakeStreamCommand(&fcmd,
+					[]byte(`<ipsets><ipset name="foo"><type>hash:ip`),
+					nil, nil))
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		err := c.list(runner)
+		if err == nil {
+			t.Errorf("[%s] expected failure, got: nil", c.name)
+			continue
+		}
+
+		if fcmd.CombinedOutputCalls != c.attempts {
+			t.Errorf("[%s] expected %d CombinedOutput() calls, got: %d",
+				c.name, c.attempts, fcmd.CombinedOutputCalls)
+		}
+
+		if !strings.Contains(err.Error(), c.setname) {
+			t.Errorf("[%s] expected error with %s, got: %v", c.name,
+				c.setname, err)
+		}
+
+		var syntaxErr *xml.SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("[%s] expected *xml.SyntaxError, got: %v", c.name, err)
+		}
+
+		if _, ok := errors.Unwrap(err).(*xml.SyntaxError); !ok {
+			t.Errorf("[%s] expected unwrapped *xml.SyntaxError, got: %T",
+				c.name, errors.Unwrap(err))
+		}
+	}
+}
+
+func TestListRetry(t *testing.T) {
+	cases := []struct {
+		name          string
+		failures      []string
+		expectedCalls int
+		expectedError bool
+	}{
+		{
+			name: "Interrupted once",
+			failures: []string{
+				"ipset v7.6: Resource temporarily unavailable",
+			},
+			expectedCalls: 2,
+		},
+		{
+			name:          "Malformed once",
+			failures:      []string{`<ipsets><ipset name="foo"><type>hash:ip`},
+			expectedCalls: 2,
+		},
+		{
+			name: "Interrupted always",
+			failures: []string{
+				"ipset v7.6: Resource temporarily unavailable",
+				"ipset v7.6: Resource temporarily unavailable",
+				"ipset v7.6: Resource temporarily unavailable",
+			},
+			expectedCalls: listAttempts,
+			expectedError: true,
+		},
+		{
+			name:          "Not retried",
+			failures:      []string{"ipset v7.6: Kernel error received"},
+			expectedCalls: 1,
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{}
+		fexec := fakeexec.FakeExec{}
+		for _, failure := range c.failures {
+			output := failure
+			fcmd.CombinedOutputScript = append(fcmd.CombinedOutputScript,
+				func() ([]byte, []byte, error) {
+					if strings.HasPrefix(output, "ipset v7.6") {
+						return []byte(output), nil,
+							&fakeexec.FakeExitError{Status: 1}
+					}
+
+					return []byte(output), nil, nil
+				})
+		}
+
+		fcmd.CombinedOutputScript = append(fcmd.CombinedOutputScript,
+			func() ([]byte, []byte, error) {
+				return []byte(testCommentListOutput), nil, nil
+			})
+
+		for range fcmd.CombinedOutputScript {
+			fexec.CommandScript = append(fexec.CommandScript,
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				})
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		entries, err := runner.ListEntries("foo")
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+		} else {
+			if err != nil {
+				t.Errorf("[%s] expected success, got: %v", c.name, err)
+			}
+
+			if len(entries) != 3 {
+				t.Errorf("[%s] expected 3 entries, got: %d", c.name,
+					len(entries))
+			}
+		}
+
+		if fcmd.CombinedOutputCalls != c.expectedCalls {
+			t.Errorf("[%s] expected %d CombinedOutput() calls, got: %d",
+				c.name, c.expectedCalls, fcmd.CombinedOutputCalls)
+		}
+	}
+}
+
+func TestLookupEntry(t *testing.T) {
+	cases := []struct {
+		name        string
+		element     string
+		listFailed  bool
+		expected    *IPSetEntry
+		notFound    bool
+		expectedErr bool
+	}{
+		{
+			name:     "Found",
+			element:  "172.18.3.3",
+			expected: &IPSetEntry{Element: "172.18.3.3", Comment: "ContainerID: cafebabe"},
+		},
+		{
+			name:        "Not found",
+			element:     "172.18.3.9",
+			notFound:    true,
+			expectedErr: true,
+		},
+		{
+			name:        "List failure",
+			element:     "172.18.3.3",
+			listFailed:  true,
+			expectedErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				func() ([]byte, []byte, error) {
+					if c.listFailed {
+						return []byte("ipset v7.6: The set with the given name does not exist"),
+							nil, &fakeexec.FakeExitError{Status: 1}
+					}
+
+					return []byte(testCommentListOutput), nil, nil
+				},
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		entry, err := runner.LookupEntry(c.element, "foo")
+		if c.expectedErr && err == nil {
+			t.Errorf("[%s] expected failure, got: nil", c.name)
+		}
+
+		if !c.expectedErr && err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if errors.Is(err, ErrEntryNotFound) != c.notFound {
+			t.Errorf("[%s] expected not found %v, got: %v", c.name,
+				c.notFound, err)
+		}
+
+		if !reflect.DeepEqual(entry, c.expected) {
+			t.Errorf("[%s] expected entry: %+v, got: %+v", c.name, c.expected,
+				entry)
+		}
+	}
+}
+
+func TestIPSetSpecDefaults(t *testing.T) {
+	set := IPSetSpec(IPSetName("x"))
+
+	if set.HashSize != DefaultHashSize ||
+		GetDefaultHashSize() != DefaultHashSize {
+		t.Errorf("expected hash size %d, got: %d", DefaultHashSize,
+			set.HashSize)
+	}
+
+	if set.MaxElement != DefaultMaxElement ||
+		GetDefaultMaxElement() != DefaultMaxElement {
+		t.Errorf("expected max element %d, got: %d", DefaultMaxElement,
+			set.MaxElement)
+	}
+
+	if set.HashFamily != DefaultFamily {
+		t.Errorf("expected hash family %s, got: %s", DefaultFamily,
+			set.HashFamily)
+	}
+}
+
+func TestIPSetWithoutComment(t *testing.T) {
+	set := IPSetSpec(IPSetName("foo"), IPSetWithComment(),
+		IPSetWithoutComment())
+	if set.WithComment {
+		t.Errorf("expected comment option disabled, got enabled")
+	}
+
+	args := buildCreateArgs(set)
+	for _, arg := range args {
+		if arg == "comment" {
+			t.Errorf("expected no comment option, got: %s", args)
+		}
+	}
+}
+
+func TestTotalMemory(t *testing.T) {
+	fcmd := fakeexec.FakeCmd{
+		CombinedOutputScript: []fakeexec.FakeAction{
+			// Success
+			func() ([]byte, []byte, error) {
+				return []byte(`
+				<ipsets>
+					<ipset name="foo">
+						<type>hash:ip</type>
+						<revision>4</revision>
+						<header>
+							<family>inet</family>
+							<hashsize>1024</hashsize>
+							<maxelem>65536</maxelem>
+							<memsize>472</memsize>
+							<references>0</references>
+							<numentries>2</numentries>
+						</header>
+					</ipset>
+					<ipset name="bar">
+						<type>hash:net</type>
+						<revision>6</revision>
+						<header>
+							<family>inet</family>
+							<hashsize>1024</hashsize>
+							<maxelem>65536</maxelem>
+							<memsize>408</memsize>
+							<references>0</references>
+							<numentries>1</numentries>
+						</header>
+					</ipset>
+				</ipsets>
+				`), nil, nil
+			},
+			// Failure
+			func() ([]byte, []byte, error) {
+				return []byte("ipset v7.6: Kernel error received: Operation not permitted"),
+					nil, &fakeexec.FakeExitError{Status: 1}
+			},
+		},
+	}
+
+	fexec := fakeexec.FakeExec{
+		CommandScript: []fakeexec.FakeCommandAction{
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			},
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			},
+		},
+	}
+
+	runner := newInternal(&fexec, testIPSetLockfilePath)
+
+	total, err := runner.TotalMemory()
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	if total != 880 {
+		t.Errorf("expected total memory 880, got: %d", total)
+	}
+
+	if !reflect.DeepEqual(fcmd.CombinedOutputLog[0],
+		[]string{"ipset", "list", "-t", "-o", "xml"}) {
+		t.Errorf("wrong CombinedOutput() log, got: %s",
+			fcmd.CombinedOutputLog[0])
+	}
+
+	_, err = runner.TotalMemory()
+	if err == nil {
+		t.Errorf("expected failure, got: nil")
+	}
+}
+
+func TestCreateSetWithSpec(t *testing.T) {
+	fcmd := fakeexec.FakeCmd{
+		CombinedOutputScript: []fakeexec.FakeAction{
+			// Create
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			// List
+			func() ([]byte, []byte, error) {
+				return []byte(`
+				<ipsets>
+					<ipset name="foo">
+						<type>hash:ip</type>
+						<revision>4</revision>
+						<header>
+							<family>inet</family>
+							<hashsize>512</hashsize>
+							<maxelem>1000</maxelem>
+							<comment/>
+							<memsize>200</memsize>
+							<references>0</references>
+							<numentries>0</numentries>
+						</header>
+						<members>
+						</members>
+					</ipset>
+				</ipsets>
+				`), nil, nil
+			},
+		},
+	}
+
+	fexec := fakeexec.FakeExec{
+		CommandScript: []fakeexec.FakeCommandAction{
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			},
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			},
+		},
+	}
+
+	runner := newInternal(&fexec, testIPSetLockfilePath)
+
+	created, err := runner.CreateSetWithSpec(IPSetSpec(
+		IPSetName("foo"),
+		IPSetHashSize(300),
+		IPSetMaxElement(1000),
+		IPSetWithComment(),
+	), false)
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	expected := &IPSet{
+		Name:        "foo",
+		SetType:     HashIP,
+		HashFamily:  ProtocolFamilyIPv4,
+		HashSize:    512,
+		MaxElement:  1000,
+		WithComment: true,
+		MemSize:     200,
+	}
+	if !reflect.DeepEqual(created, expected) {
+		t.Errorf("expected created set: %+v, got: %+v", expected, created)
+	}
+
+	expectedLog := [][]string{
+		{"ipset", "create", "foo", "hash:ip", "family", "inet",
+			"hashsize", "300", "maxelem", "1000", "comment", "-o", "xml"},
+		{"ipset", "list", "foo", "-t", "-o", "xml"},
+	}
+	if !reflect.DeepEqual(fcmd.CombinedOutputLog, expectedLog) {
+		t.Errorf("wrong CombinedOutput() log, got: %s",
+			fcmd.CombinedOutputLog)
+	}
+
+	_, err = runner.CreateSetWithSpec(IPSetSpec(IPSetName("foo"),
+		IPSetHashSize(0)), false)
+	if err == nil {
+		t.Errorf("expected failure, got: nil")
+	}
+}
+
+func TestCreateSetCommentUnsupported(t *testing.T) {
+	cases := []struct {
+		name              string
+		output            string
+		fallback          bool
+		combinedOutputLog [][]string
+		unsupported       bool
+	}{
+		{
+			name:   "Old ipset",
+			output: "ipset v6.11: Unknown argument: `comment'",
+			combinedOutputLog: [][]string{
+				{"ipset", "create", "foo", "hash:ip", "family", "inet",
+					"hashsize", testDefaultHashSize,
+					"maxelem", testDefaultMaxElement, "comment", "-o", "xml"},
+			},
+			unsupported: true,
+		},
+		{
+			name: "Old kernel with fallback",
+			output: "ipset v7.6: Argument `comment' is supported in the " +
+				"kernel module of the set type hash:ip starting from the " +
+				"revision 2 and you have installed revision 1 only. Your " +
+				"kernel is behind your ipset utility.",
+			fallback: true,
+			combinedOutputLog: [][]string{
+				{"ipset", "create", "foo", "hash:ip", "family", "inet",
+					"hashsize", testDefaultHashSize,
+					"maxelem", testDefaultMaxElement, "comment", "-o", "xml"},
+				{"ipset", "create", "foo", "hash:ip", "family", "inet",
+					"hashsize", testDefaultHashSize,
+					"maxelem", testDefaultMaxElement, "-o", "xml"},
+			},
+		},
+		{
+			name:   "Other failure",
+			output: "ipset v7.6: Set cannot be created: set with the same name already exists",
+			combinedOutputLog: [][]string{
+				{"ipset", "create", "foo", "hash:ip", "family", "inet",
+					"hashsize", testDefaultHashSize,
+					"maxelem", testDefaultMaxElement, "comment", "-o", "xml"},
+			},
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				// Create with comment
+				func() ([]byte, []byte, error) {
+					return []byte(c.output), nil,
+						&fakeexec.FakeExitError{Status: 1}
+				},
+				// Create without comment
+				func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			},
+		}
+
+		fexec := fakeexec.FakeExec{}
+		for range fcmd.CombinedOutputScript {
+			fexec.CommandScript = append(fexec.CommandScript,
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				})
+		}
+
+		opts := []RunnerOption{}
+		if c.fallback {
+			opts = append(opts, WithCommentFallback())
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath, opts...)
+
+		err := runner.CreateSet(IPSetSpec(IPSetName("foo"),
+			IPSetWithComment()), false)
+		if c.fallback && err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !c.fallback && err == nil {
+			t.Errorf("[%s] expected failure, got: nil", c.name)
+		}
+
+		if errors.Is(err, ErrCommentUnsupported) != c.unsupported {
+			t.Errorf("[%s] expected unsupported %v, got: %v", c.name,
+				c.unsupported, err)
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog, c.combinedOutputLog) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog)
+		}
+	}
+}
+
+func TestRefreshEntry(t *testing.T) {
+	fcmd := fakeexec.FakeCmd{
+		CombinedOutputScript: []fakeexec.FakeAction{
+			// Success
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			// Success
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			// Failure
+			func() ([]byte, []byte, error) {
+				return []byte("ipset v7.6: The set with the given name does not exist"),
+					nil, &fakeexec.FakeExitError{Status: 1}
+			},
+		},
+	}
+
+	fexec := fakeexec.FakeExec{}
+	for range fcmd.CombinedOutputScript {
+		fexec.CommandScript = append(fexec.CommandScript,
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			})
+	}
+
+	runner := newInternal(&fexec, testIPSetLockfilePath)
+
+	err := runner.RefreshEntry(&IPSetEntry{Element: "172.18.3.2",
+		Timeout: 600}, "foo")
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	err = runner.RefreshEntry(&IPSetEntry{Element: "172.18.3.3"}, "foo")
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	err = runner.RefreshEntry(&IPSetEntry{Element: "172.18.3.2"}, "bar")
+	if err == nil {
+		t.Errorf("expected failure, got: nil")
+	}
+
+	err = runner.RefreshEntry(&IPSetEntry{Element: ""}, "foo")
+	if err == nil {
+		t.Errorf("expected failure, got: nil")
+	}
+
+	expected := [][]string{
+		{"ipset", "add", "foo", "172.18.3.2", "timeout", "600", "-exist",
+			"-o", "xml"},
+		{"ipset", "add", "foo", "172.18.3.3", "-exist", "-o", "xml"},
+		{"ipset", "add", "bar", "172.18.3.2", "-exist", "-o", "xml"},
+	}
+	if !reflect.DeepEqual(fcmd.CombinedOutputLog, expected) {
+		t.Errorf("wrong CombinedOutput() log, got: %s",
+			fcmd.CombinedOutputLog)
+	}
+}
+
+func TestNilArguments(t *testing.T) {
+	fexec := fakeexec.FakeExec{}
+	runner := newInternal(&fexec, testIPSetLockfilePath)
+
+	cases := []struct {
+		name string
+		call func() error
+	}{
+		{
+			name: "AddEntry",
+			call: func() error { return runner.AddEntry(nil, "foo", false) },
+		},
+		{
+			name: "RefreshEntry",
+			call: func() error { return runner.RefreshEntry(nil, "foo") },
+		},
+		{
+			name: "DelEntryStruct",
+			call: func() error { return runner.DelEntryStruct(nil, "foo") },
+		},
+		{
+			name: "CreateSet",
+			call: func() error { return runner.CreateSet(nil, false) },
+		},
+		{
+			name: "CreateSetWithSpec",
+			call: func() error {
+				_, err := runner.CreateSetWithSpec(nil, false)
+				return err
+			},
+		},
+		{
+			name: "EnsureSet",
+			call: func() error { return runner.EnsureSet(nil) },
+		},
+		{
+			name: "CreateSetIfNotExists",
+			call: func() error { return runner.CreateSetIfNotExists(nil) },
+		},
+		{
+			name: "CreateSetAndAddEntries",
+			call: func() error {
+				return runner.CreateSetAndAddEntries(nil, nil, false)
+			},
+		},
+		{
+			name: "ApplyBatch",
+			call: func() error { return runner.ApplyBatch([]*IPSet{nil}, nil) },
+		},
+	}
+
+	for _, c := range cases {
+		err := c.call()
+		if err == nil {
+			t.Errorf("[%s] expected failure, got: nil", c.name)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), "nil") {
+			t.Errorf("[%s] expected nil argument error, got: %v", c.name, err)
+		}
+	}
+
+	if fexec.CommandCalls != 0 {
+		t.Errorf("expected 0 Command() calls, got: %d", fexec.CommandCalls)
+	}
+}
+
+func TestEmptySetName(t *testing.T) {
+	fexec := fakeexec.FakeExec{}
+	runner := newInternal(&fexec, testIPSetLockfilePath)
+	entry := &IPSetEntry{Element: "172.18.3.2"}
+
+	cases := []struct {
+		name string
+		call func() error
+	}{
+		{
+			name: "DestroySet",
+			call: func() error { return runner.DestroySet("") },
+		},
+		{
+			name: "RenameSet",
+			call: func() error { return runner.RenameSet("", "foo") },
+		},
+		{
+			name: "ListEntries",
+			call: func() error {
+				_, err := runner.ListEntries("")
+				return err
+			},
+		},
+		{
+			name: "ListEntriesResolved",
+			call: func() error {
+				_, err := runner.ListEntriesResolved("")
+				return err
+			},
+		},
+		{
+			name: "ListEntriesSorted",
+			call: func() error {
+				_, err := runner.ListEntriesSorted("")
+				return err
+			},
+		},
+		{
+			name: "ListEntriesByComment",
+			call: func() error {
+				_, err := runner.ListEntriesByComment("", "owner")
+				return err
+			},
+		},
+		{
+			name: "IterateEntries",
+			call: func() error {
+				return runner.IterateEntries("",
+					func(entry IPSetEntry) error { return nil })
+			},
+		},
+		{
+			name: "GetSetHeader",
+			call: func() error {
+				_, err := runner.GetSetHeader("")
+				return err
+			},
+		},
+		{
+			name: "IsSetExists",
+			call: func() error {
+				_, err := runner.IsSetExists("")
+				return err
+			},
+		},
+		{
+			name: "IsEmptySet",
+			call: func() error {
+				_, err := runner.IsEmptySet("")
+				return err
+			},
+		},
+		{
+			name: "CommentEnabled",
+			call: func() error {
+				_, err := runner.CommentEnabled("")
+				return err
+			},
+		},
+		{
+			name: "SetMembers",
+			call: func() error { return runner.SetMembers("", []string{"foo"}) },
+		},
+		{
+			name: "SaveSetStream",
+			call: func() error { return runner.SaveSetStream("", ioutil.Discard) },
+		},
+		{
+			name: "BackupSet",
+			call: func() error { return runner.BackupSet("", "/nonexistent") },
+		},
+		{
+			name: "AddEntry",
+			call: func() error { return runner.AddEntry(entry, "", false) },
+		},
+		{
+			name: "AddEntryBatch",
+			call: func() error {
+				return runner.AddEntryBatch("", []IPSetEntry{*entry})
+			},
+		},
+		{
+			name: "RefreshEntry",
+			call: func() error { return runner.RefreshEntry(entry, "") },
+		},
+		{
+			name: "DelEntry",
+			call: func() error { return runner.DelEntry(entry.Element, "") },
+		},
+		{
+			name: "DelEntryStruct",
+			call: func() error { return runner.DelEntryStruct(entry, "") },
+		},
+		{
+			name: "DelEntries",
+			call: func() error {
+				return runner.DelEntries([]string{entry.Element}, "", false)
+			},
+		},
+		{
+			name: "DelEntriesByComment",
+			call: func() error {
+				_, err := runner.DelEntriesByComment("", "owner")
+				return err
+			},
+		},
+		{
+			name: "TestEntry",
+			call: func() error {
+				_, err := runner.TestEntry(entry.Element, "")
+				return err
+			},
+		},
+		{
+			name: "TestEntries",
+			call: func() error {
+				_, err := runner.TestEntries([]string{entry.Element}, "")
+				return err
+			},
+		},
+		{
+			name: "LookupEntry",
+			call: func() error {
+				_, err := runner.LookupEntry(entry.Element, "")
+				return err
+			},
+		},
+		{
+			name: "FlushSet",
+			call: func() error { return runner.FlushSet("") },
+		},
+		{
+			name: "ClearEntries",
+			call: func() error { return runner.ClearEntries("") },
+		},
+		{
+			name: "ResizeSet",
+			call: func() error { return runner.ResizeSet("", 1024, 65536) },
+		},
+		{
+			name: "CreateSet",
+			call: func() error { return runner.CreateSet(IPSetSpec(), false) },
+		},
+		{
+			name: "CreateSetWithSpec",
+			call: func() error {
+				_, err := runner.CreateSetWithSpec(IPSetSpec(), false)
+				return err
+			},
+		},
+		{
+			name: "CreateSetIfNotExists",
+			call: func() error { return runner.CreateSetIfNotExists(IPSetSpec()) },
+		},
+		{
+			name: "EnsureSet",
+			call: func() error { return runner.EnsureSet(IPSetSpec()) },
+		},
+		{
+			name: "CreateSets",
+			call: func() error {
+				err := runner.CreateSets([]*IPSet{IPSetSpec()}, false)
+
+				var setsErr *SetsError
+				if errors.As(err, &setsErr) {
+					return setsErr.Errors[""]
+				}
+
+				return err
+			},
+		},
+		{
+			name: "CreateSetAndAddEntries",
+			call: func() error {
+				return runner.CreateSetAndAddEntries(IPSetSpec(), nil, false)
+			},
+		},
+		{
+			name: "ApplyBatch",
+			call: func() error {
+				return runner.ApplyBatch([]*IPSet{IPSetSpec()}, nil)
+			},
+		},
+	}
+
+	for _, c := range cases {
+		err := c.call()
+		if err == nil {
+			t.Errorf("[%s] expected failure, got: nil", c.name)
+			continue
+		}
+
+		if !errors.Is(err, ErrEmptySetName) {
+			t.Errorf("[%s] expected ErrEmptySetName, got: %v", c.name, err)
+		}
+	}
+
+	if fexec.CommandCalls != 0 {
+		t.Errorf("expected 0 Command() calls, got: %d", fexec.CommandCalls)
+	}
+}
+
+func TestTypeCommandBuilders(t *testing.T) {
+	for _, setType := range ValidIPSetTypes {
+		if _, ok := typeCommandBuilders[setType]; !ok {
+			t.Errorf("expected create command builder of %s, got: none",
+				setType)
+		}
+	}
+
+	if len(typeCommandBuilders) != len(ValidIPSetTypes) {
+		t.Errorf("expected %d create command builders, got: %d",
+			len(ValidIPSetTypes), len(typeCommandBuilders))
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+// Union returns the entries of a and then the entries of b which are not in
+// a, the entries are the same when their elements are equal, the first one
+// is kept.
+func Union(a, b []IPSetEntry) []IPSetEntry {
+	seen := map[string]bool{}
+	union := []IPSetEntry{}
+
+	for _, entries := range [][]IPSetEntry{a, b} {
+		for _, entry := range entries {
+			if !seen[entry.Element] {
+				seen[entry.Element] = true
+				union = append(union, entry)
+			}
+		}
+	}
+
+	return union
+}
+
+// Intersection returns the entries of a which are also in b.
+func Intersection(a, b []IPSetEntry) []IPSetEntry {
+	return filterEntries(a, elementSet(b), true)
+}
+
+// Difference returns the entries of a which are not in b.
+func Difference(a, b []IPSetEntry) []IPSetEntry {
+	return filterEntries(a, elementSet(b), false)
+}
+
+// EntriesByElement returns the entries keyed by element for the membership
+// check, the last one of the duplicated elements is kept.
+func EntriesByElement(entries []IPSetEntry) map[string]IPSetEntry {
+	byElement := make(map[string]IPSetEntry, len(entries))
+	for _, entry := range entries {
+		byElement[entry.Element] = entry
+	}
+
+	return byElement
+}
+
+// elementSet returns the set of the entry elements.
+func elementSet(entries []IPSetEntry) map[string]bool {
+	elements := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		elements[entry.Element] = true
+	}
+
+	return elements
+}
+
+// filterEntries returns the entries which are, or are not, in the elements,
+// the duplicated entries are removed.
+func filterEntries(entries []IPSetEntry, elements map[string]bool,
+	in bool) []IPSetEntry {
+	seen := map[string]bool{}
+	filtered := []IPSetEntry{}
+
+	for _, entry := range entries {
+		if elements[entry.Element] == in && !seen[entry.Element] {
+			seen[entry.Element] = true
+			filtered = append(filtered, entry)
+		}
+	}
+
+	return filtered
+}
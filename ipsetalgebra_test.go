@@ -0,0 +1,105 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEntrySetAlgebra(t *testing.T) {
+	a := []IPSetEntry{
+		{Element: "10.0.0.1", Comment: "a"},
+		{Element: "10.0.0.2"},
+		{Element: "10.0.0.3"},
+	}
+
+	b := []IPSetEntry{
+		{Element: "10.0.0.3"},
+		{Element: "10.0.0.1", Comment: "b"},
+		{Element: "10.0.0.4"},
+	}
+
+	cases := []struct {
+		name     string
+		result   []IPSetEntry
+		expected []IPSetEntry
+	}{
+		{
+			name:   "A union B",
+			result: Union(a, b),
+			expected: []IPSetEntry{
+				{Element: "10.0.0.1", Comment: "a"},
+				{Element: "10.0.0.2"},
+				{Element: "10.0.0.3"},
+				{Element: "10.0.0.4"},
+			},
+		},
+		{
+			name:   "A intersection B",
+			result: Intersection(a, b),
+			expected: []IPSetEntry{
+				{Element: "10.0.0.1", Comment: "a"},
+				{Element: "10.0.0.3"},
+			},
+		},
+		{
+			name:     "A difference B",
+			result:   Difference(a, b),
+			expected: []IPSetEntry{{Element: "10.0.0.2"}},
+		},
+		{
+			name:     "B difference A",
+			result:   Difference(b, a),
+			expected: []IPSetEntry{{Element: "10.0.0.4"}},
+		},
+		{
+			name:     "A union empty",
+			result:   Union(a, nil),
+			expected: a,
+		},
+		{
+			name:     "Empty union empty",
+			result:   Union(nil, []IPSetEntry{}),
+			expected: []IPSetEntry{},
+		},
+		{
+			name:     "A intersection empty",
+			result:   Intersection(a, nil),
+			expected: []IPSetEntry{},
+		},
+		{
+			name:     "A difference empty",
+			result:   Difference(a, nil),
+			expected: a,
+		},
+		{
+			name:     "Empty difference B",
+			result:   Difference(nil, b),
+			expected: []IPSetEntry{},
+		},
+		{
+			name: "A with duplicates difference B",
+			result: Difference([]IPSetEntry{
+				{Element: "10.0.0.2"},
+				{Element: "10.0.0.2"},
+			}, b),
+			expected: []IPSetEntry{{Element: "10.0.0.2"}},
+		},
+	}
+
+	for _, c := range cases {
+		if !reflect.DeepEqual(c.result, c.expected) {
+			t.Errorf("[%s] expected entries: %+v, got: %+v", c.name,
+				c.expected, c.result)
+		}
+	}
+
+	// (A \ B) + (A ∩ B) has the same elements as A.
+	whole := Union(Difference(a, b), Intersection(a, b))
+	if len(Difference(whole, a)) != 0 || len(Difference(a, whole)) != 0 {
+		t.Errorf("expected (A \\ B) ∪ (A ∩ B) = A, got: %+v", whole)
+	}
+}
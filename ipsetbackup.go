@@ -0,0 +1,221 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// SaveSetStream writes the specified set name in the ipset save format to w.
+func (runner *runner) SaveSetStream(setname SetName, w io.Writer) error {
+	if len(setname) == 0 {
+		return ErrEmptySetName
+	}
+
+	return runner.saveStream(w, string(setname))
+}
+
+// SaveAllStream writes all sets in the ipset save format to w.
+func (runner *runner) SaveAllStream(w io.Writer) error {
+	return runner.saveStream(w)
+}
+
+// saveStream implements the save of the set names, or all sets if none, to
+// w. The ipset save stdout is copied to w as it is read, the stderr, e.g. the
+// warnings, is reported in the error only.
+func (runner *runner) saveStream(w io.Writer, setnames ...string) error {
+	err := runner.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer runner.locker.Unlock()
+
+	var writeErr error
+
+	out, err := runner.stream(append([]string{"save"}, setnames...),
+		func(stdout io.Reader) {
+			_, writeErr = io.Copy(w, stdout)
+		})
+	if err != nil {
+		return fmt.Errorf("error saving sets %v, error: %v, output: %s",
+			setnames, err, strings.TrimSpace(string(out)))
+	}
+
+	if writeErr != nil {
+		return fmt.Errorf("error writing saved sets %v, error: %v", setnames,
+			writeErr)
+	}
+
+	return nil
+}
+
+// BackupSet writes the specified set name to the file in the ipset save
+// format, the file is created or truncated.
+func (runner *runner) BackupSet(setname SetName, filepath string) error {
+	if len(setname) == 0 {
+		return ErrEmptySetName
+	}
+
+	return backupFile(filepath, func(w io.Writer) error {
+		return runner.SaveSetStream(setname, w)
+	})
+}
+
+// BackupAll writes all sets to the file in the ipset save format, the file is
+// created or truncated.
+func (runner *runner) BackupAll(filepath string) error {
+	return backupFile(filepath, runner.SaveAllStream)
+}
+
+// backupFile creates the file and writes it with the save function.
+func backupFile(filepath string, save func(w io.Writer) error) error {
+	f, err := os.Create(filepath)
+	if err != nil {
+		return fmt.Errorf("error creating backup %s, error: %v", filepath, err)
+	}
+
+	err = save(f)
+
+	closeErr := f.Close()
+	if err == nil && closeErr != nil {
+		err = fmt.Errorf("error closing backup %s, error: %v", filepath,
+			closeErr)
+	}
+
+	return err
+}
+
+// RestoreSetFromFile restores the set from the file in the ipset save
+// format, e.g. written by BackupSet. The file of the other sets, e.g. written
+// by BackupAll, is rejected and nothing is restored, see RestoreAllFromFile.
+// The ipset restore -exist ignores the existing set or entry error when
+// ignoreExistErr is true, so the set could be restored onto the host which
+// already has it.
+func (runner *runner) RestoreSetFromFile(filepath string,
+	ignoreExistErr bool) error {
+	return runner.restoreFile(filepath, ignoreExistErr, true)
+}
+
+// RestoreAllFromFile restores all sets from the file in the ipset save
+// format, e.g. written by BackupAll. The existing set or entry error is
+// ignored when ignoreExistErr is true, see RestoreSetFromFile.
+func (runner *runner) RestoreAllFromFile(filepath string,
+	ignoreExistErr bool) error {
+	return runner.restoreFile(filepath, ignoreExistErr, false)
+}
+
+// restoreFile pipes the file content to ipset restore, the lines of the
+// single set file should all be of the same set.
+func (runner *runner) restoreFile(filepath string, ignoreExistErr bool,
+	single bool) error {
+	data, err := ioutil.ReadFile(filepath)
+	if err != nil {
+		return fmt.Errorf("error reading backup %s, error: %v", filepath, err)
+	}
+
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+
+	if single {
+		err = checkSingleSet(lines)
+		if err != nil {
+			return fmt.Errorf("error restoring backup %s, error: %v",
+				filepath, err)
+		}
+	}
+
+	err = runner.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer runner.locker.Unlock()
+
+	err = runner.restoreLines(lines, ignoreExistErr)
+	if err != nil {
+		return fmt.Errorf("error restoring backup %s, error: %w", filepath,
+			err)
+	}
+
+	return nil
+}
+
+// checkSingleSet checks that the restore lines, skipping the empty and the
+// comment ones, are all of the same set, the set name follows the command.
+func checkSingleSet(lines []string) error {
+	setname := ""
+
+	for idx, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		if len(fields) < 2 {
+			return fmt.Errorf("line %d %q has no set name", idx+1, line)
+		}
+
+		if len(setname) == 0 {
+			setname = fields[1]
+		}
+
+		if fields[1] != setname {
+			return fmt.Errorf("line %d %q is of set %s, should be set %s",
+				idx+1, line, fields[1], setname)
+		}
+	}
+
+	return nil
+}
+
+// SaveToFile saves all sets to the file with the ipset -file option, ipset
+// writes the file itself instead of the output being piped.
+func (runner *runner) SaveToFile(path string) error {
+	err := runner.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer runner.locker.Unlock()
+
+	out, err := runner.run([]string{"save", "-file", path})
+	if err != nil {
+		return fmt.Errorf("error saving to file %s, error: %v, output: %s",
+			path, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// RestoreFromFile restores the sets from the file with the ipset -file
+// option, ipset reads the file itself instead of the stdin. The existing set
+// or entry error is ignored when ignoreExistErr is true, see
+// RestoreSetFromFile. The failed line is returned as the RestoreError.
+func (runner *runner) RestoreFromFile(path string, ignoreExistErr bool) error {
+	err := runner.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer runner.locker.Unlock()
+
+	cmdArgs := []string{"restore", "-file", path}
+	if ignoreExistErr {
+		cmdArgs = append(cmdArgs, "-exist")
+	}
+
+	out, err := runner.run(cmdArgs)
+	if err != nil {
+		var lines []string
+		if data, readErr := ioutil.ReadFile(path); readErr == nil {
+			lines = strings.Split(string(data), "\n")
+		}
+
+		return fmt.Errorf("error restoring from file %s, error: %w", path,
+			newRestoreError(lines, out, err))
+	}
+
+	return nil
+}
@@ -0,0 +1,356 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/utils/exec"
+	fakeexec "k8s.io/utils/exec/testing"
+)
+
+const testBackupSave = `create foo hash:ip family inet hashsize 1024 maxelem 65536 comment
+add foo 172.18.3.2 comment "ContainerID: deadbeaf"
+add foo 172.18.3.3
+`
+
+func TestBackup(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "ipset-backup-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		name          string
+		backup        func(runner Interface, path string) error
+		stderr        []byte
+		exitErr       error
+		expectedArgs  []string
+		expectedError bool
+	}{
+		{
+			name: "Backup set",
+			backup: func(runner Interface, path string) error {
+				return runner.BackupSet("foo", path)
+			},
+			expectedArgs: []string{"ipset", "save", "foo"},
+		},
+		{
+			name: "Backup all sets",
+			backup: func(runner Interface, path string) error {
+				return runner.BackupAll(path)
+			},
+			expectedArgs: []string{"ipset", "save"},
+		},
+		{
+			name: "Warning kept out of the backup",
+			backup: func(runner Interface, path string) error {
+				return runner.BackupAll(path)
+			},
+			stderr:       []byte("ipset v7.6: Warning: the set is being resized"),
+			expectedArgs: []string{"ipset", "save"},
+		},
+		{
+			name: "Subprocess error",
+			backup: func(runner Interface, path string) error {
+				return runner.BackupSet("foo", path)
+			},
+			stderr:        []byte("ipset v7.6: Kernel error received: Operation not permitted"),
+			exitErr:       &fakeexec.FakeExitError{Status: 1},
+			expectedArgs:  []string{"ipset", "save", "foo"},
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{}
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				fakeStreamCommand(&fcmd, []byte(testBackupSave), c.stderr,
+					c.exitErr),
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		path := filepath.Join(dir, "backup")
+		err := c.backup(runner, path)
+
+		if !reflect.DeepEqual(fcmd.Argv, c.expectedArgs) {
+			t.Errorf("[%s] wrong command, got: %s", c.name, fcmd.Argv)
+		}
+
+		if c.expectedError {
+			if err == nil || !strings.Contains(err.Error(), string(c.stderr)) {
+				t.Errorf("[%s] expected failure with the stderr, got: %v",
+					c.name, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil || string(data) != testBackupSave {
+			t.Errorf("[%s] expected backup:\n%s\ngot:\n%s, %v", c.name,
+				testBackupSave, data, err)
+		}
+	}
+}
+
+func TestBackupFileError(t *testing.T) {
+	fexec := fakeexec.FakeExec{}
+	runner := newInternal(&fexec, testIPSetLockfilePath)
+
+	path := filepath.Join(os.TempDir(), "ipset-backup-missing", "dir", "backup")
+	err := runner.BackupSet("foo", path)
+	if err == nil {
+		t.Errorf("expected failure, got: nil")
+	}
+
+	if fexec.CommandCalls != 0 {
+		t.Errorf("expected 0 Command() calls, got: %d", fexec.CommandCalls)
+	}
+
+	err = runner.RestoreSetFromFile(path, false)
+	if err == nil {
+		t.Errorf("expected failure, got: nil")
+	}
+}
+
+func TestRestoreSetFromFileOtherSets(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "ipset-restore-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "backup")
+	err = ioutil.WriteFile(path, []byte(testBackupSave+
+		"create bar hash:net family inet hashsize 1024 maxelem 65536\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fexec := fakeexec.FakeExec{}
+	runner := newInternal(&fexec, testIPSetLockfilePath)
+
+	err = runner.RestoreSetFromFile(path, false)
+	if err == nil || !strings.Contains(err.Error(), "set bar") {
+		t.Errorf("expected failure of set bar, got: %v", err)
+	}
+
+	if fexec.CommandCalls != 0 {
+		t.Errorf("expected 0 Command() calls, got: %d", fexec.CommandCalls)
+	}
+}
+
+func TestRestoreFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "ipset-restore-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "backup")
+	err = ioutil.WriteFile(path, []byte(testBackupSave), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name            string
+		restore         func(runner Interface, path string) error
+		output          string
+		failed          bool
+		expectedArgs    []string
+		expectedLine    int
+		expectedCommand string
+	}{
+		{
+			name: "Restore set",
+			restore: func(runner Interface, path string) error {
+				return runner.RestoreSetFromFile(path, false)
+			},
+			expectedArgs: []string{"ipset", "restore"},
+		},
+		{
+			name: "Restore set ignoring exist",
+			restore: func(runner Interface, path string) error {
+				return runner.RestoreSetFromFile(path, true)
+			},
+			expectedArgs: []string{"ipset", "restore", "-exist"},
+		},
+		{
+			name: "Restore all sets ignoring exist",
+			restore: func(runner Interface, path string) error {
+				return runner.RestoreAllFromFile(path, true)
+			},
+			expectedArgs: []string{"ipset", "restore", "-exist"},
+		},
+		{
+			name: "Restore all sets failure",
+			restore: func(runner Interface, path string) error {
+				return runner.RestoreAllFromFile(path, false)
+			},
+			output:          "ipset v7.6: Error in line 3: Element cannot be added to the set: it's already added",
+			failed:          true,
+			expectedLine:    3,
+			expectedCommand: "add foo 172.18.3.3",
+		},
+	}
+
+	for _, c := range cases {
+		var script []byte
+
+		fcmd := fakeexec.FakeCmd{}
+		fcmd.CombinedOutputScript = []fakeexec.FakeAction{
+			func() ([]byte, []byte, error) {
+				script, _ = ioutil.ReadAll(fcmd.Stdin)
+
+				if c.failed {
+					return []byte(c.output), nil, &fakeexec.FakeExitError{Status: 1}
+				}
+
+				return []byte{}, nil, nil
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		err := c.restore(runner, path)
+		if c.failed {
+			var restoreErr *RestoreError
+			if !errors.As(err, &restoreErr) {
+				t.Errorf("[%s] expected restore error, got: %v", c.name, err)
+				continue
+			}
+
+			if restoreErr.Line != c.expectedLine ||
+				restoreErr.Command != c.expectedCommand {
+				t.Errorf("[%s] expected failed line %d %q, got: %d %q", c.name,
+					c.expectedLine, c.expectedCommand, restoreErr.Line,
+					restoreErr.Command)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog[0], c.expectedArgs) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog[0])
+		}
+
+		if string(script) != testBackupSave {
+			t.Errorf("[%s] expected script:\n%s\ngot:\n%s", c.name,
+				testBackupSave, script)
+		}
+	}
+}
+
+func TestSaveAndRestoreWithFileOption(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "ipset-file-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "backup")
+	err = ioutil.WriteFile(path, []byte(testBackupSave), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fcmd := fakeexec.FakeCmd{
+		CombinedOutputScript: []fakeexec.FakeAction{
+			// Save
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			// Restore
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			// Restore ignoring exist
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			// Restore failure
+			func() ([]byte, []byte, error) {
+				return []byte("ipset v7.6: Error in line 2: Syntax error: " +
+					"cannot parse 172.18.3.2: resolving to IPv4 address " +
+					"failed"), nil, &fakeexec.FakeExitError{Status: 1}
+			},
+			// Save failure
+			func() ([]byte, []byte, error) {
+				return []byte("ipset v7.6: Cannot open file: Permission denied"),
+					nil, &fakeexec.FakeExitError{Status: 1}
+			},
+		},
+	}
+
+	fexec := fakeexec.FakeExec{}
+	for range fcmd.CombinedOutputScript {
+		fexec.CommandScript = append(fexec.CommandScript,
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			})
+	}
+
+	runner := newInternal(&fexec, testIPSetLockfilePath)
+
+	if err := runner.SaveToFile(path); err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	if err := runner.RestoreFromFile(path, false); err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	if err := runner.RestoreFromFile(path, true); err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	expected := [][]string{
+		{"ipset", "save", "-file", path},
+		{"ipset", "restore", "-file", path},
+		{"ipset", "restore", "-file", path, "-exist"},
+	}
+	if !reflect.DeepEqual(fcmd.CombinedOutputLog, expected) {
+		t.Errorf("wrong CombinedOutput() log, got: %s", fcmd.CombinedOutputLog)
+	}
+
+	err = runner.RestoreFromFile(path, false)
+	var restoreErr *RestoreError
+	if !errors.As(err, &restoreErr) || restoreErr.Line != 2 ||
+		restoreErr.Command != `add foo 172.18.3.2 comment "ContainerID: deadbeaf"` {
+		t.Errorf("expected restore error of line 2, got: %v", err)
+	}
+
+	if err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("expected error with path %s, got: %v", path, err)
+	}
+
+	err = runner.SaveToFile(path)
+	if err == nil || !strings.Contains(err.Error(), path) {
+		t.Errorf("expected error with path %s, got: %v", path, err)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+// WithMetadataCache enables the cache of the set metadata, e.g. type, family
+// and sizes, keyed by set name. The cache is populated by CreateSet,
+// CreateSetAndAddEntries and GetSetHeader, and is used by the checks which
+// need the set metadata, e.g. the AddEntry type check, which is skipped for
+// the set not cached, instead of listing the set on every call. The sets changed outside the runner must be
+// invalidated by the caller with InvalidateCache.
+func WithMetadataCache() RunnerOption {
+	return func(runner *runner) {
+		runner.mu.Lock()
+		defer runner.mu.Unlock()
+
+		runner.cache = map[string]IPSetHeader{}
+	}
+}
+
+// InvalidateCache removes the cached metadata of the specified set name, it
+// does nothing when the cache is not enabled.
+func (runner *runner) InvalidateCache(setname SetName) {
+	runner.invalidateCache(string(setname))
+}
+
+// invalidateCache implements the invalidate cache.
+func (runner *runner) invalidateCache(setname string) {
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+
+	if runner.cache != nil {
+		delete(runner.cache, setname)
+	}
+}
+
+// cacheHeader stores the set header when the cache is enabled.
+func (runner *runner) cacheHeader(header *IPSetHeader) {
+	runner.mu.Lock()
+	defer runner.mu.Unlock()
+
+	if runner.cache != nil {
+		runner.cache[header.Name] = *header
+	}
+}
+
+// cacheSet stores the metadata of the set specification when the cache is
+// enabled, the omitted family is the ipset default inet.
+func (runner *runner) cacheSet(set *IPSet) {
+	header := &IPSetHeader{
+		Name:         set.Name,
+		SetType:      set.SetType,
+		HashFamily:   set.HashFamily,
+		HashSize:     set.HashSize,
+		MaxElement:   set.MaxElement,
+		BucketSize:   set.BucketSize,
+		Netmask:      set.Netmask,
+		Range:        set.Range,
+		Timeout:      set.Timeout,
+		WithCounters: set.WithCounters,
+		WithComment:  set.WithComment,
+		WithSkbinfo:  set.WithSkbinfo,
+	}
+
+	if len(header.HashFamily) == 0 && set.SetType.isHash() &&
+		set.SetType.hasFamily() {
+		header.HashFamily = ProtocolFamilyIPv4
+	}
+
+	runner.cacheHeader(header)
+}
+
+// lookupSetHeader returns the cached set header, or gets it from the kernel
+// when it is not cached. The caller holds the lock.
+func (runner *runner) lookupSetHeader(setname string) (*IPSetHeader, error) {
+	if header, ok := runner.cachedSetHeader(setname); ok {
+		return header, nil
+	}
+
+	return runner.getSetHeader(setname)
+}
+
+// cachedSetHeader returns the cached set header, false when it is not cached
+// or the cache is not enabled.
+func (runner *runner) cachedSetHeader(setname string) (*IPSetHeader, bool) {
+	runner.mu.RLock()
+	header, ok := runner.cache[setname]
+	runner.mu.RUnlock()
+
+	return &header, ok
+}
@@ -0,0 +1,153 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"k8s.io/utils/exec"
+	fakeexec "k8s.io/utils/exec/testing"
+)
+
+func TestMetadataCache(t *testing.T) {
+	header := `
+		<ipsets>
+			<ipset name="foo">
+				<type>hash:ip</type>
+				<header>
+					<family>inet6</family>
+					<hashsize>1024</hashsize>
+					<maxelem>65536</maxelem>
+				</header>
+				<members>
+				</members>
+			</ipset>
+		</ipsets>
+		`
+
+	fcmd := fakeexec.FakeCmd{
+		CombinedOutputScript: []fakeexec.FakeAction{
+			// Create
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			// Add
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			// Header, after the invalidation
+			func() ([]byte, []byte, error) { return []byte(header), nil, nil },
+			// Add
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+		},
+	}
+
+	fexec := fakeexec.FakeExec{}
+	for range fcmd.CombinedOutputScript {
+		fexec.CommandScript = append(fexec.CommandScript,
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			})
+	}
+
+	runner := newInternal(&fexec, testIPSetLockfilePath, WithFamilyCheck(),
+		WithMetadataCache())
+
+	err := runner.CreateSet(IPSetSpec(IPSetName("foo")), false)
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	// The cached family of the created set is used, no set header listing.
+	err = runner.AddEntry(&IPSetEntry{Element: "2001:db8::1"}, "foo", false)
+	var entryErr *EntryError
+	if !errors.As(err, &entryErr) || entryErr.Field != "family" {
+		t.Errorf("expected family error, got: %v", err)
+	}
+
+	err = runner.AddEntry(&IPSetEntry{Element: "172.18.3.2"}, "foo", false)
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	// The set is recreated outside the runner as the inet6 set.
+	runner.InvalidateCache("foo")
+
+	err = runner.AddEntry(&IPSetEntry{Element: "2001:db8::1"}, "foo", false)
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	expected := [][]string{
+		{"ipset", "create", "foo", "hash:ip", "family", "inet", "hashsize",
+			testDefaultHashSize, "maxelem", testDefaultMaxElement, "-o", "xml"},
+		{"ipset", "add", "foo", "172.18.3.2", "-o", "xml"},
+		{"ipset", "list", "foo", "-t", "-o", "xml"},
+		{"ipset", "add", "foo", "2001:db8::1", "-o", "xml"},
+	}
+	if !reflect.DeepEqual(fcmd.CombinedOutputLog, expected) {
+		t.Errorf("wrong CombinedOutput() log, got: %s", fcmd.CombinedOutputLog)
+	}
+}
+
+func TestMetadataCacheInvalidation(t *testing.T) {
+	set := IPSetSpec(IPSetName("foo"), IPSetType(HashNet))
+
+	cases := []struct {
+		name       string
+		invalidate func(runner Interface) error
+	}{
+		{
+			name: "Destroy set",
+			invalidate: func(runner Interface) error {
+				return runner.DestroySet("foo")
+			},
+		},
+		{
+			name: "Rename set",
+			invalidate: func(runner Interface) error {
+				return runner.RenameSet("foo", "bar")
+			},
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				// Create
+				func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+				// Invalidate
+				func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			},
+		}
+
+		fexec := fakeexec.FakeExec{}
+		for range fcmd.CombinedOutputScript {
+			fexec.CommandScript = append(fexec.CommandScript,
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				})
+		}
+
+		r := newInternal(&fexec, testIPSetLockfilePath, WithMetadataCache())
+
+		err := r.CreateSet(set, false)
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		cached, ok := r.(*runner).cache["foo"]
+		if !ok || cached.HashFamily != ProtocolFamilyIPv4 {
+			t.Errorf("[%s] expected cached inet set, got: %+v", c.name, cached)
+		}
+
+		err = c.invalidate(r)
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if _, ok := r.(*runner).cache["foo"]; ok {
+			t.Errorf("[%s] expected invalidated cache", c.name)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ListEntriesByComment list the entries of the specified set name whose
+// comment contains the substring, e.g. the owner token "ContainerID: X". The
+// match is the case-sensitive exact substring of the unquoted comment, the
+// empty substring matches every entry.
+func (runner *runner) ListEntriesByComment(setname SetName,
+	substring string) ([]IPSetEntry, error) {
+	entries, err := runner.ListEntries(setname)
+	if err != nil {
+		return nil, err
+	}
+
+	return FilterEntriesByComment(entries, substring), nil
+}
+
+// DelEntriesByComment deletes the entries of the specified set name whose
+// comment contains the substring, see ListEntriesByComment, and returns the
+// number of deleted entries. The empty substring is rejected rather than
+// deleting every entry, use FlushSet for that. The entries deleted before a
+// failure stay deleted and are counted.
+func (runner *runner) DelEntriesByComment(setname SetName,
+	substring string) (int, error) {
+	if len(setname) == 0 {
+		return 0, ErrEmptySetName
+	}
+
+	if len(substring) == 0 {
+		return 0, fmt.Errorf("error deleting entries of set %s by comment, "+
+			"error: empty comment substring", setname)
+	}
+
+	err := runner.locker.Lock()
+	if err != nil {
+		return 0, err
+	}
+	defer runner.locker.Unlock()
+
+	set, err := runner.listSet(string(setname))
+	if err != nil {
+		return 0, fmt.Errorf("error deleting entries of set %s by comment, "+
+			"error: %w", setname, err)
+	}
+
+	deleted := 0
+	for _, entry := range FilterEntriesByComment(set.Entries, substring) {
+		err = runner.delEntry(entry.element(), string(setname))
+		if err != nil {
+			return deleted, fmt.Errorf("error deleting entries of set %s by "+
+				"comment, error: %v", setname, err)
+		}
+
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// CommentEnabled checks whether the specified set name is created with the
+// comment option, the <comment/> of the set header, so the entry comment
+// could be added. The set header is taken from the metadata cache if it is
+// enabled, see WithMetadataCache. ErrSetNotFound is returned when the set
+// does not exist.
+func (runner *runner) CommentEnabled(setname SetName) (bool, error) {
+	if len(setname) == 0 {
+		return false, ErrEmptySetName
+	}
+
+	err := runner.locker.Lock()
+	if err != nil {
+		return false, err
+	}
+	defer runner.locker.Unlock()
+
+	header, err := runner.lookupSetHeader(string(setname))
+	if err != nil {
+		return false, fmt.Errorf("error checking set %s comment, error: %w",
+			setname, err)
+	}
+
+	return header.WithComment, nil
+}
+
+// FilterEntriesByComment returns the entries whose comment contains the
+// substring, the match is case-sensitive.
+func FilterEntriesByComment(entries []IPSetEntry,
+	substring string) []IPSetEntry {
+	filtered := []IPSetEntry{}
+	for _, entry := range entries {
+		if strings.Contains(entry.Comment, substring) {
+			filtered = append(filtered, entry)
+		}
+	}
+
+	return filtered
+}
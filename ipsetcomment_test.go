@@ -0,0 +1,278 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/utils/exec"
+	fakeexec "k8s.io/utils/exec/testing"
+)
+
+const testCommentListOutput = `
+<ipsets>
+	<ipset name="foo">
+		<type>hash:ip</type>
+		<revision>4</revision>
+		<header>
+			<family>inet</family>
+			<hashsize>1024</hashsize>
+			<maxelem>65536</maxelem>
+			<comment/>
+			<memsize>472</memsize>
+			<references>0</references>
+			<numentries>3</numentries>
+		</header>
+		<members>
+			<member>
+				<elem>172.18.3.2</elem>
+				<comment>"ContainerID: deadbeaf"</comment>
+			</member>
+			<member>
+				<elem>172.18.3.3</elem>
+				<comment>"ContainerID: cafebabe"</comment>
+			</member>
+			<member>
+				<elem>172.18.3.4</elem>
+			</member>
+		</members>
+	</ipset>
+</ipsets>
+`
+
+func TestListEntriesByComment(t *testing.T) {
+	cases := []struct {
+		name      string
+		substring string
+		expected  []IPSetEntry
+	}{
+		{
+			name:      "Owner match",
+			substring: "ContainerID: deadbeaf",
+			expected: []IPSetEntry{
+				{Element: "172.18.3.2", Comment: "ContainerID: deadbeaf"},
+			},
+		},
+		{
+			name:      "Substring match",
+			substring: "ContainerID",
+			expected: []IPSetEntry{
+				{Element: "172.18.3.2", Comment: "ContainerID: deadbeaf"},
+				{Element: "172.18.3.3", Comment: "ContainerID: cafebabe"},
+			},
+		},
+		{
+			name:      "Case-sensitive",
+			substring: "containerid",
+			expected:  []IPSetEntry{},
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				func() ([]byte, []byte, error) {
+					return []byte(testCommentListOutput), nil, nil
+				},
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		entries, err := runner.ListEntriesByComment("foo", c.substring)
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !reflect.DeepEqual(entries, c.expected) {
+			t.Errorf("[%s] expected entries: %+v, got: %+v", c.name,
+				c.expected, entries)
+		}
+	}
+}
+
+func TestDelEntriesByComment(t *testing.T) {
+	cases := []struct {
+		name              string
+		substring         string
+		delFailed         bool
+		combinedOutputLog [][]string
+		expected          int
+		expectedError     bool
+	}{
+		{
+			name:      "Delete by owner",
+			substring: "ContainerID: deadbeaf",
+			combinedOutputLog: [][]string{
+				{"ipset", "list", "foo", "-o", "xml"},
+				{"ipset", "del", "foo", "172.18.3.2", "-o", "xml"},
+			},
+			expected: 1,
+		},
+		{
+			name:      "Delete all tagged",
+			substring: "ContainerID",
+			combinedOutputLog: [][]string{
+				{"ipset", "list", "foo", "-o", "xml"},
+				{"ipset", "del", "foo", "172.18.3.2", "-o", "xml"},
+				{"ipset", "del", "foo", "172.18.3.3", "-o", "xml"},
+			},
+			expected: 2,
+		},
+		{
+			name:      "No match",
+			substring: "ContainerID: unknown",
+			combinedOutputLog: [][]string{
+				{"ipset", "list", "foo", "-o", "xml"},
+			},
+		},
+		{
+			name:      "Delete failure",
+			substring: "ContainerID",
+			delFailed: true,
+			combinedOutputLog: [][]string{
+				{"ipset", "list", "foo", "-o", "xml"},
+				{"ipset", "del", "foo", "172.18.3.2", "-o", "xml"},
+			},
+			expectedError: true,
+		},
+		{
+			name:          "Empty substring",
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				func() ([]byte, []byte, error) {
+					return []byte(testCommentListOutput), nil, nil
+				},
+			},
+		}
+
+		for i := 0; i < 2; i++ {
+			fcmd.CombinedOutputScript = append(fcmd.CombinedOutputScript,
+				func() ([]byte, []byte, error) {
+					if c.delFailed {
+						return []byte("ipset v7.6: Element cannot be deleted " +
+								"from the set: it's not added"), nil,
+							&fakeexec.FakeExitError{Status: 1}
+					}
+
+					return []byte{}, nil, nil
+				})
+		}
+
+		fexec := fakeexec.FakeExec{}
+		for range fcmd.CombinedOutputScript {
+			fexec.CommandScript = append(fexec.CommandScript,
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				})
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		deleted, err := runner.DelEntriesByComment("foo", c.substring)
+		if c.expectedError && err == nil {
+			t.Errorf("[%s] expected failure, got: nil", c.name)
+		}
+
+		if !c.expectedError && err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if deleted != c.expected {
+			t.Errorf("[%s] expected %d deleted, got: %d", c.name, c.expected,
+				deleted)
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog, c.combinedOutputLog) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog)
+		}
+	}
+}
+
+func TestCommentEnabled(t *testing.T) {
+	cases := []struct {
+		name          string
+		output        string
+		failed        bool
+		expected      bool
+		expectedError bool
+	}{
+		{
+			name:     "Comment enabled",
+			output:   testCommentListOutput,
+			expected: true,
+		},
+		{
+			name:   "Comment disabled",
+			output: strings.Replace(testCommentListOutput, "<comment/>", "", 1),
+		},
+		{
+			name:          "Set not found",
+			output:        "ipset v7.6: The set with the given name does not exist",
+			failed:        true,
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				func() ([]byte, []byte, error) {
+					if c.failed {
+						return []byte(c.output), nil,
+							&fakeexec.FakeExitError{Status: 1}
+					}
+
+					return []byte(c.output), nil, nil
+				},
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		enabled, err := runner.CommentEnabled("foo")
+		if c.expectedError {
+			if !errors.Is(err, ErrSetNotFound) {
+				t.Errorf("[%s] expected ErrSetNotFound, got: %v", c.name, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if enabled != c.expected {
+			t.Errorf("[%s] expected comment enabled %v, got: %v", c.name,
+				c.expected, enabled)
+		}
+	}
+}
@@ -0,0 +1,699 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupportedType is returned when there is no element formatter or parser
+// for the set type.
+var ErrUnsupportedType = errors.New("unsupported set type")
+
+// EntryError represents the entry validation error, Field names the part of
+// the entry which failed, e.g. "ip", "port".
+type EntryError struct {
+	Field  string
+	Value  string
+	Reason string
+}
+
+func (e *EntryError) Error() string {
+	return fmt.Sprintf("invalid entry %s %q: %s", e.Field, e.Value, e.Reason)
+}
+
+// entryElementCodec holds the type-specific element formatter, parser and
+// canonicalizer.
+type entryElementCodec struct {
+	format       func(entry *IPSetEntry) (string, error)
+	parse        func(element string) (*IPSetEntry, error)
+	canonicalize func(element string) (string, error)
+}
+
+// newEntryElementCodec returns the codec of the element which is used as is
+// once validated, the canonical form is the best effort, the element which
+// could not be parsed is returned as is, and is then validated.
+func newEntryElementCodec(validate func(element string) error,
+	canonical func(element string) string) entryElementCodec {
+	return entryElementCodec{
+		format: func(entry *IPSetEntry) (string, error) {
+			if err := validate(entry.Element); err != nil {
+				return "", err
+			}
+
+			return entry.Element, nil
+		},
+		parse: func(element string) (*IPSetEntry, error) {
+			if err := validate(element); err != nil {
+				return nil, err
+			}
+
+			return &IPSetEntry{Element: element}, nil
+		},
+		canonicalize: func(element string) (string, error) {
+			element = canonical(element)
+			if err := validate(element); err != nil {
+				return "", err
+			}
+
+			return element, nil
+		},
+	}
+}
+
+// entryElementCodecs maps the set type to its element formatter and parser.
+var entryElementCodecs = map[Type]entryElementCodec{
+	HashIP: newEntryElementCodec(validateIPAddrElement, canonicalIP),
+	HashNet: newEntryElementCodec(validateNetElement,
+		canonicalNet),
+	HashNetPort: newEntryElementCodec(validateNetPortElement,
+		canonicalParts(canonicalNet, canonicalProtoPort)),
+	HashIPPort: newEntryElementCodec(validateIPPortElement,
+		canonicalParts(canonicalIP, canonicalProtoPort)),
+	HashIPPortIP: newEntryElementCodec(validateIPPortIPElement,
+		canonicalParts(canonicalIP, canonicalProtoPort, canonicalIP)),
+	HashMAC: newEntryElementCodec(validateMACElement, canonicalMAC),
+	HashNetNet: newEntryElementCodec(validateNetNetElement,
+		canonicalParts(canonicalNet, canonicalNet)),
+	HashNetIface: newEntryElementCodec(validateNetIfaceElement,
+		canonicalParts(canonicalNet, canonicalAsIs)),
+	BitmapPort: newEntryElementCodec(validatePortRangeElement,
+		canonicalPortRange),
+	ListSet: newEntryElementCodec(validateSetNameElement, canonicalAsIs),
+}
+
+// FormatEntryElement formats the entry element for the given set type.
+func FormatEntryElement(entry *IPSetEntry, setType Type) (string, error) {
+	if entry == nil {
+		return "", fmt.Errorf("error formatting element, error: nil entry")
+	}
+
+	codec, ok := entryElementCodecs[setType]
+	if !ok {
+		return "", fmt.Errorf("error formatting element %s, error: %w %s",
+			entry.Element, ErrUnsupportedType, setType)
+	}
+
+	return codec.format(entry)
+}
+
+// ValidateEntryForSet checks if a given entry is valid for the set type. The
+// hash:net entry with host bits set, e.g. 192.168.1.1/24, is rejected rather
+// than normalized, so the stored element is always the one the caller holds.
+// The hash:ip entry is the host address, also for the set created with the
+// netmask option, e.g. 192.168.1.100 of the netmask 24 set, which ipset
+// masks to the network itself.
+func ValidateEntryForSet(entry *IPSetEntry, setType Type) error {
+	_, err := FormatEntryElement(entry, setType)
+	return err
+}
+
+// ParseEntryElement parses the element string of the given set type into an
+// entry.
+func ParseEntryElement(element string, setType Type) (*IPSetEntry, error) {
+	codec, ok := entryElementCodecs[setType]
+	if !ok {
+		return nil, fmt.Errorf("error parsing element %s, error: %w %s",
+			element, ErrUnsupportedType, setType)
+	}
+
+	return codec.parse(element)
+}
+
+// CanonicalizeElement returns the canonical form of the element of the given
+// set type, the one listed by ipset, so the elements could be compared, e.g.
+// by Difference, and added without the spurious diff of the reconcile loop.
+// The IPv6 address is compressed, e.g. 0:0:0:0:0:0:0:1 is ::1, the host bits
+// of the network are zeroed, e.g. 10.0.0.5/24 is 10.0.0.0/24, the host
+// network is the address, e.g. 10.0.0.1/32 is 10.0.0.1, the protocol is
+// lowercased and defaults to tcp, e.g. 80 is tcp:80, the port range of the
+// single port is the port, e.g. 80-80 is 80, and the MAC address is
+// uppercased. The canonical element is validated for the set type.
+func CanonicalizeElement(element string, setType Type) (string, error) {
+	codec, ok := entryElementCodecs[setType]
+	if !ok {
+		return "", fmt.Errorf("error canonicalizing element %s, error: %w %s",
+			element, ErrUnsupportedType, setType)
+	}
+
+	return codec.canonicalize(element)
+}
+
+// canonicalAsIs returns the element as is.
+func canonicalAsIs(element string) string {
+	return element
+}
+
+// canonicalParts returns the canonicalizer of the element of the comma
+// separated parts, each part is canonicalized by its canonicalizer, the
+// element of the other number of parts is returned as is.
+func canonicalParts(canonicals ...func(part string) string) func(
+	element string) string {
+	return func(element string) string {
+		parts := strings.SplitN(element, ",", len(canonicals))
+		if len(parts) != len(canonicals) {
+			return element
+		}
+
+		for i, canonical := range canonicals {
+			parts[i] = canonical(parts[i])
+		}
+
+		return strings.Join(parts, ",")
+	}
+}
+
+// canonicalIP returns the IP address in its shortest form, the IPv4-mapped
+// IPv6 address is kept in the IPv6 form.
+func canonicalIP(s string) string {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return s
+	}
+
+	if ip4 := ip.To4(); ip4 != nil && strings.Contains(s, ":") {
+		return "::ffff:" + ip4.String()
+	}
+
+	return ip.String()
+}
+
+// canonicalNet returns the network with the host bits zeroed, the host
+// network, e.g. 10.0.0.1/32, is the address as listed by ipset.
+func canonicalNet(s string) string {
+	if !strings.Contains(s, "/") {
+		return canonicalIP(s)
+	}
+
+	ip, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return s
+	}
+
+	if ones, bits := ipnet.Mask.Size(); ones == bits {
+		return canonicalIP(ip.String())
+	}
+
+	return ipnet.String()
+}
+
+// canonicalProtoPort returns the proto:port part with the lowercased
+// protocol, tcp if it is omitted, and the canonical port range.
+func canonicalProtoPort(s string) string {
+	proto, port := "tcp", s
+	if idx := strings.Index(s, ":"); idx >= 0 {
+		proto, port = strings.ToLower(s[:idx]), s[idx+1:]
+	}
+
+	switch proto {
+	case "tcp", "udp", "sctp", "udplite":
+		port = canonicalPortRange(port)
+	}
+
+	return proto + ":" + port
+}
+
+// canonicalPortRange returns the port range without the leading zeros, the
+// range of the single port is the port.
+func canonicalPortRange(s string) string {
+	pr, err := ParsePortRange(s)
+	if err != nil {
+		return s
+	}
+
+	return pr.String()
+}
+
+// canonicalMAC returns the colon separated uppercase MAC address.
+func canonicalMAC(s string) string {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		return s
+	}
+
+	return strings.ToUpper(mac.String())
+}
+
+// validateIPElement checks the single IP address part of the element.
+func validateIPElement(element string) error {
+	if net.ParseIP(element) == nil {
+		return &EntryError{"ip", element, "not an IP address"}
+	}
+
+	return nil
+}
+
+// validateIPAddrElement checks the hash:ip element, the IP address, the
+// network, e.g. 10.0.0.0/24, or the range, e.g. 10.0.0.1-10.0.0.5, which
+// ipset adds as the addresses it covers.
+func validateIPAddrElement(element string) error {
+	if !isIPOrCIDR(element) && !isIPRange(element) {
+		return &EntryError{"ip", element,
+			"not an IP address, network or range"}
+	}
+
+	return nil
+}
+
+// validateNetElement checks the hash:net element, the network or the range
+// of addresses, the host network is listed by ipset without the prefix
+// length.
+func validateNetElement(element string) error {
+	if isIPRange(element) {
+		return nil
+	}
+
+	if !isIPOrCIDR(element) {
+		return &EntryError{"net", element, "not a network address"}
+	}
+
+	return validateNetworkBits(element)
+}
+
+// validateNetPortElement checks the hash:net,port element, the protocol is
+// optional and defaults to tcp, e.g. 10.0.0.0/24,udp:53 or 10.0.0.0/24,80.
+func validateNetPortElement(element string) error {
+	parts := strings.SplitN(element, ",", 2)
+	if len(parts) != 2 {
+		return &EntryError{"element", element, "should be net,[proto:]port"}
+	}
+
+	if err := validateNetElement(parts[0]); err != nil {
+		return err
+	}
+
+	return validateProtoPort(parts[1])
+}
+
+// PolicyEntry returns the hash:net,port entry of the network policy rule,
+// the CIDR, the protocol tcp, udp or sctp, case-insensitive and tcp if empty,
+// and the port 1-65535. The port 0, all ports, is rejected, ipset would add
+// the range 0-65535 as an element per port, the rule of all ports should be
+// the CIDR entry of a hash:net set instead.
+func PolicyEntry(cidr string, proto string, port int32) (*IPSetEntry, error) {
+	proto = strings.ToLower(proto)
+	if len(proto) == 0 {
+		proto = "tcp"
+	}
+
+	switch proto {
+	case "tcp", "udp", "sctp":
+	default:
+		return nil, &EntryError{"proto", proto, "should be tcp, udp or sctp"}
+	}
+
+	if port == 0 {
+		return nil, &EntryError{"port", "0",
+			"all ports should be the network of a hash:net set"}
+	}
+
+	if port < 0 || port > 65535 {
+		return nil, &EntryError{"port", strconv.Itoa(int(port)),
+			"should be 1-65535"}
+	}
+
+	entry := &IPSetEntry{Element: cidr + "," + proto + ":" +
+		strconv.Itoa(int(port))}
+
+	if err := validateNetPortElement(entry.Element); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// validateIPPortElement checks the hash:ip,port element, the protocol is
+// optional and defaults to tcp, e.g. 192.168.1.1,udp:53 or 192.168.1.1,80.
+func validateIPPortElement(element string) error {
+	parts := strings.SplitN(element, ",", 2)
+	if len(parts) != 2 {
+		return &EntryError{"element", element, "should be ip,[proto:]port"}
+	}
+
+	if err := validateIPAddrElement(parts[0]); err != nil {
+		return err
+	}
+
+	return validateProtoPort(parts[1])
+}
+
+// validateIPPortIPElement checks the hash:ip,port,ip element, e.g.
+// 1.1.1.1,tcp:80,2.2.2.2, both IP addresses should be of the same family,
+// which is checked against the set family by AddEntry, see WithFamilyCheck.
+func validateIPPortIPElement(element string) error {
+	parts := strings.Split(element, ",")
+	if len(parts) != 3 {
+		return &EntryError{"element", element, "should be ip,[proto:]port,ip"}
+	}
+
+	if err := validateIPAddrElement(parts[0]); err != nil {
+		return err
+	}
+
+	if err := validateIPElement(parts[2]); err != nil {
+		return err
+	}
+
+	if elementFamily(parts[0]) != elementFamily(parts[2]) {
+		return &EntryError{"family", element,
+			"addresses should be of the same family"}
+	}
+
+	return validateProtoPort(parts[1])
+}
+
+// IPPortIPEntry returns the hash:ip,port,ip entry of the source IP address,
+// the [proto:]port, e.g. tcp:80, and the destination IP address.
+func IPPortIPEntry(srcIP, protoPort, dstIP string) (*IPSetEntry, error) {
+	entry := &IPSetEntry{Element: srcIP + "," + protoPort + "," + dstIP}
+
+	if err := validateIPPortIPElement(entry.Element); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// validateNetNetElement checks the hash:net,net element, e.g.
+// 10.0.0.0/24,192.168.0.0/16.
+func validateNetNetElement(element string) error {
+	parts := strings.Split(element, ",")
+	if len(parts) != 2 {
+		return &EntryError{"element", element, "should be net,net"}
+	}
+
+	for _, part := range parts {
+		if err := validateNetElement(part); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NetNetEntry returns the hash:net,net entry of the source and destination
+// networks.
+func NetNetEntry(srcCIDR, dstCIDR string) (*IPSetEntry, error) {
+	entry := &IPSetEntry{Element: srcCIDR + "," + dstCIDR}
+
+	if err := validateNetNetElement(entry.Element); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// MaxIfaceNameLength defines the maximum length of the interface name,
+// IFNAMSIZ-1.
+const MaxIfaceNameLength = 15
+
+// FormatIPIfaceElement returns the hash:ip,iface element of the IP address and
+// the interface name, e.g. 10.0.0.1,eth0.
+func FormatIPIfaceElement(ip, iface string) string {
+	return ip + "," + iface
+}
+
+// ParseIPIfaceElement parses the hash:ip,iface element into the IP address
+// and the interface name.
+func ParseIPIfaceElement(s string) (ip, iface string, err error) {
+	parts := strings.SplitN(s, ",", 2)
+	if len(parts) != 2 {
+		return "", "", &EntryError{"element", s, "should be ip,iface"}
+	}
+
+	if err := validateIPElement(parts[0]); err != nil {
+		return "", "", err
+	}
+
+	if err := validateIfaceName(parts[1]); err != nil {
+		return "", "", err
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// FormatNetIfaceElement returns the hash:net,iface element of the network
+// and the interface name, e.g. 10.0.0.0/24,eth0.
+func FormatNetIfaceElement(cidr, iface string) string {
+	return cidr + "," + iface
+}
+
+// ParseNetIfaceElement parses the hash:net,iface element into the network
+// and the interface name, the host network is listed by ipset without the
+// prefix length.
+func ParseNetIfaceElement(s string) (cidr, iface string, err error) {
+	parts := strings.SplitN(s, ",", 2)
+	if len(parts) != 2 {
+		return "", "", &EntryError{"element", s, "should be net,iface"}
+	}
+
+	if err := validateNetElement(parts[0]); err != nil {
+		return "", "", err
+	}
+
+	if err := validateIfaceName(parts[1]); err != nil {
+		return "", "", err
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// validateNetIfaceElement checks the hash:net,iface element.
+func validateNetIfaceElement(element string) error {
+	_, _, err := ParseNetIfaceElement(element)
+	return err
+}
+
+// validateIfaceName checks the interface name, it should be 1-15 characters
+// without whitespaces, "/" or ",".
+func validateIfaceName(iface string) error {
+	if len(iface) == 0 || len(iface) > MaxIfaceNameLength {
+		return &EntryError{"iface", iface,
+			fmt.Sprintf("should be 1-%d characters", MaxIfaceNameLength)}
+	}
+
+	if strings.ContainsAny(iface, " \t\n/,") {
+		return &EntryError{"iface", iface, "contains invalid character"}
+	}
+
+	return nil
+}
+
+// PortRange represents the port or the port range of the bitmap:port entry,
+// the single port has the same Start and End.
+type PortRange struct {
+	Start int
+	End   int
+}
+
+// String returns the element of the port range, e.g. 80 for the single port
+// or 80-443 for the range.
+func (pr PortRange) String() string {
+	if pr.Start == pr.End {
+		return strconv.Itoa(pr.Start)
+	}
+
+	return strconv.Itoa(pr.Start) + "-" + strconv.Itoa(pr.End)
+}
+
+// ValidatePortRange checks if the port range is valid, the ports should be
+// 0-65535 and the Start should not be greater than the End.
+func ValidatePortRange(pr PortRange) error {
+	for _, port := range []int{pr.Start, pr.End} {
+		if port < 0 || port > 65535 {
+			return &EntryError{"port", pr.String(), "should be 0-65535"}
+		}
+	}
+
+	if pr.Start > pr.End {
+		return &EntryError{"port", pr.String(),
+			"start should not be greater than end"}
+	}
+
+	return nil
+}
+
+// ParsePortRange parses the bitmap:port element, e.g. 80 or 80-443, into the
+// port range.
+func ParsePortRange(s string) (PortRange, error) {
+	bounds := strings.SplitN(s, "-", 2)
+
+	ports := make([]int, 0, 2)
+	for _, bound := range bounds {
+		port, err := strconv.ParseUint(bound, 10, 16)
+		if err != nil {
+			return PortRange{}, &EntryError{"port", s, "should be 0-65535"}
+		}
+
+		ports = append(ports, int(port))
+	}
+
+	pr := PortRange{Start: ports[0], End: ports[len(ports)-1]}
+
+	return pr, ValidatePortRange(pr)
+}
+
+// validatePortRangeElement checks the bitmap:port element.
+func validatePortRangeElement(element string) error {
+	_, err := ParsePortRange(element)
+	return err
+}
+
+// validateMACElement checks the hash:mac element.
+func validateMACElement(element string) error {
+	mac, err := net.ParseMAC(element)
+	if err != nil || len(mac) != 6 {
+		return &EntryError{"mac", element, "not an Ethernet MAC address"}
+	}
+
+	return nil
+}
+
+// validateSetNameElement checks the list:set element.
+func validateSetNameElement(element string) error {
+	if err := ValidateSetName(element); err != nil {
+		return &EntryError{"set", element, err.Error()}
+	}
+
+	return nil
+}
+
+// validateProtoPort checks the [proto:]port part of the element, the port
+// could be a range or a service name, e.g. tcp:http, for tcp, udp, sctp and
+// udplite. Any other protocol, by
+// name or number, e.g. gre:0 or 47:0, is accepted with the port 0.
+func validateProtoPort(s string) error {
+	proto, port := "tcp", s
+	if idx := strings.Index(s, ":"); idx >= 0 {
+		proto, port = s[:idx], s[idx+1:]
+	}
+
+	switch proto {
+	case "tcp", "udp", "sctp", "udplite":
+	case "icmp", "icmpv6":
+		if len(port) == 0 {
+			return &EntryError{"port", s, "missing icmp type"}
+		}
+
+		return nil
+	default:
+		if len(proto) == 0 || strings.ContainsAny(proto, " \t\n,") {
+			return &EntryError{"proto", proto, "invalid protocol"}
+		}
+
+		if port != "0" {
+			return &EntryError{"proto", proto,
+				"unsupported protocol of port " + port + ", should be port 0"}
+		}
+
+		return nil
+	}
+
+	if isServiceName(port) {
+		return nil
+	}
+
+	bounds := strings.SplitN(port, "-", 2)
+	numbers := make([]uint64, 0, len(bounds))
+	for _, bound := range bounds {
+		if isServiceName(bound) {
+			continue
+		}
+
+		number, err := strconv.ParseUint(bound, 10, 16)
+		if err != nil {
+			return &EntryError{"port", port, "should be 0-65535"}
+		}
+
+		numbers = append(numbers, number)
+	}
+
+	if len(numbers) == 2 && numbers[0] > numbers[1] {
+		return &EntryError{"port", port,
+			"start should not be greater than end"}
+	}
+
+	return nil
+}
+
+// isServiceName checks if a given port is the service name, which ipset
+// resolves by the services database, e.g. http, the name starts with a
+// letter.
+func isServiceName(port string) bool {
+	if len(port) == 0 ||
+		!(port[0] >= 'a' && port[0] <= 'z' || port[0] >= 'A' && port[0] <= 'Z') {
+		return false
+	}
+
+	return !strings.ContainsAny(port, " \t\n,:/")
+}
+
+// validateNetworkBits checks that a given CIDR has no host bits set, a plain
+// IP address is a host network and always valid.
+func validateNetworkBits(s string) error {
+	ip, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil
+	}
+
+	if !ip.Equal(ipnet.IP) {
+		return &EntryError{"net", s,
+			fmt.Sprintf("host bits are set, should be %s", ipnet.String())}
+	}
+
+	return nil
+}
+
+// elementFamily returns the protocol family of the first address of the
+// element, the start of the range, or empty if the element does not start with an address, e.g. MAC.
+func elementFamily(element string) string {
+	addr := strings.SplitN(element, ",", 2)[0]
+	if idx := strings.IndexAny(addr, "/-"); idx >= 0 {
+		addr = addr[:idx]
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return ""
+	}
+
+	if ip.To4() != nil {
+		return ProtocolFamilyIPv4
+	}
+
+	return ProtocolFamilyIPv6
+}
+
+// isIPOrCIDR checks if a given string is an IP address or a CIDR.
+func isIPOrCIDR(s string) bool {
+	if net.ParseIP(s) != nil {
+		return true
+	}
+
+	_, _, err := net.ParseCIDR(s)
+	return err == nil
+}
+
+// isIPRange checks if a given string is the from-to range of the IP
+// addresses of the same family, e.g. 10.0.0.1-10.0.0.5.
+func isIPRange(s string) bool {
+	bounds := strings.SplitN(s, "-", 2)
+	if len(bounds) != 2 {
+		return false
+	}
+
+	from, to := net.ParseIP(bounds[0]), net.ParseIP(bounds[1])
+	if from == nil || to == nil ||
+		(from.To4() == nil) != (to.To4() == nil) {
+		return false
+	}
+
+	return bytes.Compare(from.To16(), to.To16()) <= 0
+}
@@ -0,0 +1,1088 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormatEntryElement(t *testing.T) {
+	cases := []struct {
+		name          string
+		entry         *IPSetEntry
+		setType       Type
+		expected      string
+		expectedError bool
+	}{
+		{
+			name:     "hash:ip IPv4 element",
+			entry:    &IPSetEntry{Element: "172.18.3.2"},
+			setType:  HashIP,
+			expected: "172.18.3.2",
+		},
+		{
+			name:     "hash:ip IPv6 element",
+			entry:    &IPSetEntry{Element: "2001:db8::1"},
+			setType:  HashIP,
+			expected: "2001:db8::1",
+		},
+		{
+			name:     "hash:ip network element",
+			entry:    &IPSetEntry{Element: "172.18.3.0/24"},
+			setType:  HashIP,
+			expected: "172.18.3.0/24",
+		},
+		{
+			name:     "hash:ip range element",
+			entry:    &IPSetEntry{Element: "172.18.3.1-172.18.3.5"},
+			setType:  HashIP,
+			expected: "172.18.3.1-172.18.3.5",
+		},
+		{
+			name:          "hash:ip reversed range element",
+			entry:         &IPSetEntry{Element: "172.18.3.5-172.18.3.1"},
+			setType:       HashIP,
+			expectedError: true,
+		},
+		{
+			name:          "hash:ip invalid element",
+			entry:         &IPSetEntry{Element: "172.18.3"},
+			setType:       HashIP,
+			expectedError: true,
+		},
+		{
+			name:     "hash:net CIDR element",
+			entry:    &IPSetEntry{Element: "172.18.3.0/24"},
+			setType:  HashNet,
+			expected: "172.18.3.0/24",
+		},
+		{
+			name:     "hash:net host element",
+			entry:    &IPSetEntry{Element: "172.18.3.2"},
+			setType:  HashNet,
+			expected: "172.18.3.2",
+		},
+		{
+			name:          "hash:net invalid element",
+			entry:         &IPSetEntry{Element: "foo"},
+			setType:       HashNet,
+			expectedError: true,
+		},
+		{
+			name:          "nil entry",
+			entry:         nil,
+			setType:       HashIP,
+			expectedError: true,
+		},
+		{
+			name:          "unsupported type",
+			entry:         &IPSetEntry{Element: "172.18.3.2"},
+			setType:       "bitmap:ip",
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		element, err := FormatEntryElement(c.entry, c.setType)
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if element != c.expected {
+			t.Errorf("[%s] expected element: %s, got: %s", c.name, c.expected,
+				element)
+		}
+	}
+}
+
+func TestParseEntryElement(t *testing.T) {
+	cases := []struct {
+		name          string
+		element       string
+		setType       Type
+		expected      string
+		expectedError bool
+	}{
+		{
+			name:     "hash:ip element",
+			element:  "172.18.3.2",
+			setType:  HashIP,
+			expected: "172.18.3.2",
+		},
+		{
+			name:          "hash:ip invalid element",
+			element:       "172.18.3",
+			setType:       HashIP,
+			expectedError: true,
+		},
+		{
+			name:     "hash:net element",
+			element:  "2001:db8::/64",
+			setType:  HashNet,
+			expected: "2001:db8::/64",
+		},
+		{
+			name:          "hash:net invalid element",
+			element:       "2001:db8::/129",
+			setType:       HashNet,
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		entry, err := ParseEntryElement(c.element, c.setType)
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if entry.Element != c.expected {
+			t.Errorf("[%s] expected element: %s, got: %s", c.name, c.expected,
+				entry.Element)
+		}
+	}
+
+	_, err := ParseEntryElement("172.18.3.2", "bitmap:ip")
+	if !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("expected unsupported type error, got: %v", err)
+	}
+}
+
+func TestValidateEntryForSet(t *testing.T) {
+	cases := []struct {
+		name          string
+		entry         *IPSetEntry
+		setType       Type
+		expectedError bool
+	}{
+		{
+			name:    "hash:net clean IPv4 CIDR",
+			entry:   &IPSetEntry{Element: "192.168.1.0/24"},
+			setType: HashNet,
+		},
+		{
+			name:          "hash:net IPv4 CIDR with host bits",
+			entry:         &IPSetEntry{Element: "192.168.1.1/24"},
+			setType:       HashNet,
+			expectedError: true,
+		},
+		{
+			name:    "hash:net clean IPv6 CIDR",
+			entry:   &IPSetEntry{Element: "2001:db8::/64"},
+			setType: HashNet,
+		},
+		{
+			name:          "hash:net IPv6 CIDR with host bits",
+			entry:         &IPSetEntry{Element: "2001:db8::1/64"},
+			setType:       HashNet,
+			expectedError: true,
+		},
+		{
+			name:    "hash:net host address",
+			entry:   &IPSetEntry{Element: "192.168.1.1"},
+			setType: HashNet,
+		},
+		{
+			name:    "hash:net range",
+			entry:   &IPSetEntry{Element: "192.168.1.1-192.168.1.100"},
+			setType: HashNet,
+		},
+		{
+			name:          "hash:net range of mixed families",
+			entry:         &IPSetEntry{Element: "192.168.1.1-2001:db8::1"},
+			setType:       HashNet,
+			expectedError: true,
+		},
+		{
+			name:    "hash:ip,port named port",
+			entry:   &IPSetEntry{Element: "192.168.1.1,tcp:http"},
+			setType: HashIPPort,
+		},
+		{
+			name:    "hash:ip,port range and named port",
+			entry:   &IPSetEntry{Element: "192.168.1.1-192.168.1.5,http-alt"},
+			setType: HashIPPort,
+		},
+		{
+			name:    "hash:net,port named port range",
+			entry:   &IPSetEntry{Element: "192.168.1.0/24,udp:domain-1024"},
+			setType: HashNetPort,
+		},
+	}
+
+	for _, c := range cases {
+		err := ValidateEntryForSet(c.entry, c.setType)
+		if c.expectedError && err == nil {
+			t.Errorf("[%s] expected failure, got: nil", c.name)
+		}
+
+		if !c.expectedError && err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+	}
+}
+
+func TestIPSetEntryValidate(t *testing.T) {
+	cases := []struct {
+		name          string
+		entry         IPSetEntry
+		setType       Type
+		expectedField string
+	}{
+		{
+			name:    "hash:ip IPv4 address",
+			entry:   IPSetEntry{Element: "172.18.3.2"},
+			setType: HashIP,
+		},
+		{
+			name:          "hash:ip invalid address",
+			entry:         IPSetEntry{Element: "172.18.3.256"},
+			setType:       HashIP,
+			expectedField: "ip",
+		},
+		{
+			name:    "hash:net IPv6 CIDR",
+			entry:   IPSetEntry{Element: "2001:db8::/64"},
+			setType: HashNet,
+		},
+		{
+			name:          "hash:net CIDR with host bits",
+			entry:         IPSetEntry{Element: "172.18.3.2/24"},
+			setType:       HashNet,
+			expectedField: "net",
+		},
+		{
+			name:    "hash:ip,port with protocol",
+			entry:   IPSetEntry{Element: "172.18.3.2,udp:53"},
+			setType: HashIPPort,
+		},
+		{
+			name:    "hash:ip,port without protocol",
+			entry:   IPSetEntry{Element: "172.18.3.2,80"},
+			setType: HashIPPort,
+		},
+		{
+			name:    "hash:ip,port with port range",
+			entry:   IPSetEntry{Element: "172.18.3.2,tcp:8000-8080"},
+			setType: HashIPPort,
+		},
+		{
+			name:    "hash:ip,port with icmp type",
+			entry:   IPSetEntry{Element: "172.18.3.2,icmp:ping"},
+			setType: HashIPPort,
+		},
+		{
+			name:          "hash:ip,port without port",
+			entry:         IPSetEntry{Element: "172.18.3.2"},
+			setType:       HashIPPort,
+			expectedField: "element",
+		},
+		{
+			name:    "hash:ip,port with protocol name and port 0",
+			entry:   IPSetEntry{Element: "192.168.1.1,gre:0"},
+			setType: HashIPPort,
+		},
+		{
+			name:    "hash:ip,port with protocol number and port 0",
+			entry:   IPSetEntry{Element: "192.168.1.1,47:0"},
+			setType: HashIPPort,
+		},
+		{
+			name:          "hash:ip,port with invalid protocol",
+			entry:         IPSetEntry{Element: "172.18.3.2,foo:80"},
+			setType:       HashIPPort,
+			expectedField: "proto",
+		},
+		{
+			name:          "hash:ip,port with invalid port",
+			entry:         IPSetEntry{Element: "172.18.3.2,tcp:65536"},
+			setType:       HashIPPort,
+			expectedField: "port",
+		},
+		{
+			name:          "hash:ip,port with reversed port range",
+			entry:         IPSetEntry{Element: "172.18.3.2,udp:90-80"},
+			setType:       HashIPPort,
+			expectedField: "port",
+		},
+		{
+			name:    "hash:mac address",
+			entry:   IPSetEntry{Element: "de:ad:be:af:00:01"},
+			setType: HashMAC,
+		},
+		{
+			name:          "hash:mac invalid address",
+			entry:         IPSetEntry{Element: "de:ad:be:af"},
+			setType:       HashMAC,
+			expectedField: "mac",
+		},
+	}
+
+	for _, c := range cases {
+		err := c.entry.Validate(c.setType)
+		if len(c.expectedField) == 0 {
+			if err != nil {
+				t.Errorf("[%s] expected success, got: %v", c.name, err)
+			}
+
+			continue
+		}
+
+		var entryErr *EntryError
+		if !errors.As(err, &entryErr) {
+			t.Errorf("[%s] expected entry error, got: %v", c.name, err)
+			continue
+		}
+
+		if entryErr.Field != c.expectedField {
+			t.Errorf("[%s] expected failed field: %s, got: %s", c.name,
+				c.expectedField, entryErr.Field)
+		}
+	}
+}
+
+func TestNetNetEntry(t *testing.T) {
+	cases := []struct {
+		name          string
+		src           string
+		dst           string
+		expected      string
+		expectedError bool
+	}{
+		{
+			name:     "IPv4 networks",
+			src:      "10.0.0.0/24",
+			dst:      "192.168.0.0/16",
+			expected: "10.0.0.0/24,192.168.0.0/16",
+		},
+		{
+			name:     "IPv6 networks",
+			src:      "2001:db8::/64",
+			dst:      "2001:db8:1::/48",
+			expected: "2001:db8::/64,2001:db8:1::/48",
+		},
+		{
+			name:          "Invalid source network",
+			src:           "10.0.0.0/33",
+			dst:           "192.168.0.0/16",
+			expectedError: true,
+		},
+		{
+			name:          "Destination network with host bits",
+			src:           "10.0.0.0/24",
+			dst:           "192.168.1.1/16",
+			expectedError: true,
+		},
+		{
+			name:          "Missing destination network",
+			src:           "10.0.0.0/24",
+			dst:           "",
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		entry, err := NetNetEntry(c.src, c.dst)
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+			continue
+		}
+
+		if entry.Element != c.expected {
+			t.Errorf("[%s] expected element: %s, got: %s", c.name, c.expected,
+				entry.Element)
+		}
+
+		if err := entry.Validate(HashNetNet); err != nil {
+			t.Errorf("[%s] expected valid entry, got: %v", c.name, err)
+		}
+	}
+}
+
+func TestIPIfaceElement(t *testing.T) {
+	cases := []struct {
+		name          string
+		ip            string
+		iface         string
+		expected      string
+		expectedError bool
+	}{
+		{
+			name:     "Loopback interface",
+			ip:       "127.0.0.1",
+			iface:    "lo",
+			expected: "127.0.0.1,lo",
+		},
+		{
+			name:     "Physical interface",
+			ip:       "10.0.0.1",
+			iface:    "eth0",
+			expected: "10.0.0.1,eth0",
+		},
+		{
+			name:     "VLAN sub-interface",
+			ip:       "2001:db8::1",
+			iface:    "eth0.100",
+			expected: "2001:db8::1,eth0.100",
+		},
+		{
+			name:          "Empty interface name",
+			ip:            "10.0.0.1",
+			iface:         "",
+			expectedError: true,
+		},
+		{
+			name:          "16 characters interface name",
+			ip:            "10.0.0.1",
+			iface:         "abcdefghijklmnop",
+			expectedError: true,
+		},
+		{
+			name:          "Invalid IP address",
+			ip:            "10.0.0",
+			iface:         "eth0",
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		element := FormatIPIfaceElement(c.ip, c.iface)
+
+		ip, iface, err := ParseIPIfaceElement(element)
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+			continue
+		}
+
+		if element != c.expected {
+			t.Errorf("[%s] expected element: %s, got: %s", c.name, c.expected,
+				element)
+		}
+
+		if ip != c.ip || iface != c.iface {
+			t.Errorf("[%s] expected %s %s, got: %s %s", c.name, c.ip, c.iface,
+				ip, iface)
+		}
+	}
+}
+
+func TestParseNetIfaceElement(t *testing.T) {
+	cases := []struct {
+		name          string
+		element       string
+		expectedCIDR  string
+		expectedIface string
+		expectedError bool
+	}{
+		{
+			name:          "IPv4 network",
+			element:       "10.0.0.0/24,eth0",
+			expectedCIDR:  "10.0.0.0/24",
+			expectedIface: "eth0",
+		},
+		{
+			name:          "IPv6 host network",
+			element:       "2001:db8::1,lo",
+			expectedCIDR:  "2001:db8::1",
+			expectedIface: "lo",
+		},
+		{
+			name:          "Network with host bits",
+			element:       "10.0.0.1/24,eth0",
+			expectedError: true,
+		},
+		{
+			name:          "Invalid prefix length",
+			element:       "10.0.0.0/33,eth0",
+			expectedError: true,
+		},
+		{
+			name:          "Missing interface",
+			element:       "10.0.0.0/24",
+			expectedError: true,
+		},
+		{
+			name:          "16 characters interface name",
+			element:       "10.0.0.0/24,abcdefghijklmnop",
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		cidr, iface, err := ParseNetIfaceElement(c.element)
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+			continue
+		}
+
+		if cidr != c.expectedCIDR || iface != c.expectedIface {
+			t.Errorf("[%s] expected %s %s, got: %s %s", c.name,
+				c.expectedCIDR, c.expectedIface, cidr, iface)
+		}
+
+		if FormatNetIfaceElement(cidr, iface) != c.element {
+			t.Errorf("[%s] expected element: %s, got: %s", c.name, c.element,
+				FormatNetIfaceElement(cidr, iface))
+		}
+	}
+}
+
+func TestPortRange(t *testing.T) {
+	cases := []struct {
+		name          string
+		element       string
+		expected      PortRange
+		expectedError bool
+	}{
+		{
+			name:     "Single port",
+			element:  "80",
+			expected: PortRange{Start: 80, End: 80},
+		},
+		{
+			name:     "Port range",
+			element:  "80-443",
+			expected: PortRange{Start: 80, End: 443},
+		},
+		{
+			name:     "Boundary range",
+			element:  "0-65535",
+			expected: PortRange{Start: 0, End: 65535},
+		},
+		{
+			name:          "Port out of range",
+			element:       "65536",
+			expectedError: true,
+		},
+		{
+			name:          "Start greater than end",
+			element:       "443-80",
+			expectedError: true,
+		},
+		{
+			name:          "Not a port",
+			element:       "http",
+			expectedError: true,
+		},
+		{
+			name:          "Missing end",
+			element:       "80-",
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		pr, err := ParsePortRange(c.element)
+		validateErr := ValidateEntryForSet(&IPSetEntry{Element: c.element},
+			BitmapPort)
+
+		if c.expectedError {
+			if err == nil || validateErr == nil {
+				t.Errorf("[%s] expected failure, got: %v, %v", c.name, err,
+					validateErr)
+			}
+
+			continue
+		}
+
+		if err != nil || validateErr != nil {
+			t.Errorf("[%s] expected success, got: %v, %v", c.name, err,
+				validateErr)
+		}
+
+		if pr != c.expected || pr.String() != c.element {
+			t.Errorf("[%s] expected port range %s, got: %s", c.name,
+				c.element, pr)
+		}
+	}
+
+	for _, pr := range []PortRange{{-1, 80}, {80, 65536}, {443, 80}} {
+		if ValidatePortRange(pr) == nil {
+			t.Errorf("[%+v] expected failure, got: nil", pr)
+		}
+	}
+}
+
+func TestBitmapPortSpec(t *testing.T) {
+	set := IPSetSpec(IPSetName("foo"), IPSetType(BitmapPort),
+		IPSetRange(PortRange{Start: 1024, End: 65535}.String()))
+
+	if err := set.Validate(); err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	expected := []string{"create", "foo", "bitmap:port", "range",
+		"1024-65535"}
+	if args := buildCreateArgs(set); !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected create args: %v, got: %v", expected, args)
+	}
+
+	for _, invalid := range []*IPSet{
+		IPSetSpec(IPSetName("foo"), IPSetType(BitmapPort)),
+		IPSetSpec(IPSetName("foo"), IPSetType(BitmapPort),
+			IPSetRange("1024-")),
+		IPSetSpec(IPSetName("foo"), IPSetRange("1024-65535")),
+	} {
+		if err := invalid.Validate(); err == nil {
+			t.Errorf("[%+v] expected failure, got: nil", invalid)
+		}
+	}
+}
+
+func TestIPPortIPEntry(t *testing.T) {
+	cases := []struct {
+		name          string
+		srcIP         string
+		protoPort     string
+		dstIP         string
+		expected      string
+		expectedError bool
+	}{
+		{
+			name:      "IPv4 tcp",
+			srcIP:     "1.1.1.1",
+			protoPort: "tcp:80",
+			dstIP:     "2.2.2.2",
+			expected:  "1.1.1.1,tcp:80,2.2.2.2",
+		},
+		{
+			name:      "IPv6 without protocol",
+			srcIP:     "2001:db8::1",
+			protoPort: "443",
+			dstIP:     "2001:db8::2",
+			expected:  "2001:db8::1,443,2001:db8::2",
+		},
+		{
+			name:          "Mixed families",
+			srcIP:         "1.1.1.1",
+			protoPort:     "udp:53",
+			dstIP:         "2001:db8::2",
+			expectedError: true,
+		},
+		{
+			name:          "Invalid port",
+			srcIP:         "1.1.1.1",
+			protoPort:     "tcp:65536",
+			dstIP:         "2.2.2.2",
+			expectedError: true,
+		},
+		{
+			name:          "Reversed port range",
+			srcIP:         "1.1.1.1",
+			protoPort:     "udp:90-80",
+			dstIP:         "2.2.2.2",
+			expectedError: true,
+		},
+		{
+			name:          "Invalid destination",
+			srcIP:         "1.1.1.1",
+			protoPort:     "tcp:80",
+			dstIP:         "2.2.2.0/24",
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		entry, err := IPPortIPEntry(c.srcIP, c.protoPort, c.dstIP)
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+			continue
+		}
+
+		if entry.Element != c.expected {
+			t.Errorf("[%s] expected element %s, got: %s", c.name, c.expected,
+				entry.Element)
+		}
+
+		if err := ValidateEntryForSet(entry, HashIPPortIP); err != nil {
+			t.Errorf("[%s] expected valid entry, got: %v", c.name, err)
+		}
+	}
+
+	if ValidateEntryForSet(&IPSetEntry{Element: "1.1.1.1,tcp:80"},
+		HashIPPortIP) == nil {
+		t.Errorf("expected failure of missing destination, got: nil")
+	}
+}
+
+func TestPolicyEntry(t *testing.T) {
+	cases := []struct {
+		name          string
+		cidr          string
+		proto         string
+		port          int32
+		expected      string
+		expectedError bool
+	}{
+		{
+			name:     "IPv4 TCP port",
+			cidr:     "10.0.0.0/24",
+			proto:    "TCP",
+			port:     80,
+			expected: "10.0.0.0/24,tcp:80",
+		},
+		{
+			name:     "IPv6 UDP port",
+			cidr:     "2001:db8::/64",
+			proto:    "udp",
+			port:     53,
+			expected: "2001:db8::/64,udp:53",
+		},
+		{
+			name:     "Default protocol",
+			cidr:     "10.0.0.0/24",
+			port:     443,
+			expected: "10.0.0.0/24,tcp:443",
+		},
+		{
+			name:          "All ports",
+			cidr:          "10.0.0.0/24",
+			proto:         "SCTP",
+			expectedError: true,
+		},
+		{
+			name:     "Host address",
+			cidr:     "10.0.0.1",
+			proto:    "tcp",
+			port:     22,
+			expected: "10.0.0.1,tcp:22",
+		},
+		{
+			name:          "CIDR with host bits",
+			cidr:          "10.0.0.1/24",
+			proto:         "tcp",
+			port:          80,
+			expectedError: true,
+		},
+		{
+			name:          "Invalid CIDR",
+			cidr:          "10.0.0.0/33",
+			proto:         "tcp",
+			port:          80,
+			expectedError: true,
+		},
+		{
+			name:          "Unsupported protocol",
+			cidr:          "10.0.0.0/24",
+			proto:         "icmp",
+			port:          8,
+			expectedError: true,
+		},
+		{
+			name:          "Negative port",
+			cidr:          "10.0.0.0/24",
+			proto:         "tcp",
+			port:          -1,
+			expectedError: true,
+		},
+		{
+			name:          "Port out of range",
+			cidr:          "10.0.0.0/24",
+			proto:         "tcp",
+			port:          65536,
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		entry, err := PolicyEntry(c.cidr, c.proto, c.port)
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+			continue
+		}
+
+		if entry.Element != c.expected {
+			t.Errorf("[%s] expected element %s, got: %s", c.name, c.expected,
+				entry.Element)
+		}
+
+		if err := ValidateEntryForSet(entry, HashNetPort); err != nil {
+			t.Errorf("[%s] expected valid entry, got: %v", c.name, err)
+		}
+	}
+
+	if ValidateEntryForSet(&IPSetEntry{Element: "10.0.0.0/24"},
+		HashNetPort) == nil {
+		t.Errorf("expected failure of missing port, got: nil")
+	}
+}
+
+func TestRangeSupport(t *testing.T) {
+	for _, setType := range ValidIPSetTypes {
+		r := "10.0.0.0-10.0.0.255"
+		if setType == BitmapPort {
+			r = "1024-65535"
+		}
+
+		set := IPSetSpec(IPSetName("foo"), IPSetType(setType), IPSetRange(r))
+
+		err := set.Validate()
+		if setType.isBitmap() && err != nil {
+			t.Errorf("[%s] expected success, got: %v", setType, err)
+		}
+
+		if !setType.isBitmap() &&
+			(err == nil || !strings.Contains(err.Error(), "Range")) {
+			t.Errorf("[%s] expected invalid Range failure, got: %v", setType,
+				err)
+		}
+	}
+}
+
+func TestCanonicalizeElement(t *testing.T) {
+	cases := []struct {
+		name          string
+		element       string
+		setType       Type
+		expected      string
+		expectedError bool
+	}{
+		{
+			name:     "IPv4 address",
+			element:  "172.18.3.2",
+			setType:  HashIP,
+			expected: "172.18.3.2",
+		},
+		{
+			name:     "IPv6 loopback",
+			element:  "0:0:0:0:0:0:0:1",
+			setType:  HashIP,
+			expected: "::1",
+		},
+		{
+			name:     "IPv6 compressed",
+			element:  "::1",
+			setType:  HashIP,
+			expected: "::1",
+		},
+		{
+			name:     "IPv6 leading zeros and case",
+			element:  "2001:0DB8:0000:0000:0000:0000:0000:0001",
+			setType:  HashIP,
+			expected: "2001:db8::1",
+		},
+		{
+			name:     "IPv4-mapped IPv6",
+			element:  "::FFFF:10.0.0.1",
+			setType:  HashIP,
+			expected: "::ffff:10.0.0.1",
+		},
+		{
+			name:          "Invalid IP address",
+			element:       "172.18.3.256",
+			setType:       HashIP,
+			expectedError: true,
+		},
+		{
+			name:     "Network host bits",
+			element:  "10.0.0.5/24",
+			setType:  HashNet,
+			expected: "10.0.0.0/24",
+		},
+		{
+			name:     "IPv6 network host bits",
+			element:  "2001:db8::1/64",
+			setType:  HashNet,
+			expected: "2001:db8::/64",
+		},
+		{
+			name:     "Host network",
+			element:  "10.0.0.1/32",
+			setType:  HashNet,
+			expected: "10.0.0.1",
+		},
+		{
+			name:     "IPv6 host network",
+			element:  "2001:db8:0:0::1/128",
+			setType:  HashNet,
+			expected: "2001:db8::1",
+		},
+		{
+			name:          "Invalid prefix length",
+			element:       "10.0.0.0/33",
+			setType:       HashNet,
+			expectedError: true,
+		},
+		{
+			name:     "Net and port",
+			element:  "10.0.0.5/24,UDP:053",
+			setType:  HashNetPort,
+			expected: "10.0.0.0/24,udp:53",
+		},
+		{
+			name:     "IP and default protocol",
+			element:  "172.18.3.2,80",
+			setType:  HashIPPort,
+			expected: "172.18.3.2,tcp:80",
+		},
+		{
+			name:     "IP and single port range",
+			element:  "172.18.3.2,tcp:80-80",
+			setType:  HashIPPort,
+			expected: "172.18.3.2,tcp:80",
+		},
+		{
+			name:     "IP and port range",
+			element:  "172.18.3.2,tcp:0080-0443",
+			setType:  HashIPPort,
+			expected: "172.18.3.2,tcp:80-443",
+		},
+		{
+			name:     "IP and icmp type",
+			element:  "172.18.3.2,ICMP:echo-request",
+			setType:  HashIPPort,
+			expected: "172.18.3.2,icmp:echo-request",
+		},
+		{
+			name:     "IP, port and IPv6",
+			element:  "0:0:0:0:0:0:0:1,53,0:0:0:0:0:0:0:2",
+			setType:  HashIPPortIP,
+			expected: "::1,tcp:53,::2",
+		},
+		{
+			name:     "MAC address",
+			element:  "de:ad:be:ef:ca:fe",
+			setType:  HashMAC,
+			expected: "DE:AD:BE:EF:CA:FE",
+		},
+		{
+			name:     "MAC address with dashes",
+			element:  "de-ad-be-ef-ca-fe",
+			setType:  HashMAC,
+			expected: "DE:AD:BE:EF:CA:FE",
+		},
+		{
+			name:     "Net and net",
+			element:  "10.0.0.5/24,192.168.1.1/16",
+			setType:  HashNetNet,
+			expected: "10.0.0.0/24,192.168.0.0/16",
+		},
+		{
+			name:     "Net and iface",
+			element:  "10.0.0.5/24,eth0",
+			setType:  HashNetIface,
+			expected: "10.0.0.0/24,eth0",
+		},
+		{
+			name:     "Single port range",
+			element:  "1024-1024",
+			setType:  BitmapPort,
+			expected: "1024",
+		},
+		{
+			name:     "Port range leading zeros",
+			element:  "080-0443",
+			setType:  BitmapPort,
+			expected: "80-443",
+		},
+		{
+			name:          "Invalid port range",
+			element:       "443-80",
+			setType:       BitmapPort,
+			expectedError: true,
+		},
+		{
+			name:     "Set name",
+			element:  "foo",
+			setType:  ListSet,
+			expected: "foo",
+		},
+		{
+			name:          "Unsupported type",
+			element:       "172.18.3.2",
+			setType:       Type("hash:unknown"),
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		element, err := CanonicalizeElement(c.element, c.setType)
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if element != c.expected {
+			t.Errorf("[%s] expected element %s, got: %s", c.name, c.expected,
+				element)
+		}
+
+		again, err := CanonicalizeElement(element, c.setType)
+		if err != nil || again != element {
+			t.Errorf("[%s] expected canonical element stable %s, got: %s, %v",
+				c.name, element, again, err)
+		}
+	}
+
+	_, err := CanonicalizeElement("172.18.3.2", Type("hash:unknown"))
+	if !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("expected ErrUnsupportedType, got: %v", err)
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrSpecMismatch is returned by EnsureSet when the set exists with the
+// different specification.
+type ErrSpecMismatch struct {
+	Desired *IPSet
+	Actual  *IPSetHeader
+}
+
+func (e *ErrSpecMismatch) Error() string {
+	return fmt.Sprintf("set %s exists with different specification, "+
+		"desired: %+v, actual: %+v", e.Desired.Name, *e.Desired, *e.Actual)
+}
+
+// EnsureSet creates the set if it does not exist. If it exists with the
+// same specification it does nothing, otherwise *ErrSpecMismatch is returned
+// and the caller decides whether to swap or recreate it. Unlike CreateSet
+// with ignoreExistErr, the existing set is compared to the specification.
+func (runner *runner) EnsureSet(set *IPSet) error {
+	set = runner.withDefaultFamily(set)
+
+	err := set.Validate()
+	if err != nil {
+		return fmt.Errorf("error ensuring set: %v, error: %w", set, err)
+	}
+
+	err = runner.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer runner.locker.Unlock()
+
+	header, err := runner.getSetHeader(set.Name)
+	if errors.Is(err, ErrSetNotFound) {
+		return runner.createSet(set, false)
+	}
+
+	if err != nil {
+		return fmt.Errorf("error ensuring set %s, error: %w", set.Name, err)
+	}
+
+	if !specMatches(set, header) {
+		return &ErrSpecMismatch{Desired: set, Actual: header}
+	}
+
+	return nil
+}
+
+// IsSetExists checks whether the specified set name exists.
+func (runner *runner) IsSetExists(setname SetName) (bool, error) {
+	if len(setname) == 0 {
+		return false, ErrEmptySetName
+	}
+
+	err := runner.locker.Lock()
+	if err != nil {
+		return false, err
+	}
+	defer runner.locker.Unlock()
+
+	return runner.setExists(string(setname))
+}
+
+// setExists implements the set existence check, the caller holds the lock.
+func (runner *runner) setExists(setname string) (bool, error) {
+	_, err := runner.getSetHeader(setname)
+	if errors.Is(err, ErrSetNotFound) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("error checking set %s exists, error: %w",
+			setname, err)
+	}
+
+	return true, nil
+}
+
+// IsEmptySet checks whether the specified set name has no entries, only the
+// set header is parsed, the members are not read. ErrSetNotFound is returned
+// when the set does not exist.
+func (runner *runner) IsEmptySet(setname SetName) (bool, error) {
+	header, err := runner.GetSetHeader(setname)
+	if err != nil {
+		return false, fmt.Errorf("error checking set %s empty, error: %w",
+			setname, err)
+	}
+
+	return header.NumEntries == 0, nil
+}
+
+// CreateSetIfNotExists creates the set only if it does not exist, the
+// specification is validated before any ipset command. Unlike EnsureSet, the
+// existing set is not compared to the specification.
+func (runner *runner) CreateSetIfNotExists(set *IPSet) error {
+	set = runner.withDefaultFamily(set)
+
+	err := set.Validate()
+	if err != nil {
+		return fmt.Errorf("error creating set: %v, invalid specification, "+
+			"error: %w", set, err)
+	}
+
+	err = runner.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer runner.locker.Unlock()
+
+	exists, err := runner.setExists(set.Name)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	return runner.createSet(set, false)
+}
+
+// specMatches checks if the set header matches the set specification. The
+// kernel rounds the hash size up to the power of two, at least 64, and grows
+// it when the set is full, so the larger hash size matches.
+func specMatches(set *IPSet, header *IPSetHeader) bool {
+	family := set.HashFamily
+	if len(family) == 0 && set.SetType.familyOptional() {
+		family = ProtocolFamilyIPv4
+	}
+
+	if set.SetType != header.SetType || set.Timeout != header.Timeout ||
+		set.WithCounters != header.WithCounters ||
+		set.WithComment != header.WithComment ||
+		set.WithSkbinfo != header.WithSkbinfo {
+		return false
+	}
+
+	if !set.SetType.isHash() {
+		return set.Range == header.Range
+	}
+
+	if set.SetType.hasFamily() && family != header.HashFamily {
+		return false
+	}
+
+	if set.MaxElement != header.MaxElement {
+		return false
+	}
+
+	if set.BucketSize > 0 && set.BucketSize != header.BucketSize {
+		return false
+	}
+
+	if set.Netmask != header.Netmask {
+		return false
+	}
+
+	hashSize := 64
+	for hashSize < set.HashSize {
+		hashSize <<= 1
+	}
+
+	return header.HashSize >= hashSize
+}
+
+// SetMembers reconciles the members of the list:set to exactly the ordered
+// members, the order is the match priority. The members which are not in the
+// order are deleted and the misplaced ones are deleted and added back before
+// the member at their position, the members in place are kept as is.
+func (runner *runner) SetMembers(listSetName SetName,
+	orderedMembers []string) error {
+	if len(listSetName) == 0 {
+		return ErrEmptySetName
+	}
+
+	seen := map[string]bool{}
+	for _, member := range orderedMembers {
+		if err := validateSetNameElement(member); err != nil {
+			return fmt.Errorf("error setting members of %s, error: %w",
+				listSetName, err)
+		}
+
+		if seen[member] {
+			return fmt.Errorf("error setting members of %s, error: "+
+				"duplicated member %s", listSetName, member)
+		}
+		seen[member] = true
+	}
+
+	err := runner.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer runner.locker.Unlock()
+
+	setname := string(listSetName)
+
+	set, err := runner.listSet(setname)
+	if err != nil {
+		return fmt.Errorf("error setting members of %s, error: %v", setname,
+			err)
+	}
+
+	if set.SetType != ListSet {
+		return fmt.Errorf("error setting members of %s, error: %s is not "+
+			"a %s set", setname, set.SetType, ListSet)
+	}
+
+	current := []string{}
+	for _, entry := range set.Entries {
+		if seen[entry.Element] {
+			current = append(current, entry.Element)
+			continue
+		}
+
+		err = runner.delEntry(entry.Element, setname)
+		if err != nil {
+			return fmt.Errorf("error setting members of %s, error: %v",
+				setname, err)
+		}
+	}
+
+	for idx, member := range orderedMembers {
+		if idx < len(current) && current[idx] == member {
+			continue
+		}
+
+		current, err = runner.moveMember(setname, current, member, idx)
+		if err != nil {
+			return fmt.Errorf("error setting members of %s, error: %v",
+				setname, err)
+		}
+	}
+
+	return nil
+}
+
+// moveMember places the member at the position of the list:set current
+// members, and returns the updated current members. The caller holds the
+// lock.
+func (runner *runner) moveMember(setname string, current []string,
+	member string, idx int) ([]string, error) {
+	for pos := idx; pos < len(current); pos++ {
+		if current[pos] != member {
+			continue
+		}
+
+		err := runner.delEntry(member, setname)
+		if err != nil {
+			return nil, err
+		}
+
+		current = append(current[:pos], current[pos+1:]...)
+		break
+	}
+
+	entry := &IPSetEntry{Element: member}
+	if idx < len(current) {
+		entry.Before = current[idx]
+	}
+
+	err := runner.addEntry(entry, setname, false)
+	if err != nil {
+		return nil, err
+	}
+
+	current = append(current[:idx], append([]string{member},
+		current[idx:]...)...)
+
+	return current, nil
+}
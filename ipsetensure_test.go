@@ -0,0 +1,437 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"k8s.io/utils/exec"
+	fakeexec "k8s.io/utils/exec/testing"
+)
+
+const testEnsureSetHeader = `
+<ipsets>
+	<ipset name="foo">
+		<type>hash:ip</type>
+		<revision>4</revision>
+		<header>
+			<family>inet</family>
+			<hashsize>2048</hashsize>
+			<maxelem>65536</maxelem>
+			<comment/>
+			<memsize>472</memsize>
+			<references>0</references>
+			<numentries>0</numentries>
+		</header>
+		<members>
+		</members>
+	</ipset>
+</ipsets>
+`
+
+func TestEnsureSet(t *testing.T) {
+	cases := []struct {
+		name              string
+		set               *IPSet
+		listOutput        string
+		listFailed        bool
+		combinedOutputLog [][]string
+		mismatch          bool
+	}{
+		{
+			name:       "Set does not exist",
+			set:        IPSetSpec(IPSetName("foo")),
+			listOutput: "ipset v7.6: The set with the given name does not exist",
+			listFailed: true,
+			combinedOutputLog: [][]string{
+				{"ipset", "list", "foo", "-t", "-o", "xml"},
+				{"ipset", "create", "foo", "hash:ip", "family", "inet",
+					"hashsize", testDefaultHashSize,
+					"maxelem", testDefaultMaxElement, "-o", "xml"},
+			},
+		},
+		{
+			name: "Set exists with the same specification",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetHashSize(1000),
+				IPSetWithComment(),
+			),
+			listOutput: testEnsureSetHeader,
+			combinedOutputLog: [][]string{
+				{"ipset", "list", "foo", "-t", "-o", "xml"},
+			},
+		},
+		{
+			name: "Set exists with different family",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetHashFamily(ProtocolFamilyIPv6),
+				IPSetWithComment(),
+			),
+			listOutput: testEnsureSetHeader,
+			mismatch:   true,
+		},
+		{
+			name: "Set exists without comment",
+			set: IPSetSpec(
+				IPSetName("foo"),
+			),
+			listOutput: testEnsureSetHeader,
+			mismatch:   true,
+		},
+		{
+			name: "Set exists with smaller hash size",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetHashSize(4096),
+				IPSetWithComment(),
+			),
+			listOutput: testEnsureSetHeader,
+			mismatch:   true,
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				// List
+				func() ([]byte, []byte, error) {
+					if c.listFailed {
+						return []byte(c.listOutput), nil,
+							&fakeexec.FakeExitError{Status: 1}
+					}
+
+					return []byte(c.listOutput), nil, nil
+				},
+				// Create
+				func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			},
+		}
+
+		fexec := fakeexec.FakeExec{}
+		for range fcmd.CombinedOutputScript {
+			fexec.CommandScript = append(fexec.CommandScript,
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				})
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		err := runner.EnsureSet(c.set)
+		if c.mismatch {
+			var mismatchErr *ErrSpecMismatch
+			if !errors.As(err, &mismatchErr) {
+				t.Errorf("[%s] expected spec mismatch, got: %v", c.name, err)
+				continue
+			}
+
+			if mismatchErr.Desired != c.set || mismatchErr.Actual.Name != "foo" {
+				t.Errorf("[%s] wrong spec mismatch, got: %v", c.name, err)
+			}
+
+			if fcmd.CombinedOutputCalls != 1 {
+				t.Errorf("[%s] expected 1 CombinedOutput() calls, got: %d",
+					c.name, fcmd.CombinedOutputCalls)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog, c.combinedOutputLog) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog)
+		}
+	}
+}
+
+func TestSetMembers(t *testing.T) {
+	listOutput := func(setType string, members ...string) string {
+		out := `<ipsets><ipset name="foo"><type>` + setType + `</type>` +
+			`<header><size>8</size></header><members>`
+		for _, member := range members {
+			out += `<member><elem>` + member + `</elem></member>`
+		}
+
+		return out + `</members></ipset></ipsets>`
+	}
+
+	cases := []struct {
+		name              string
+		members           []string
+		listOutput        string
+		combinedOutputLog [][]string
+		expectedError     bool
+	}{
+		{
+			name:       "Reorder, delete and add members",
+			members:    []string{"c", "a", "d"},
+			listOutput: listOutput("list:set", "a", "b", "c", "x"),
+			combinedOutputLog: [][]string{
+				{"ipset", "list", "foo", "-o", "xml"},
+				{"ipset", "del", "foo", "b", "-o", "xml"},
+				{"ipset", "del", "foo", "x", "-o", "xml"},
+				{"ipset", "del", "foo", "c", "-o", "xml"},
+				{"ipset", "add", "foo", "c", "before", "a", "-o", "xml"},
+				{"ipset", "add", "foo", "d", "-o", "xml"},
+			},
+		},
+		{
+			name:       "Members in order",
+			members:    []string{"a", "b"},
+			listOutput: listOutput("list:set", "a", "b"),
+			combinedOutputLog: [][]string{
+				{"ipset", "list", "foo", "-o", "xml"},
+			},
+		},
+		{
+			name:       "Empty list:set",
+			members:    []string{"a", "b"},
+			listOutput: listOutput("list:set"),
+			combinedOutputLog: [][]string{
+				{"ipset", "list", "foo", "-o", "xml"},
+				{"ipset", "add", "foo", "a", "-o", "xml"},
+				{"ipset", "add", "foo", "b", "-o", "xml"},
+			},
+		},
+		{
+			name:       "Remove all members",
+			members:    []string{},
+			listOutput: listOutput("list:set", "a"),
+			combinedOutputLog: [][]string{
+				{"ipset", "list", "foo", "-o", "xml"},
+				{"ipset", "del", "foo", "a", "-o", "xml"},
+			},
+		},
+		{
+			name:          "Not a list:set",
+			members:       []string{"a"},
+			listOutput:    listOutput("hash:ip"),
+			expectedError: true,
+		},
+		{
+			name:          "Duplicated members",
+			members:       []string{"a", "a"},
+			expectedError: true,
+		},
+		{
+			name:          "Invalid member",
+			members:       []string{"a b"},
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				// List
+				func() ([]byte, []byte, error) {
+					return []byte(c.listOutput), nil, nil
+				},
+			},
+		}
+
+		for i := 0; i < 8; i++ {
+			fcmd.CombinedOutputScript = append(fcmd.CombinedOutputScript,
+				func() ([]byte, []byte, error) { return []byte{}, nil, nil })
+		}
+
+		fexec := fakeexec.FakeExec{}
+		for range fcmd.CombinedOutputScript {
+			fexec.CommandScript = append(fexec.CommandScript,
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				})
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		err := runner.SetMembers("foo", c.members)
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog, c.combinedOutputLog) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog)
+		}
+	}
+}
+
+func TestCreateSetIfNotExists(t *testing.T) {
+	cases := []struct {
+		name              string
+		set               *IPSet
+		listOutput        string
+		listFailed        bool
+		combinedOutputLog [][]string
+		expectedError     bool
+	}{
+		{
+			name:       "Set does not exist",
+			set:        IPSetSpec(IPSetName("foo")),
+			listOutput: "ipset v7.6: The set with the given name does not exist",
+			listFailed: true,
+			combinedOutputLog: [][]string{
+				{"ipset", "list", "foo", "-t", "-o", "xml"},
+				{"ipset", "create", "foo", "hash:ip", "family", "inet",
+					"hashsize", testDefaultHashSize,
+					"maxelem", testDefaultMaxElement, "-o", "xml"},
+			},
+		},
+		{
+			name:       "Set exists",
+			set:        IPSetSpec(IPSetName("foo")),
+			listOutput: testEnsureSetHeader,
+			combinedOutputLog: [][]string{
+				{"ipset", "list", "foo", "-t", "-o", "xml"},
+			},
+		},
+		{
+			name:       "Existence check failure",
+			set:        IPSetSpec(IPSetName("foo")),
+			listOutput: "ipset v7.6: Kernel error received: Operation not permitted",
+			listFailed: true,
+			combinedOutputLog: [][]string{
+				{"ipset", "list", "foo", "-t", "-o", "xml"},
+			},
+			expectedError: true,
+		},
+		{
+			name:          "Invalid specification",
+			set:           IPSetSpec(IPSetName("foo"), IPSetHashSize(0)),
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				// List
+				func() ([]byte, []byte, error) {
+					if c.listFailed {
+						return []byte(c.listOutput), nil,
+							&fakeexec.FakeExitError{Status: 1}
+					}
+
+					return []byte(c.listOutput), nil, nil
+				},
+				// Create
+				func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			},
+		}
+
+		fexec := fakeexec.FakeExec{}
+		for range fcmd.CombinedOutputScript {
+			fexec.CommandScript = append(fexec.CommandScript,
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				})
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		err := runner.CreateSetIfNotExists(c.set)
+		if c.expectedError && err == nil {
+			t.Errorf("[%s] expected failure, got: nil", c.name)
+		}
+
+		if !c.expectedError && err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog, c.combinedOutputLog) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog)
+		}
+	}
+}
+
+func TestIsEmptySet(t *testing.T) {
+	cases := []struct {
+		name          string
+		output        string
+		failed        bool
+		expected      bool
+		notFound      bool
+		expectedError bool
+	}{
+		{
+			name:     "Empty set",
+			output:   testEnsureSetHeader,
+			expected: true,
+		},
+		{
+			name:   "Non-empty set",
+			output: testCommentListOutput,
+		},
+		{
+			name:          "Set does not exist",
+			output:        "ipset v7.6: The set with the given name does not exist",
+			failed:        true,
+			notFound:      true,
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				func() ([]byte, []byte, error) {
+					if c.failed {
+						return []byte(c.output), nil,
+							&fakeexec.FakeExitError{Status: 1}
+					}
+
+					return []byte(c.output), nil, nil
+				},
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		empty, err := runner.IsEmptySet("foo")
+		if c.expectedError && err == nil {
+			t.Errorf("[%s] expected failure, got: nil", c.name)
+		}
+
+		if !c.expectedError && err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if errors.Is(err, ErrSetNotFound) != c.notFound {
+			t.Errorf("[%s] expected not found %v, got: %v", c.name,
+				c.notFound, err)
+		}
+
+		if empty != c.expected {
+			t.Errorf("[%s] expected empty %v, got: %v", c.name, c.expected,
+				empty)
+		}
+	}
+}
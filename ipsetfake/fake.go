@@ -0,0 +1,1008 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+// Package ipsetfake provides the in-memory fake of the ipset Interface for
+// the unit tests which do not exec ipset.
+package ipsetfake
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	ipset "github.com/neutronth/go-ipset"
+)
+
+// FakeRunner implements ipset.Interface with the sets kept in memory. Every
+// call is recorded as the ipset command name and its set name, e.g.
+// {"add", "foo", "172.18.3.2"}, see CommandLog.
+type FakeRunner struct {
+	// Version is returned by GetVersion.
+	Version ipset.IPSetVersion
+
+	mu         sync.Mutex
+	sets       map[string]*ipset.IPSet
+	errors     map[string]error
+	commandLog [][]string
+}
+
+var _ ipset.Interface = &FakeRunner{}
+
+// NewFakeRunner returns a new FakeRunner without any set.
+func NewFakeRunner() *FakeRunner {
+	return &FakeRunner{
+		Version: ipset.IPSetVersion{Major: 7, Minor: 6, Protocol: 7},
+		sets:    map[string]*ipset.IPSet{},
+		errors:  map[string]error{},
+	}
+}
+
+// InjectError makes the calls of the ipset command, e.g. "add", "create",
+// return err, a nil err removes the injected error.
+func (f *FakeRunner) InjectError(op string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err == nil {
+		delete(f.errors, op)
+		return
+	}
+
+	f.errors[op] = err
+}
+
+// CommandLog returns the copy of the recorded calls in order, it is safe to
+// call while the fake is in use.
+func (f *FakeRunner) CommandLog() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	log := make([][]string, 0, len(f.commandLog))
+	for _, args := range f.commandLog {
+		log = append(log, append([]string{}, args...))
+	}
+
+	return log
+}
+
+// record logs the call and returns its injected error, the caller holds mu.
+func (f *FakeRunner) record(args ...string) error {
+	f.commandLog = append(f.commandLog, args)
+	return f.errors[args[0]]
+}
+
+// lookup returns the set of the set name, the caller holds mu.
+func (f *FakeRunner) lookup(setname string) (*ipset.IPSet, error) {
+	if len(setname) == 0 {
+		return nil, ipset.ErrEmptySetName
+	}
+
+	set, ok := f.sets[setname]
+	if !ok {
+		return nil, fmt.Errorf("error with set %s, error: %w", setname,
+			ipset.ErrSetNotFound)
+	}
+
+	return set, nil
+}
+
+// entryIndex returns the index of the element in the set, or -1.
+func entryIndex(set *ipset.IPSet, element string) int {
+	for idx, entry := range set.Entries {
+		if entry.Element == element {
+			return idx
+		}
+	}
+
+	return -1
+}
+
+// copyEntries returns the copy of the set entries.
+func copyEntries(set *ipset.IPSet) []ipset.IPSetEntry {
+	return append([]ipset.IPSetEntry{}, set.Entries...)
+}
+
+// CreateSet creates a new set in memory.
+func (f *FakeRunner) CreateSet(set *ipset.IPSet, ignoreExistErr bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.createSet(set, ignoreExistErr)
+}
+
+// createSet implements the create set, the caller holds mu.
+func (f *FakeRunner) createSet(set *ipset.IPSet, ignoreExistErr bool) error {
+	if set == nil {
+		return fmt.Errorf("error creating set, error: nil set")
+	}
+
+	if err := f.record("create", set.Name); err != nil {
+		return err
+	}
+
+	if err := set.Validate(); err != nil {
+		return fmt.Errorf("error creating set: %v, error: %v", set, err)
+	}
+
+	if _, exists := f.sets[set.Name]; exists {
+		if ignoreExistErr {
+			return nil
+		}
+
+		return fmt.Errorf("error creating set %s, error: set with the same "+
+			"name already exists", set.Name)
+	}
+
+	created := *set
+	created.Entries = nil
+	f.sets[set.Name] = &created
+
+	return nil
+}
+
+// CreateSets creates the sets in memory, the sets before the failed one are
+// created as ipset restore does and the failure is the *ipset.SetsError.
+func (f *FakeRunner) CreateSets(sets []*ipset.IPSet,
+	ignoreExistErr bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for idx, set := range sets {
+		err := f.createSet(set, ignoreExistErr)
+		if err == nil {
+			continue
+		}
+
+		errs := map[string]error{}
+		for _, skipped := range sets[idx+1:] {
+			if skipped != nil {
+				errs[skipped.Name] = fmt.Errorf("set %s is not applied",
+					skipped.Name)
+			}
+		}
+
+		if set != nil {
+			errs[set.Name] = err
+		} else {
+			errs[""] = err
+		}
+
+		return &ipset.SetsError{Errors: errs}
+	}
+
+	return nil
+}
+
+// CreateSetWithSpec creates a new set in memory and returns its copy.
+func (f *FakeRunner) CreateSetWithSpec(set *ipset.IPSet,
+	ignoreExistErr bool) (*ipset.IPSet, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.createSet(set, ignoreExistErr); err != nil {
+		return nil, err
+	}
+
+	created := *f.sets[set.Name]
+	return &created, nil
+}
+
+// DestroySet destroys the set.
+func (f *FakeRunner) DestroySet(setname ipset.SetName) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.record("destroy", string(setname)); err != nil {
+		return err
+	}
+
+	if _, err := f.lookup(string(setname)); err != nil {
+		return err
+	}
+
+	delete(f.sets, string(setname))
+	return nil
+}
+
+// CreateSetWithOptions creates a new set in memory with the options enabled.
+func (f *FakeRunner) CreateSetWithOptions(set *ipset.IPSet,
+	opts ipset.CreateOptions) error {
+	if set != nil {
+		created := *set
+		created.WithCounters = created.WithCounters || opts.Counters
+		created.WithComment = created.WithComment || opts.Comment
+		if opts.Timeout != 0 {
+			created.Timeout = opts.Timeout
+		}
+		set = &created
+	}
+
+	return f.CreateSet(set, opts.IgnoreExists)
+}
+
+// DestroySets destroys the sets, the sets before the failed one are destroyed
+// as ipset restore does and the failure is the *ipset.SetsError. The missing
+// sets are not an error if ignoreNotExistErr is set.
+func (f *FakeRunner) DestroySets(setnames []ipset.SetName,
+	ignoreNotExistErr bool) error {
+	for idx, setname := range setnames {
+		err := f.DestroySet(setname)
+		if err == nil ||
+			(ignoreNotExistErr && errors.Is(err, ipset.ErrSetNotFound)) {
+			continue
+		}
+
+		errs := map[string]error{string(setname): err}
+		for _, skipped := range setnames[idx+1:] {
+			errs[string(skipped)] = fmt.Errorf("set %s is not applied",
+				skipped)
+		}
+
+		return &ipset.SetsError{Errors: errs}
+	}
+
+	return nil
+}
+
+// DestroySetWithOptions destroys the set, the missing set is not an error if
+// IgnoreNotFound is set.
+func (f *FakeRunner) DestroySetWithOptions(setname ipset.SetName,
+	opts ipset.DestroyOptions) error {
+	err := f.DestroySet(setname)
+	if opts.IgnoreNotFound && errors.Is(err, ipset.ErrSetNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+// RenameSet renames the set.
+func (f *FakeRunner) RenameSet(oldName ipset.SetName,
+	newName ipset.SetName) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.record("rename", string(oldName), string(newName)); err != nil {
+		return err
+	}
+
+	if err := newName.Validate(); err != nil {
+		return err
+	}
+
+	set, err := f.lookup(string(oldName))
+	if err != nil {
+		return err
+	}
+
+	if _, exists := f.sets[string(newName)]; exists {
+		return fmt.Errorf("error renaming set %s to %s, error: set with the "+
+			"same name already exists", oldName, newName)
+	}
+
+	delete(f.sets, string(oldName))
+	set.Name = string(newName)
+	f.sets[set.Name] = set
+
+	return nil
+}
+
+// ListSets returns the set names in the sorted order.
+func (f *FakeRunner) ListSets() ([]string, error) {
+	return f.ListSetsFunc(func(setname string) bool { return true })
+}
+
+// ListSetsNameOnly is the same as ListSets.
+func (f *FakeRunner) ListSetsNameOnly() ([]string, error) {
+	return f.ListSets()
+}
+
+// ListSetsMatching returns the set names starting with the prefix.
+func (f *FakeRunner) ListSetsMatching(prefix string) ([]string, error) {
+	return f.ListSetsFunc(func(setname string) bool {
+		return strings.HasPrefix(setname, prefix)
+	})
+}
+
+// ListSetsFunc returns the set names for which match returns true.
+func (f *FakeRunner) ListSetsFunc(match func(setname string) bool) ([]string,
+	error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.record("list", "-n"); err != nil {
+		return nil, err
+	}
+
+	list := []string{}
+	for name := range f.sets {
+		if match(name) {
+			list = append(list, name)
+		}
+	}
+	sort.Strings(list)
+
+	return list, nil
+}
+
+// ListEntries returns the entries of the set in the insertion order.
+func (f *FakeRunner) ListEntries(setname ipset.SetName) ([]ipset.IPSetEntry,
+	error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.record("list", string(setname)); err != nil {
+		return nil, err
+	}
+
+	set, err := f.lookup(string(setname))
+	if err != nil {
+		return nil, err
+	}
+
+	return copyEntries(set), nil
+}
+
+// ListEntriesResolved is the same as ListEntries, the names are not resolved.
+func (f *FakeRunner) ListEntriesResolved(
+	setname ipset.SetName) ([]ipset.IPSetEntry, error) {
+	return f.ListEntries(setname)
+}
+
+// ListEntriesSorted returns the entries of the set sorted by SortEntries.
+func (f *FakeRunner) ListEntriesSorted(
+	setname ipset.SetName) ([]ipset.IPSetEntry, error) {
+	entries, err := f.ListEntries(setname)
+	if err != nil {
+		return nil, err
+	}
+
+	ipset.SortEntries(entries)
+	return entries, nil
+}
+
+// ListEntriesByComment returns the entries of the set whose comment contains
+// the substring.
+func (f *FakeRunner) ListEntriesByComment(setname ipset.SetName,
+	substring string) ([]ipset.IPSetEntry, error) {
+	entries, err := f.ListEntries(setname)
+	if err != nil {
+		return nil, err
+	}
+
+	return ipset.FilterEntriesByComment(entries, substring), nil
+}
+
+// ListEntriesSet returns the entries of the set keyed by element.
+func (f *FakeRunner) ListEntriesSet(setname ipset.SetName) (
+	map[string]ipset.IPSetEntry, error) {
+	entries, err := f.ListEntries(setname)
+	if err != nil {
+		return nil, err
+	}
+
+	return ipset.EntriesByElement(entries), nil
+}
+
+// ListAllEntries returns the entries of all sets keyed by set name.
+func (f *FakeRunner) ListAllEntries() (map[string][]ipset.IPSetEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.record("list"); err != nil {
+		return nil, err
+	}
+
+	all := map[string][]ipset.IPSetEntry{}
+	for name, set := range f.sets {
+		all[name] = copyEntries(set)
+	}
+
+	return all, nil
+}
+
+// TotalMemory returns 0, the sets use no kernel memory.
+func (f *FakeRunner) TotalMemory() (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.record("list", "-t"); err != nil {
+		return 0, err
+	}
+
+	return 0, nil
+}
+
+// IterateEntries calls fn for each entry of the set.
+func (f *FakeRunner) IterateEntries(setname ipset.SetName,
+	fn func(entry ipset.IPSetEntry) error) error {
+	entries, err := f.ListEntries(setname)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetSetHeader returns the header of the set.
+func (f *FakeRunner) GetSetHeader(setname ipset.SetName) (*ipset.IPSetHeader,
+	error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.record("list", string(setname)); err != nil {
+		return nil, err
+	}
+
+	set, err := f.lookup(string(setname))
+	if err != nil {
+		return nil, err
+	}
+
+	return &ipset.IPSetHeader{
+		Name:         set.Name,
+		SetType:      set.SetType,
+		HashFamily:   set.HashFamily,
+		HashSize:     set.HashSize,
+		MaxElement:   set.MaxElement,
+		BucketSize:   set.BucketSize,
+		Netmask:      set.Netmask,
+		Range:        set.Range,
+		Timeout:      set.Timeout,
+		WithCounters: set.WithCounters,
+		WithComment:  set.WithComment,
+		WithSkbinfo:  set.WithSkbinfo,
+		NumEntries:   len(set.Entries),
+	}, nil
+}
+
+// EnsureSet creates the set if it does not exist, the existing set of the
+// different type or family is *ipset.ErrSpecMismatch.
+func (f *FakeRunner) EnsureSet(set *ipset.IPSet) error {
+	if err := set.Validate(); err != nil {
+		return fmt.Errorf("error ensuring set: %v, error: %v", set, err)
+	}
+
+	header, err := f.GetSetHeader(ipset.SetName(set.Name))
+	if err != nil {
+		return f.CreateSet(set, false)
+	}
+
+	if header.SetType != set.SetType || header.HashFamily != set.HashFamily {
+		return &ipset.ErrSpecMismatch{Desired: set, Actual: header}
+	}
+
+	return nil
+}
+
+// IsSetExists checks whether the set exists.
+func (f *FakeRunner) IsSetExists(setname ipset.SetName) (bool, error) {
+	_, err := f.GetSetHeader(setname)
+	if errors.Is(err, ipset.ErrSetNotFound) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// IsEmptySet checks whether the set has no entries.
+func (f *FakeRunner) IsEmptySet(setname ipset.SetName) (bool, error) {
+	header, err := f.GetSetHeader(setname)
+	if err != nil {
+		return false, err
+	}
+
+	return header.NumEntries == 0, nil
+}
+
+// CommentEnabled checks whether the set is created with the comment option.
+func (f *FakeRunner) CommentEnabled(setname ipset.SetName) (bool, error) {
+	header, err := f.GetSetHeader(setname)
+	if err != nil {
+		return false, err
+	}
+
+	return header.WithComment, nil
+}
+
+// CreateSetIfNotExists creates the set if it does not exist.
+func (f *FakeRunner) CreateSetIfNotExists(set *ipset.IPSet) error {
+	if err := set.Validate(); err != nil {
+		return err
+	}
+
+	exists, err := f.IsSetExists(ipset.SetName(set.Name))
+	if err != nil || exists {
+		return err
+	}
+
+	return f.CreateSet(set, false)
+}
+
+// SetMembers replaces the list:set members with the ordered members.
+func (f *FakeRunner) SetMembers(listSetName ipset.SetName,
+	orderedMembers []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.record("members", string(listSetName)); err != nil {
+		return err
+	}
+
+	set, err := f.lookup(string(listSetName))
+	if err != nil {
+		return err
+	}
+
+	set.Entries = nil
+	for _, member := range orderedMembers {
+		set.Entries = append(set.Entries, ipset.IPSetEntry{Element: member})
+	}
+
+	return nil
+}
+
+// SaveSetStream records the call, nothing is written.
+func (f *FakeRunner) SaveSetStream(setname ipset.SetName, w io.Writer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.record("save", string(setname))
+}
+
+// SaveAllStream records the call, nothing is written.
+func (f *FakeRunner) SaveAllStream(w io.Writer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.record("save")
+}
+
+// BackupSet records the call, no file is written.
+func (f *FakeRunner) BackupSet(setname ipset.SetName, filepath string) error {
+	return f.SaveSetStream(setname, nil)
+}
+
+// BackupAll records the call, no file is written.
+func (f *FakeRunner) BackupAll(filepath string) error {
+	return f.SaveAllStream(nil)
+}
+
+// RestoreSetFromFile records the call, no file is read.
+func (f *FakeRunner) RestoreSetFromFile(filepath string,
+	ignoreExistErr bool) error {
+	return f.RestoreFromFile(filepath, ignoreExistErr)
+}
+
+// RestoreAllFromFile records the call, no file is read.
+func (f *FakeRunner) RestoreAllFromFile(filepath string,
+	ignoreExistErr bool) error {
+	return f.RestoreFromFile(filepath, ignoreExistErr)
+}
+
+// SaveToFile records the call, no file is written.
+func (f *FakeRunner) SaveToFile(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.record("save", "-file", path)
+}
+
+// RestoreFromFile records the call, no file is read.
+func (f *FakeRunner) RestoreFromFile(path string, ignoreExistErr bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	args := []string{"restore", "-file", path}
+	if ignoreExistErr {
+		args = append(args, "-exist")
+	}
+
+	return f.record(args...)
+}
+
+// AddEntry adds the entry to the set.
+func (f *FakeRunner) AddEntry(entry *ipset.IPSetEntry, setname ipset.SetName,
+	ignoreExistErr bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.addEntry(entry, string(setname), ignoreExistErr)
+}
+
+// AddEntryWithOptions adds the entry to the set with the options set.
+func (f *FakeRunner) AddEntryWithOptions(entry *ipset.IPSetEntry,
+	setname ipset.SetName, opts ipset.AddOptions) error {
+	if entry != nil {
+		added := *entry
+		if opts.Timeout != 0 {
+			added.Timeout = opts.Timeout
+		}
+		if opts.Packets != 0 {
+			added.Packets = opts.Packets
+		}
+		if opts.Bytes != 0 {
+			added.Bytes = opts.Bytes
+		}
+		entry = &added
+	}
+
+	return f.AddEntry(entry, setname, opts.IgnoreExists)
+}
+
+// addEntry implements the add entry, the caller holds mu.
+func (f *FakeRunner) addEntry(entry *ipset.IPSetEntry, setname string,
+	ignoreExistErr bool) error {
+	if entry == nil {
+		return fmt.Errorf("error adding entry to set %s, error: nil entry",
+			setname)
+	}
+
+	if err := f.record("add", setname, entry.Element); err != nil {
+		return err
+	}
+
+	set, err := f.lookup(setname)
+	if err != nil {
+		return err
+	}
+
+	if entryIndex(set, entry.Element) >= 0 {
+		if ignoreExistErr {
+			return nil
+		}
+
+		return fmt.Errorf("error adding entry %+v, error: element is "+
+			"already added", entry)
+	}
+
+	set.Entries = append(set.Entries, *entry)
+	return nil
+}
+
+// AddEntryBatch adds the entries to the set, the existing entries are not an
+// error.
+func (f *FakeRunner) AddEntryBatch(setname ipset.SetName,
+	entries []ipset.IPSetEntry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.record("restore", string(setname)); err != nil {
+		return err
+	}
+
+	for idx := range entries {
+		err := f.addEntry(&entries[idx], string(setname), true)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// RefreshEntry replaces the entry of the set, or adds it when it is missing.
+func (f *FakeRunner) RefreshEntry(entry *ipset.IPSetEntry,
+	setname ipset.SetName) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.addEntry(entry, string(setname), true); err != nil {
+		return err
+	}
+
+	set, _ := f.lookup(string(setname))
+	set.Entries[entryIndex(set, entry.Element)] = *entry
+	return nil
+}
+
+// DelEntry deletes the entry from the set.
+func (f *FakeRunner) DelEntry(entryElement string,
+	setname ipset.SetName) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.record("del", string(setname), entryElement); err != nil {
+		return err
+	}
+
+	set, err := f.lookup(string(setname))
+	if err != nil {
+		return err
+	}
+
+	idx := entryIndex(set, entryElement)
+	if idx < 0 {
+		return fmt.Errorf("error deleting entry %s, error: element is not "+
+			"added", entryElement)
+	}
+
+	set.Entries = append(set.Entries[:idx], set.Entries[idx+1:]...)
+	return nil
+}
+
+// DelEntryStruct deletes the entry element from the set.
+func (f *FakeRunner) DelEntryStruct(entry *ipset.IPSetEntry,
+	setname ipset.SetName) error {
+	if entry == nil {
+		return fmt.Errorf("error deleting entry from set %s, error: nil entry",
+			setname)
+	}
+
+	return f.DelEntry(entry.Element, setname)
+}
+
+// DelEntries deletes the elements from the set, the missing elements are not
+// an error if ignoreNotExistErr is set. The elements before the failure stay
+// deleted as ipset restore does.
+func (f *FakeRunner) DelEntries(elements []string, setname ipset.SetName,
+	ignoreNotExistErr bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, element := range elements {
+		if err := f.record("del", string(setname), element); err != nil {
+			return err
+		}
+
+		set, err := f.lookup(string(setname))
+		if err != nil {
+			return err
+		}
+
+		idx := entryIndex(set, element)
+		if idx < 0 {
+			if ignoreNotExistErr {
+				continue
+			}
+
+			return fmt.Errorf("error deleting entry %s, error: element is "+
+				"not added", element)
+		}
+
+		set.Entries = append(set.Entries[:idx], set.Entries[idx+1:]...)
+	}
+
+	return nil
+}
+
+// DelEntriesByComment deletes the entries of the set whose comment contains
+// the substring and returns the number of deleted entries.
+func (f *FakeRunner) DelEntriesByComment(setname ipset.SetName,
+	substring string) (int, error) {
+	if len(substring) == 0 {
+		return 0, fmt.Errorf("error deleting entries of set %s by comment, "+
+			"error: empty comment substring", setname)
+	}
+
+	entries, err := f.ListEntriesByComment(setname, substring)
+	if err != nil {
+		return 0, err
+	}
+
+	for idx, entry := range entries {
+		if err := f.DelEntry(entry.Element, setname); err != nil {
+			return idx, err
+		}
+	}
+
+	return len(entries), nil
+}
+
+// TestEntry tests whether the entry is in the set.
+func (f *FakeRunner) TestEntry(entryElement string,
+	setname ipset.SetName) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.record("test", string(setname), entryElement); err != nil {
+		return false, err
+	}
+
+	set, err := f.lookup(string(setname))
+	if err != nil {
+		return false, err
+	}
+
+	return entryIndex(set, entryElement) >= 0, nil
+}
+
+// HasEntry checks whether the entry is in the set, any error is false.
+func (f *FakeRunner) HasEntry(entryElement string,
+	setname ipset.SetName) bool {
+	found, err := f.TestEntry(entryElement, setname)
+	return err == nil && found
+}
+
+// TestEntries tests whether the entries are in the set.
+func (f *FakeRunner) TestEntries(elements []string,
+	setname ipset.SetName) (map[string]bool, error) {
+	results := map[string]bool{}
+	for _, element := range elements {
+		found, err := f.TestEntry(element, setname)
+		if err != nil {
+			return nil, err
+		}
+
+		results[element] = found
+	}
+
+	return results, nil
+}
+
+// LookupEntry returns the entry of the element in the set.
+func (f *FakeRunner) LookupEntry(element string,
+	setname ipset.SetName) (*ipset.IPSetEntry, error) {
+	entries, err := f.ListEntries(setname)
+	if err != nil {
+		return nil, err
+	}
+
+	for idx := range entries {
+		if entries[idx].Element == element {
+			return &entries[idx], nil
+		}
+	}
+
+	return nil, fmt.Errorf("error looking up entry %s in set %s, error: %w",
+		element, setname, ipset.ErrEntryNotFound)
+}
+
+// SelfTest records the call.
+func (f *FakeRunner) SelfTest() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.record("selftest")
+}
+
+// TypeSupported checks the type against ipset.ValidIPSetTypes.
+func (f *FakeRunner) TypeSupported(t ipset.Type) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.record("create", "type", string(t)); err != nil {
+		return false, err
+	}
+
+	return ipset.ValidateIPSetType(t), nil
+}
+
+// FlushSet is the same as ClearEntries.
+func (f *FakeRunner) FlushSet(setname ipset.SetName) error {
+	return f.ClearEntries(setname)
+}
+
+// FlushSetIfExists removes all entries of the set, the missing set is not an
+// error.
+func (f *FakeRunner) FlushSetIfExists(setname ipset.SetName) error {
+	err := f.ClearEntries(setname)
+	if errors.Is(err, ipset.ErrSetNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+// ClearEntries removes all entries of the set.
+func (f *FakeRunner) ClearEntries(setname ipset.SetName) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.record("flush", string(setname)); err != nil {
+		return err
+	}
+
+	set, err := f.lookup(string(setname))
+	if err != nil {
+		return err
+	}
+
+	set.Entries = nil
+	return nil
+}
+
+// ResizeSet changes the hash size and the maximum elements of the set.
+func (f *FakeRunner) ResizeSet(setname ipset.SetName, newHashSize,
+	newMaxElem int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.record("swap", string(setname)); err != nil {
+		return err
+	}
+
+	set, err := f.lookup(string(setname))
+	if err != nil {
+		return err
+	}
+
+	set.HashSize = newHashSize
+	set.MaxElement = newMaxElem
+
+	return nil
+}
+
+// CreateSetAndAddEntries creates the set and adds the entries to it.
+func (f *FakeRunner) CreateSetAndAddEntries(set *ipset.IPSet,
+	entries []ipset.IPSetEntry, ignoreExistErr bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.record("restore"); err != nil {
+		return err
+	}
+
+	if err := f.createSet(set, ignoreExistErr); err != nil {
+		return err
+	}
+
+	for idx := range entries {
+		err := f.addEntry(&entries[idx], set.Name, ignoreExistErr)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ApplyBatch creates the sets and adds the entries keyed by set name, the
+// changes before a failure are kept as ipset restore does.
+func (f *FakeRunner) ApplyBatch(creates []*ipset.IPSet,
+	entriesBySet map[string][]ipset.IPSetEntry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.record("restore"); err != nil {
+		return err
+	}
+
+	for _, set := range creates {
+		if err := f.createSet(set, false); err != nil {
+			return err
+		}
+	}
+
+	setnames := []string{}
+	for setname := range entriesBySet {
+		setnames = append(setnames, setname)
+	}
+	sort.Strings(setnames)
+
+	for _, setname := range setnames {
+		entries := entriesBySet[setname]
+		for idx := range entries {
+			if err := f.addEntry(&entries[idx], setname, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// InvalidateCache does nothing, there is no cache.
+func (f *FakeRunner) InvalidateCache(setname ipset.SetName) {}
+
+// GetVersion returns the Version.
+func (f *FakeRunner) GetVersion() (ipset.IPSetVersion, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.record("version"); err != nil {
+		return ipset.IPSetVersion{}, err
+	}
+
+	return f.Version, nil
+}
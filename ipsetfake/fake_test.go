@@ -0,0 +1,164 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipsetfake
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	ipset "github.com/neutronth/go-ipset"
+)
+
+// newFakeWithSet returns a new FakeRunner with the set foo of an entry.
+func newFakeWithSet(t *testing.T) *FakeRunner {
+	fake := NewFakeRunner()
+
+	err := fake.CreateSetAndAddEntries(ipset.IPSetSpec(ipset.IPSetName("foo")),
+		[]ipset.IPSetEntry{{Element: "172.18.3.2"}}, false)
+	if err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+
+	return fake
+}
+
+func TestFakeStateChanges(t *testing.T) {
+	cases := []struct {
+		name        string
+		call        func(fake *FakeRunner) error
+		op          string
+		expectedLog []string
+		check       func(fake *FakeRunner) error
+	}{
+		{
+			name: "Rename",
+			call: func(fake *FakeRunner) error {
+				return fake.RenameSet("foo", "bar")
+			},
+			op:          "rename",
+			expectedLog: []string{"rename", "foo", "bar"},
+			check: func(fake *FakeRunner) error {
+				sets, _ := fake.ListSets()
+				if !reflect.DeepEqual(sets, []string{"bar"}) {
+					return errors.New("expected set bar only")
+				}
+
+				entries, _ := fake.ListEntries("bar")
+				if len(entries) != 1 {
+					return errors.New("expected the entry of set bar")
+				}
+
+				return nil
+			},
+		},
+		{
+			name: "Flush",
+			call: func(fake *FakeRunner) error {
+				return fake.FlushSet("foo")
+			},
+			op:          "flush",
+			expectedLog: []string{"flush", "foo"},
+			check: func(fake *FakeRunner) error {
+				empty, _ := fake.IsEmptySet("foo")
+				if !empty {
+					return errors.New("expected empty set foo")
+				}
+
+				return nil
+			},
+		},
+		{
+			name: "Swap",
+			call: func(fake *FakeRunner) error {
+				return fake.ResizeSet("foo", 2048, 131072)
+			},
+			op:          "swap",
+			expectedLog: []string{"swap", "foo"},
+			check: func(fake *FakeRunner) error {
+				header, _ := fake.GetSetHeader("foo")
+				if header == nil || header.HashSize != 2048 ||
+					header.MaxElement != 131072 {
+					return errors.New("expected resized set foo")
+				}
+
+				entries, _ := fake.ListEntries("foo")
+				if len(entries) != 1 {
+					return errors.New("expected the entry of set foo kept")
+				}
+
+				return nil
+			},
+		},
+	}
+
+	for _, c := range cases {
+		fake := newFakeWithSet(t)
+
+		err := c.call(fake)
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		log := fake.CommandLog()
+		if !reflect.DeepEqual(log[len(log)-1], c.expectedLog) {
+			t.Errorf("[%s] expected logged call %v, got: %v", c.name,
+				c.expectedLog, log[len(log)-1])
+		}
+
+		if err := c.check(fake); err != nil {
+			t.Errorf("[%s] %v", c.name, err)
+		}
+
+		fake = newFakeWithSet(t)
+		injected := errors.New("injected")
+		fake.InjectError(c.op, injected)
+
+		err = c.call(fake)
+		if !errors.Is(err, injected) {
+			t.Errorf("[%s] expected injected error, got: %v", c.name, err)
+		}
+
+		entries, err := fake.ListEntries("foo")
+		if err != nil || len(entries) != 1 {
+			t.Errorf("[%s] expected set foo unchanged, got: %+v, %v", c.name,
+				entries, err)
+		}
+
+		fake.InjectError(c.op, nil)
+
+		if err := c.call(fake); err != nil {
+			t.Errorf("[%s] expected success of removed error, got: %v",
+				c.name, err)
+		}
+	}
+}
+
+func TestFakeCommandLog(t *testing.T) {
+	fake := NewFakeRunner()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, _ = fake.ListSets()
+			_ = fake.CommandLog()
+		}()
+	}
+	wg.Wait()
+
+	log := fake.CommandLog()
+	if len(log) != 10 {
+		t.Errorf("expected 10 logged calls, got: %d", len(log))
+	}
+
+	log[0][0] = "changed"
+	if fake.CommandLog()[0][0] == "changed" {
+		t.Errorf("expected the copy of the log, got: %v", fake.CommandLog())
+	}
+}
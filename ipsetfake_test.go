@@ -0,0 +1,73 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	ipset "github.com/neutronth/go-ipset"
+	"github.com/neutronth/go-ipset/ipsetfake"
+)
+
+func TestFakeRunner(t *testing.T) {
+	var runner ipset.Interface = ipsetfake.NewFakeRunner()
+	fake := runner.(*ipsetfake.FakeRunner)
+
+	err := runner.CreateSet(ipset.IPSetSpec(ipset.IPSetName("foo")), false)
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	err = runner.AddEntry(&ipset.IPSetEntry{Element: "172.18.3.2"}, "foo",
+		false)
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	injected := errors.New("injected")
+	fake.InjectError("add", injected)
+
+	err = runner.AddEntry(&ipset.IPSetEntry{Element: "172.18.3.3"}, "foo",
+		false)
+	if !errors.Is(err, injected) {
+		t.Errorf("expected injected error, got: %v", err)
+	}
+
+	fake.InjectError("add", nil)
+
+	found, err := runner.TestEntry("172.18.3.3", "foo")
+	if err != nil || found {
+		t.Errorf("expected entry not added, got: %v, %v", found, err)
+	}
+
+	entries, err := runner.ListEntries("foo")
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	expected := []ipset.IPSetEntry{{Element: "172.18.3.2"}}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected entries: %+v, got: %+v", expected, entries)
+	}
+
+	err = runner.DestroySet("bar")
+	if !errors.Is(err, ipset.ErrSetNotFound) {
+		t.Errorf("expected set not found, got: %v", err)
+	}
+
+	expectedLog := [][]string{
+		{"create", "foo"},
+		{"add", "foo", "172.18.3.2"},
+		{"add", "foo", "172.18.3.3"},
+		{"test", "foo", "172.18.3.3"},
+		{"list", "foo"},
+		{"destroy", "bar"},
+	}
+	if !reflect.DeepEqual(fake.CommandLog(), expectedLog) {
+		t.Errorf("wrong CommandLog, got: %s", fake.CommandLog())
+	}
+}
@@ -6,12 +6,19 @@
 package ipset
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"time"
 
 	"golang.org/x/sys/unix"
-	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// lockTimeout is the default timeout of acquiring the ipset lock.
+	lockTimeout = 2 * time.Second
+	// lockRetryInterval is the interval of the ipset lock retries.
+	lockRetryInterval = 200 * time.Millisecond
 )
 
 type locker struct {
@@ -20,9 +27,24 @@ type locker struct {
 }
 
 func (l *locker) Lock() error {
+	ctx, cancel := context.WithTimeout(context.Background(), lockTimeout)
+	defer cancel()
+
+	return l.LockContext(ctx)
+}
+
+// LockContext acquires the ipset lock, it is retried until the context is
+// done, e.g. the deadline is exceeded or the request is cancelled. The
+// context which is already done fails without trying the lock, the error
+// wraps the context error for errors.Is.
+func (l *locker) LockContext(ctx context.Context) error {
 	var err error
 	var success bool
 
+	if err = ctx.Err(); err != nil {
+		return fmt.Errorf("failed to acquire ipset lock: %w", err)
+	}
+
 	defer func(l *locker) {
 		if !success {
 			// Clean up immediately on failure
@@ -35,23 +57,46 @@ func (l *locker) Lock() error {
 		return fmt.Errorf("failed to open ipset lock %s: %v", l.lockfilePath, err)
 	}
 
-	err = wait.PollImmediate(200*time.Millisecond, 2*time.Second,
-		func() (bool, error) {
-			err := grabIPSetFileLock(l.lock)
-			if err != nil {
-				return false, nil
-			}
-			return true, nil
-		})
+	for {
+		if err = grabIPSetFileLock(l.lock); err == nil {
+			break
+		}
 
-	if err != nil {
-		return fmt.Errorf("failed to acquire ipset lock: %v", err)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to acquire ipset lock: %w", ctx.Err())
+		case <-time.After(lockRetryInterval):
+		}
 	}
 
 	success = true
 	return nil
 }
 
+// contextLocker acquires the ipset lock of the wrapped locker until the
+// context is done or the lock timeout, see WithLockContext.
+type contextLocker struct {
+	ipsetLocker
+	ctx context.Context
+}
+
+func (l *contextLocker) Lock() error {
+	ctx, cancel := context.WithTimeout(l.ctx, lockTimeout)
+	defer cancel()
+
+	return l.LockContext(ctx)
+}
+
+// WithLockContext makes every ipset command wait for the ipset lock until the
+// context is done, at most the default lock timeout, e.g. the cancelled
+// context of the stopping controller fails the waiting commands promptly. The
+// error wraps the context error for errors.Is.
+func WithLockContext(ctx context.Context) RunnerOption {
+	return func(runner *runner) {
+		runner.locker = &contextLocker{ipsetLocker: runner.locker, ctx: ctx}
+	}
+}
+
 func (l *locker) Unlock() {
 	if l.lock != nil {
 		l.lock.Close()
@@ -0,0 +1,156 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	fakeexec "k8s.io/utils/exec/testing"
+)
+
+func TestLockContext(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "ipset-lock-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ipset.lock")
+
+	cases := []struct {
+		name     string
+		holdFor  time.Duration
+		timeout  time.Duration
+		expected bool
+	}{
+		{
+			name:     "Lock is free",
+			timeout:  time.Second,
+			expected: true,
+		},
+		{
+			name:     "Lock is released before deadline",
+			holdFor:  300 * time.Millisecond,
+			timeout:  2 * time.Second,
+			expected: true,
+		},
+		{
+			name:    "Lock is held past deadline",
+			holdFor: 2 * time.Second,
+			timeout: 300 * time.Millisecond,
+		},
+	}
+
+	for _, c := range cases {
+		holder := &locker{lockfilePath: path}
+		if c.holdFor > 0 {
+			if err := holder.Lock(); err != nil {
+				t.Fatalf("[%s] expected holder lock, got: %v", c.name, err)
+			}
+
+			timer := time.AfterFunc(c.holdFor, holder.Unlock)
+			defer timer.Stop()
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+
+		l := &locker{lockfilePath: path}
+		err := l.LockContext(ctx)
+		cancel()
+
+		if c.expected && err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !c.expected && !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("[%s] expected deadline exceeded, got: %v", c.name, err)
+		}
+
+		l.Unlock()
+		holder.Unlock()
+	}
+}
+
+func TestLockContextCancelled(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "ipset-lock-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ipset.lock")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	l := &locker{lockfilePath: path}
+	err = l.LockContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancelled with free lock, got: %v", err)
+	}
+	l.Unlock()
+
+	holder := &locker{lockfilePath: path}
+	if err := holder.Lock(); err != nil {
+		t.Fatalf("expected holder lock, got: %v", err)
+	}
+	defer holder.Unlock()
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	err = l.LockContext(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancelled with held lock, got: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > lockTimeout {
+		t.Errorf("expected prompt cancellation, got: %v", elapsed)
+	}
+	l.Unlock()
+}
+
+func TestWithLockContext(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "ipset-lock-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ipset.lock")
+
+	holder := &locker{lockfilePath: path}
+	if err := holder.Lock(); err != nil {
+		t.Fatalf("expected holder lock, got: %v", err)
+	}
+	defer holder.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	fexec := fakeexec.FakeExec{}
+	runner := newInternal(&fexec, path, WithLockContext(ctx))
+
+	start := time.Now()
+	err = runner.DestroySet("foo")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected cancelled with held lock, got: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > lockTimeout {
+		t.Errorf("expected prompt cancellation, got: %v", elapsed)
+	}
+
+	if fexec.CommandCalls != 0 {
+		t.Errorf("expected no command, got: %d", fexec.CommandCalls)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+const (
+	// memSetOverhead is the approximate kernel memory in bytes of the empty
+	// set, as reported in the memsize of the list header.
+	memSetOverhead = 296
+	// memBucketSize is the memory in bytes of each hash bucket in use, the
+	// buckets are allocated when the first entry is hashed to them.
+	memBucketSize = 32
+	// memEntryOverhead is the approximate memory in bytes of each hash entry
+	// besides the element, the bucket header share and the slack.
+	memEntryOverhead = 32
+	// memListEntrySize is the memory in bytes of each list:set entry.
+	memListEntrySize = 16
+)
+
+// memElementSizes are the memory in bytes of the hash set elements of the
+// inet and the inet6 family, the net elements hold the cidr as well.
+var memElementSizes = map[Type][2]int{
+	HashIP:       {4, 16},
+	HashNet:      {8, 20},
+	HashNetPort:  {12, 24},
+	HashIPPort:   {8, 20},
+	HashIPPortIP: {12, 36},
+	HashMAC:      {8, 8},
+	HashNetNet:   {12, 36},
+	HashNetIface: {24, 36},
+}
+
+// memExtensionSize returns the memory in bytes of the extensions, e.g. the
+// timeout or the counters, of each entry of the set.
+func (set *IPSet) memExtensionSize() int {
+	size := 0
+	if set.Timeout > 0 {
+		size += 8
+	}
+
+	if set.WithCounters {
+		size += 16
+	}
+
+	if set.WithComment {
+		size += 8
+	}
+
+	if set.WithSkbinfo {
+		size += 16
+	}
+
+	return size
+}
+
+// EstimateMemory returns the approximate kernel memory in bytes of the set
+// holding its Entries, at most MaxElement of them, for the capacity planning.
+// It is derived from the type, the family, the hash size and the options, e.g.
+// the hash:ip set is about 296 + the entries * 68 bytes, and the bitmap:port
+// set is the bitmap of its range. The estimate excludes the comment strings and
+// the hash table growth, and is within about a half of the memsize reported by
+// the kernel, see IPSetHeader.MemSize. The nil set is 0.
+func (set *IPSet) EstimateMemory() int {
+	if set == nil {
+		return 0
+	}
+
+	entries := len(set.Entries)
+	if set.MaxElement > 0 && entries > set.MaxElement {
+		entries = set.MaxElement
+	}
+
+	switch {
+	case set.SetType.isBitmap():
+		pr, err := ParsePortRange(set.Range)
+		if err != nil {
+			return memSetOverhead
+		}
+
+		elements := pr.End - pr.Start + 1
+
+		return memSetOverhead + (elements+7)/8 +
+			elements*set.memExtensionSize()
+	case set.SetType == ListSet:
+		return memSetOverhead +
+			entries*(memListEntrySize+set.memExtensionSize())
+	}
+
+	family := 0
+	if set.HashFamily == ProtocolFamilyIPv6 {
+		family = 1
+	}
+
+	elementSize := memElementSizes[set.SetType][family]
+
+	buckets := entries
+	if set.HashSize > 0 && buckets > set.HashSize {
+		buckets = set.HashSize
+	}
+
+	return memSetOverhead + buckets*memBucketSize +
+		entries*(memEntryOverhead+elementSize+set.memExtensionSize())
+}
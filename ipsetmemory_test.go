@@ -0,0 +1,199 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"testing"
+)
+
+func TestEstimateMemory(t *testing.T) {
+	entries := []IPSetEntry{
+		{Element: "172.18.3.2"},
+		{Element: "172.18.3.3"},
+		{Element: "172.18.3.4"},
+	}
+
+	cases := []struct {
+		name     string
+		set      *IPSet
+		expected int
+	}{
+		{
+			name:     "Empty hash:ip",
+			set:      IPSetSpec(IPSetName("foo")),
+			expected: 296,
+		},
+		{
+			name: "Hash:ip with entries",
+			set: &IPSet{Name: "foo", SetType: HashIP, HashFamily: "inet",
+				HashSize: 1024, MaxElement: 65536, Entries: entries},
+			expected: 296 + 3*(32+36),
+		},
+		{
+			name: "Entries over max element",
+			set: &IPSet{Name: "foo", SetType: HashIP, HashFamily: "inet",
+				HashSize: 64, MaxElement: 2, Entries: entries},
+			expected: 296 + 2*(32+36),
+		},
+		{
+			name: "Entries over hash size",
+			set: &IPSet{Name: "foo", SetType: HashIP, HashFamily: "inet",
+				HashSize: 2, MaxElement: 65536, Entries: entries},
+			expected: 296 + 2*32 + 3*36,
+		},
+		{
+			name: "Hash:ip inet6 with timeout",
+			set: &IPSet{Name: "foo", SetType: HashIP, HashFamily: "inet6",
+				HashSize: 4096, MaxElement: 65536, Timeout: 300,
+				Entries: entries[:1]},
+			expected: 296 + 32 + (32 + 16 + 8),
+		},
+		{
+			name: "Hash:net with counters and comment",
+			set: &IPSet{Name: "foo", SetType: HashNet, HashFamily: "inet",
+				HashSize: 1024, MaxElement: 65536, WithCounters: true,
+				WithComment: true, Entries: entries[:2]},
+			expected: 296 + 2*(32+32+8+16+8),
+		},
+		{
+			name: "Bitmap:port",
+			set: IPSetSpec(IPSetName("foo"), IPSetType(BitmapPort),
+				IPSetRange("1024-65535")),
+			expected: 296 + 64512/8,
+		},
+		{
+			name: "Bitmap:port with counters",
+			set: IPSetSpec(IPSetName("foo"), IPSetType(BitmapPort),
+				IPSetRange("0-9"), IPSetWithCounters()),
+			expected: 296 + 2 + 10*16,
+		},
+		{
+			name: "List:set",
+			set: &IPSet{Name: "foo", SetType: ListSet, MaxElement: 8,
+				Entries: entries[:2]},
+			expected: 296 + 2*16,
+		},
+		{
+			name:     "Nil set",
+			expected: 0,
+		},
+	}
+
+	for _, c := range cases {
+		estimate := c.set.EstimateMemory()
+		if estimate != c.expected {
+			t.Errorf("[%s] expected estimate %d, got: %d", c.name, c.expected,
+				estimate)
+		}
+	}
+}
+
+func TestEstimateMemoryTypes(t *testing.T) {
+	for _, setType := range ValidIPSetTypes {
+		if setType.isHash() {
+			if _, ok := memElementSizes[setType]; !ok {
+				t.Errorf("expected element size of %s", setType)
+			}
+		}
+
+		set := IPSetSpec(IPSetName("foo"), IPSetType(setType),
+			IPSetRange("1024-65535"))
+		set.Entries = []IPSetEntry{{Element: "foo"}}
+
+		empty := *set
+		empty.Entries = nil
+
+		if set.EstimateMemory() < empty.EstimateMemory() {
+			t.Errorf("[%s] expected estimate growing with the entries, "+
+				"got: %d < %d", setType, set.EstimateMemory(),
+				empty.EstimateMemory())
+		}
+	}
+}
+
+func TestEstimateMemoryListedSets(t *testing.T) {
+	entries := func(n int) []IPSetEntry {
+		return make([]IPSetEntry, n)
+	}
+
+	// The memsize of the list output fixtures.
+	cases := []struct {
+		name    string
+		set     *IPSet
+		memsize int
+	}{
+		{
+			name: "Empty hash:ip with comment",
+			set: &IPSet{Name: "foo", SetType: HashIP, HashFamily: "inet",
+				HashSize: 1024, MaxElement: 65536, WithComment: true},
+			memsize: 334,
+		},
+		{
+			name: "Empty hash:net",
+			set: &IPSet{Name: "foo", SetType: HashNet, HashFamily: "inet",
+				HashSize: 1024, MaxElement: 65536},
+			memsize: 200,
+		},
+		{
+			name: "Empty hash:ip with hashsize 2048",
+			set: &IPSet{Name: "foo", SetType: HashIP, HashFamily: "inet",
+				HashSize: 2048, MaxElement: 65536, WithComment: true},
+			memsize: 472,
+		},
+		{
+			name: "Hash:ip with 2 entries",
+			set: &IPSet{Name: "foo", SetType: HashIP, HashFamily: "inet",
+				HashSize: 1024, MaxElement: 65536, Entries: entries(2)},
+			memsize: 472,
+		},
+		{
+			name: "Hash:ip with 3 entries and comment",
+			set: &IPSet{Name: "foo", SetType: HashIP, HashFamily: "inet",
+				HashSize: 1024, MaxElement: 65536, WithComment: true,
+				Entries: entries(3)},
+			memsize: 472,
+		},
+		{
+			name: "Hash:net with 1 entry",
+			set: &IPSet{Name: "foo", SetType: HashNet, HashFamily: "inet",
+				HashSize: 1024, MaxElement: 65536, Entries: entries(1)},
+			memsize: 408,
+		},
+		{
+			name: "Hash:net,net with 2 entries",
+			set: &IPSet{Name: "foo", SetType: HashNetNet, HashFamily: "inet",
+				HashSize: 1024, MaxElement: 65536, Entries: entries(2)},
+			memsize: 408,
+		},
+		{
+			name: "Hash:ip,port with 3 entries",
+			set: &IPSet{Name: "foo", SetType: HashIPPort, HashFamily: "inet",
+				HashSize: 1024, MaxElement: 65536, Entries: entries(3)},
+			memsize: 344,
+		},
+		{
+			name: "Hash:ip,port,ip with timeout",
+			set: &IPSet{Name: "foo", SetType: HashIPPortIP,
+				HashFamily: "inet", HashSize: 1024, MaxElement: 65536,
+				Timeout: 300, Entries: entries(1)},
+			memsize: 296,
+		},
+		{
+			name: "Hash:net inet6 with timeout, counters and comment",
+			set: &IPSet{Name: "foo", SetType: HashNet, HashFamily: "inet6",
+				HashSize: 4096, MaxElement: 262144, Timeout: 300,
+				WithCounters: true, WithComment: true, Entries: entries(2)},
+			memsize: 1048,
+		},
+	}
+
+	for _, c := range cases {
+		estimate := c.set.EstimateMemory()
+		if estimate < c.memsize/2 || estimate > c.memsize*3/2 {
+			t.Errorf("[%s] expected estimate within a half of %d, got: %d",
+				c.name, c.memsize, estimate)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"errors"
+)
+
+// CreateOptions represents the options of CreateSetWithOptions, the set
+// options are enabled on top of the set specification, which is not changed.
+type CreateOptions struct {
+	// IgnoreExists ignores the error of the existing set, see CreateSet.
+	IgnoreExists bool
+	// Counters enables the counters option of the set.
+	Counters bool
+	// Comment enables the comment option of the set.
+	Comment bool
+	// Timeout overrides the default timeout of the set if it is not 0.
+	Timeout int
+}
+
+// DestroyOptions represents the options of DestroySetWithOptions.
+type DestroyOptions struct {
+	// IgnoreNotFound ignores the error of the set which does not exist.
+	IgnoreNotFound bool
+}
+
+// AddOptions represents the options of AddEntryWithOptions, the entry
+// options are set on top of the entry, which is not changed. The forceadd is
+// the set option of ipset create, not of the add.
+type AddOptions struct {
+	// IgnoreExists ignores the error of the existing entry, see AddEntry.
+	IgnoreExists bool
+	// Timeout overrides the timeout of the entry if it is not 0.
+	Timeout int
+	// Packets overrides the initial packets counter of the entry if it is
+	// not 0.
+	Packets uint64
+	// Bytes overrides the initial bytes counter of the entry if it is not 0.
+	Bytes uint64
+}
+
+// DefaultAddOptions returns the options of AddEntry without ignoreExistErr.
+func DefaultAddOptions() AddOptions {
+	return AddOptions{IgnoreExists: false}
+}
+
+// apply returns the copy of the entry with the options set.
+func (opts AddOptions) apply(entry *IPSetEntry) *IPSetEntry {
+	if entry == nil {
+		return nil
+	}
+
+	added := *entry
+
+	if opts.Timeout != 0 {
+		added.Timeout = opts.Timeout
+	}
+
+	if opts.Packets != 0 {
+		added.Packets = opts.Packets
+	}
+
+	if opts.Bytes != 0 {
+		added.Bytes = opts.Bytes
+	}
+
+	return &added
+}
+
+// apply returns the copy of the set specification with the options enabled.
+func (opts CreateOptions) apply(set *IPSet) *IPSet {
+	if set == nil {
+		return nil
+	}
+
+	created := *set
+
+	if opts.Counters {
+		created.WithCounters = true
+	}
+
+	if opts.Comment {
+		created.WithComment = true
+	}
+
+	if opts.Timeout != 0 {
+		created.Timeout = opts.Timeout
+	}
+
+	return &created
+}
+
+// CreateSetWithOptions creates a new set with provided specification and the
+// options, CreateSet(set, ignoreExistErr) is the same as the options with
+// IgnoreExists only.
+func (runner *runner) CreateSetWithOptions(set *IPSet,
+	opts CreateOptions) error {
+	return runner.CreateSet(opts.apply(set), opts.IgnoreExists)
+}
+
+// AddEntryWithOptions adds an entry to the specified set name with the
+// options, AddEntry(entry, setname, ignoreExistErr) is the same as the options
+// with IgnoreExists only.
+func (runner *runner) AddEntryWithOptions(entry *IPSetEntry, setname SetName,
+	opts AddOptions) error {
+	return runner.AddEntry(opts.apply(entry), setname, opts.IgnoreExists)
+}
+
+// DestroySetWithOptions destroys the specified set name with the options,
+// DestroySet(setname) is the same as the zero options.
+func (runner *runner) DestroySetWithOptions(setname SetName,
+	opts DestroyOptions) error {
+	err := runner.DestroySet(setname)
+	if opts.IgnoreNotFound && errors.Is(err, ErrSetNotFound) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,239 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"k8s.io/utils/exec"
+	fakeexec "k8s.io/utils/exec/testing"
+)
+
+func TestCreateSetWithOptions(t *testing.T) {
+	for combination := 0; combination < 16; combination++ {
+		opts := CreateOptions{
+			IgnoreExists: combination&1 != 0,
+			Counters:     combination&2 != 0,
+			Comment:      combination&4 != 0,
+		}
+		if combination&8 != 0 {
+			opts.Timeout = 300
+		}
+		name := fmt.Sprintf("%+v", opts)
+
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				// Success
+				func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		set := IPSetSpec(IPSetName("foo"))
+		err := runner.CreateSetWithOptions(set, opts)
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", name, err)
+		}
+
+		if set.WithCounters || set.WithComment || set.Timeout != 0 {
+			t.Errorf("[%s] expected unchanged specification, got: %+v", name,
+				set)
+		}
+
+		expected := []string{"ipset", "create", "foo", "hash:ip",
+			"family", "inet", "hashsize", testDefaultHashSize,
+			"maxelem", testDefaultMaxElement}
+		if opts.Timeout > 0 {
+			expected = append(expected, "timeout", "300")
+		}
+		if opts.Counters {
+			expected = append(expected, "counters")
+		}
+		if opts.Comment {
+			expected = append(expected, "comment")
+		}
+		if opts.IgnoreExists {
+			expected = append(expected, "-exist")
+		}
+		expected = append(expected, "-o", "xml")
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog,
+			[][]string{expected}) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", name,
+				fcmd.CombinedOutputLog)
+		}
+	}
+}
+
+func TestDestroySetWithOptions(t *testing.T) {
+	cases := []struct {
+		name        string
+		opts        DestroyOptions
+		output      string
+		failed      bool
+		expectedErr error
+	}{
+		{
+			name: "Destroy set",
+		},
+		{
+			name: "Destroy set ignoring not found",
+			opts: DestroyOptions{IgnoreNotFound: true},
+		},
+		{
+			name:        "Destroy missing set",
+			output:      "ipset v7.6: The set with the given name does not exist",
+			failed:      true,
+			expectedErr: ErrSetNotFound,
+		},
+		{
+			name:   "Destroy missing set ignoring not found",
+			opts:   DestroyOptions{IgnoreNotFound: true},
+			output: "ipset v7.6: The set with the given name does not exist",
+			failed: true,
+		},
+		{
+			name: "Destroy set in use ignoring not found",
+			opts: DestroyOptions{IgnoreNotFound: true},
+			output: "ipset v7.6: Set cannot be destroyed: it is in use by " +
+				"a kernel component",
+			failed:      true,
+			expectedErr: ErrSetInUse,
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				func() ([]byte, []byte, error) {
+					if c.failed {
+						return []byte(c.output), nil,
+							&fakeexec.FakeExitError{Status: 1}
+					}
+
+					return []byte{}, nil, nil
+				},
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		err := runner.DestroySetWithOptions("foo", c.opts)
+		if c.expectedErr != nil {
+			if !errors.Is(err, c.expectedErr) {
+				t.Errorf("[%s] expected error %v, got: %v", c.name,
+					c.expectedErr, err)
+			}
+		} else if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog,
+			[][]string{{"ipset", "destroy", "foo", "-o", "xml"}}) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog)
+		}
+	}
+}
+
+func TestAddEntryWithOptions(t *testing.T) {
+	cases := []struct {
+		name         string
+		opts         AddOptions
+		expectedArgs []string
+	}{
+		{
+			name:         "Default options",
+			opts:         DefaultAddOptions(),
+			expectedArgs: []string{},
+		},
+		{
+			name:         "Ignore exists",
+			opts:         AddOptions{IgnoreExists: true},
+			expectedArgs: []string{"-exist"},
+		},
+		{
+			name:         "Timeout",
+			opts:         AddOptions{Timeout: 300},
+			expectedArgs: []string{"timeout", "300"},
+		},
+		{
+			name:         "Packets",
+			opts:         AddOptions{Packets: 10},
+			expectedArgs: []string{"packets", "10"},
+		},
+		{
+			name:         "Bytes",
+			opts:         AddOptions{Bytes: 1024},
+			expectedArgs: []string{"bytes", "1024"},
+		},
+		{
+			name: "All options",
+			opts: AddOptions{IgnoreExists: true, Timeout: 300, Packets: 10,
+				Bytes: 1024},
+			expectedArgs: []string{"timeout", "300", "packets", "10",
+				"bytes", "1024", "-exist"},
+		},
+	}
+
+	for _, c := range cases {
+		fcmd := fakeexec.FakeCmd{
+			CombinedOutputScript: []fakeexec.FakeAction{
+				// Success
+				func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		entry := &IPSetEntry{Element: "172.18.3.2"}
+		err := runner.AddEntryWithOptions(entry, "foo", c.opts)
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if entry.Timeout != 0 || entry.Packets != 0 || entry.Bytes != 0 {
+			t.Errorf("[%s] expected unchanged entry, got: %+v", c.name, entry)
+		}
+
+		expected := append([]string{"ipset", "add", "foo", "172.18.3.2"},
+			c.expectedArgs...)
+		expected = append(expected, "-o", "xml")
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog,
+			[][]string{expected}) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog)
+		}
+	}
+}
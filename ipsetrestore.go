@@ -0,0 +1,504 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RestoreError represents the failure of a line of the ipset restore script.
+type RestoreError struct {
+	Line    int
+	Command string
+	Output  string
+}
+
+func (e *RestoreError) Error() string {
+	return fmt.Sprintf("error restoring line %d %q, output: %s", e.Line,
+		e.Command, e.Output)
+}
+
+// SetsError represents the failure of the batch operation of the sets, e.g.
+// CreateSets, keyed by set name. The restore stops at the first failed line,
+// so the set of that line has the RestoreError and the sets after it, which
+// are not applied, have their error too.
+type SetsError struct {
+	Errors map[string]error
+
+	// restoreErr is the RestoreError of the failed line, if any.
+	restoreErr error
+}
+
+func (e *SetsError) Error() string {
+	setnames := make([]string, 0, len(e.Errors))
+	for setname := range e.Errors {
+		setnames = append(setnames, setname)
+	}
+	sort.Strings(setnames)
+
+	messages := make([]string, 0, len(setnames))
+	for _, setname := range setnames {
+		messages = append(messages, fmt.Sprintf("%s: %v", setname,
+			e.Errors[setname]))
+	}
+
+	return fmt.Sprintf("error with %d sets, %s", len(setnames),
+		strings.Join(messages, "; "))
+}
+
+// Unwrap returns the RestoreError of the failed line, if any.
+func (e *SetsError) Unwrap() error {
+	return e.restoreErr
+}
+
+// newSetsError returns the SetsError of the restore failure of the commands
+// of the set names, or the restore error as is if the failed line is unknown.
+func newSetsError(setnames []string, err error) error {
+	var restoreErr *RestoreError
+	if !errors.As(err, &restoreErr) || restoreErr.Line < 1 ||
+		restoreErr.Line > len(setnames) {
+		return err
+	}
+
+	errs := map[string]error{setnames[restoreErr.Line-1]: restoreErr}
+	for _, setname := range setnames[restoreErr.Line:] {
+		errs[setname] = fmt.Errorf("set %s is not applied, the restore "+
+			"stopped at line %d", setname, restoreErr.Line)
+	}
+
+	return &SetsError{Errors: errs, restoreErr: restoreErr}
+}
+
+var restoreErrorLine = regexp.MustCompile(`Error in line (\d+):`)
+
+// restoreLine formats the command arguments into an ipset restore line, the
+// argument with whitespaces, e.g. comment, is quoted.
+func restoreLine(args []string) string {
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		if len(arg) == 0 || strings.ContainsAny(arg, " \t") {
+			arg = `"` + arg + `"`
+		}
+
+		quoted = append(quoted, arg)
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// restoreScript formats the commands into the ipset restore script lines.
+func restoreScript(commands [][]string) []string {
+	lines := make([]string, 0, len(commands))
+	for _, args := range commands {
+		lines = append(lines, restoreLine(args))
+	}
+
+	return lines
+}
+
+// restore pipes the commands as a script to ipset restore, the failed line is
+// returned as the RestoreError. The caller holds the lock.
+func (runner *runner) restore(commands [][]string, ignoreExistErr bool) error {
+	return runner.restoreLines(restoreScript(commands), ignoreExistErr)
+}
+
+// restoreLines pipes the script lines to ipset restore, the failed line is
+// returned as the RestoreError. The caller holds the lock.
+func (runner *runner) restoreLines(lines []string, ignoreExistErr bool) error {
+	cmdArgs := []string{"restore"}
+	if ignoreExistErr {
+		cmdArgs = append(cmdArgs, "-exist")
+	}
+
+	var script strings.Builder
+	for _, line := range lines {
+		script.WriteString(line)
+		script.WriteString("\n")
+	}
+
+	out, err := runner.runWithStdin(cmdArgs, []byte(script.String()))
+	if err != nil {
+		return newRestoreError(lines, out, err)
+	}
+
+	return nil
+}
+
+// newRestoreError returns the RestoreError of the failed line reported by
+// ipset restore, or the exec error if the line is unknown.
+func newRestoreError(lines []string, out []byte, err error) error {
+	output := strings.TrimSpace(string(out))
+
+	match := restoreErrorLine.FindStringSubmatch(output)
+	if match == nil {
+		return fmt.Errorf("error restoring, error: %v, output: %s", err,
+			output)
+	}
+
+	line, _ := strconv.Atoi(match[1])
+	command := ""
+	if line > 0 && line <= len(lines) {
+		command = lines[line-1]
+	}
+
+	return &RestoreError{
+		Line:    line,
+		Command: command,
+		Output:  output,
+	}
+}
+
+// CreateSetAndAddEntries creates a new set and adds the entries to it with a
+// single ipset restore.
+func (runner *runner) CreateSetAndAddEntries(set *IPSet, entries []IPSetEntry,
+	ignoreExistErr bool) error {
+	set = runner.withDefaultFamily(set)
+
+	err := set.Validate()
+	if err != nil {
+		return fmt.Errorf("error creating set: %v, error: %w", set, err)
+	}
+
+	commands := [][]string{buildCreateArgs(set)}
+
+	addCommands, err := buildRestoreAddArgs(set.Name, set.SetType, entries)
+	if err != nil {
+		return err
+	}
+	commands = append(commands, addCommands...)
+
+	err = runner.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer runner.locker.Unlock()
+
+	err = runner.restore(commands, ignoreExistErr)
+	if err != nil {
+		return fmt.Errorf("error creating set %s with entries, error: %w",
+			set.Name, err)
+	}
+
+	runner.cacheSet(set)
+
+	return nil
+}
+
+// CreateSets creates the sets with a single ipset restore instead of a
+// command per set. The sets are validated first, all invalid sets are
+// returned as the SetsError and nothing is created. The restore failure is
+// returned as the SetsError of the failed set and the sets after it, the sets
+// before it are created.
+func (runner *runner) CreateSets(sets []*IPSet, ignoreExistErr bool) error {
+	invalid := map[string]error{}
+	valid := make([]*IPSet, 0, len(sets))
+	setnames := make([]string, 0, len(sets))
+	commands := make([][]string, 0, len(sets))
+
+	for _, set := range sets {
+		set = runner.withDefaultFamily(set)
+
+		err := set.Validate()
+		if err != nil {
+			setname := ""
+			if set != nil {
+				setname = set.Name
+			}
+
+			invalid[setname] = fmt.Errorf("error creating set: %v, error: %w",
+				set, err)
+			continue
+		}
+
+		valid = append(valid, set)
+		setnames = append(setnames, set.Name)
+		commands = append(commands, buildCreateArgs(set))
+	}
+
+	if len(invalid) > 0 {
+		return &SetsError{Errors: invalid}
+	}
+
+	if len(commands) == 0 {
+		return nil
+	}
+
+	err := runner.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer runner.locker.Unlock()
+
+	err = runner.restore(commands, ignoreExistErr)
+
+	created := valid
+	if err != nil {
+		created = nil
+
+		var restoreErr *RestoreError
+		if errors.As(err, &restoreErr) && restoreErr.Line > 1 &&
+			restoreErr.Line <= len(valid) {
+			created = valid[:restoreErr.Line-1]
+		}
+	}
+
+	for _, set := range created {
+		runner.cacheSet(set)
+	}
+
+	if err != nil {
+		return newSetsError(setnames, err)
+	}
+
+	return nil
+}
+
+// DestroySets destroys the sets with a single ipset restore instead of a
+// command per set. The set names are validated first, all invalid names are
+// returned as the SetsError and nothing is destroyed. The ipset -exist does
+// not cover the destroy, so the sets which do not exist are left out of the
+// restore if ignoreNotExistErr is set, which costs an ipset list of the set
+// names. The restore failure, e.g. the set in use, is returned as the
+// SetsError of the failed set and the sets after it.
+func (runner *runner) DestroySets(setnames []SetName,
+	ignoreNotExistErr bool) error {
+	invalid := map[string]error{}
+	for _, setname := range setnames {
+		err := setname.Validate()
+		if err != nil {
+			invalid[string(setname)] = err
+		}
+	}
+
+	if len(invalid) > 0 {
+		return &SetsError{Errors: invalid}
+	}
+
+	if len(setnames) == 0 {
+		return nil
+	}
+
+	err := runner.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer runner.locker.Unlock()
+
+	existing := map[string]bool{}
+	if ignoreNotExistErr {
+		names, err := runner.listSetNames()
+		if err != nil {
+			return fmt.Errorf("error destroying sets, error: %v", err)
+		}
+
+		for _, name := range names {
+			existing[name] = true
+		}
+	}
+
+	destroyed := make([]string, 0, len(setnames))
+	commands := make([][]string, 0, len(setnames))
+	for _, setname := range setnames {
+		if ignoreNotExistErr && !existing[string(setname)] {
+			continue
+		}
+
+		destroyed = append(destroyed, string(setname))
+		commands = append(commands, []string{"destroy", string(setname)})
+	}
+
+	if len(commands) == 0 {
+		return nil
+	}
+
+	err = runner.restore(commands, false)
+
+	for _, setname := range destroyed {
+		runner.invalidateCache(setname)
+	}
+
+	if err != nil {
+		return newSetsError(destroyed, err)
+	}
+
+	return nil
+}
+
+// AddEntryBatch adds the entries to the specified set name with a single
+// ipset restore -exist, the existing entries are not an error. The entry
+// options, e.g. comment and timeout, are added as AddEntry does. The failed
+// line is returned as the RestoreError, the entries before it are added.
+func (runner *runner) AddEntryBatch(setname SetName,
+	entries []IPSetEntry) error {
+	if len(setname) == 0 {
+		return ErrEmptySetName
+	}
+
+	commands, err := buildRestoreAddArgs(string(setname), "", entries)
+	if err != nil {
+		return err
+	}
+
+	if len(commands) == 0 {
+		return nil
+	}
+
+	err = runner.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer runner.locker.Unlock()
+
+	err = runner.restore(commands, true)
+	if err != nil {
+		return fmt.Errorf("error adding entries to set %s, error: %w",
+			setname, err)
+	}
+
+	return nil
+}
+
+// DelEntries deletes the elements from the specified set name with a single
+// ipset restore, which is much faster than DelEntry one by one for the mass
+// cleanup. The missing elements are ignored with -exist if ignoreNotExistErr
+// is set. The failed line is returned as the RestoreError, the elements
+// before it are deleted.
+func (runner *runner) DelEntries(elements []string, setname SetName,
+	ignoreNotExistErr bool) error {
+	if len(setname) == 0 {
+		return ErrEmptySetName
+	}
+
+	commands := make([][]string, 0, len(elements))
+	for _, element := range elements {
+		entry := &IPSetEntry{Element: element}
+
+		err := entry.validate()
+		if err != nil {
+			return fmt.Errorf("error deleting entry %s, error: %v", element,
+				err)
+		}
+
+		commands = append(commands, []string{"del", string(setname), element})
+	}
+
+	if len(commands) == 0 {
+		return nil
+	}
+
+	err := runner.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer runner.locker.Unlock()
+
+	err = runner.restore(commands, ignoreNotExistErr)
+	if err != nil {
+		return fmt.Errorf("error deleting entries from set %s, error: %w",
+			setname, err)
+	}
+
+	return nil
+}
+
+// buildRestoreAddArgs validates the entries and builds their add commands of
+// the set name, the entries of the unknown set type, e.g. empty, are checked
+// regardless of the type.
+func buildRestoreAddArgs(setname string, setType Type,
+	entries []IPSetEntry) ([][]string, error) {
+	commands := make([][]string, 0, len(entries))
+	for idx := range entries {
+		entry := &entries[idx]
+
+		err := entry.validate()
+		if err == nil {
+			err = ValidateEntryForSet(entry, setType)
+		}
+
+		if err != nil && !errors.Is(err, ErrUnsupportedType) {
+			return nil, fmt.Errorf("error adding entry %+v, error: %v", entry,
+				err)
+		}
+
+		commands = append(commands,
+			append([]string{"add", setname}, buildEntryArgs(entry)...))
+	}
+
+	return commands, nil
+}
+
+// ApplyBatch creates the sets and adds the entries keyed by set name with a
+// single ipset restore, the sets are created first, then the entries are
+// added in the set name order. The entries of the set which is not created
+// here must be added to the existing set. Everything is validated before the
+// restore is run. The batch is not atomic, the ipset restore stops at the
+// first failed line, which is returned as the RestoreError, but the lines
+// before it are already applied and are not rolled back.
+func (runner *runner) ApplyBatch(creates []*IPSet,
+	entriesBySet map[string][]IPSetEntry) error {
+	types := map[string]Type{}
+	created := make([]*IPSet, 0, len(creates))
+	commands := [][]string{}
+
+	for _, set := range creates {
+		set = runner.withDefaultFamily(set)
+
+		err := set.Validate()
+		if err != nil {
+			return fmt.Errorf("error creating set: %v, error: %w", set, err)
+		}
+
+		created = append(created, set)
+		types[set.Name] = set.SetType
+		commands = append(commands, buildCreateArgs(set))
+	}
+
+	setnames := make([]string, 0, len(entriesBySet))
+	for setname := range entriesBySet {
+		setnames = append(setnames, setname)
+	}
+	sort.Strings(setnames)
+
+	for _, setname := range setnames {
+		err := ValidateSetName(setname)
+		if err != nil {
+			return fmt.Errorf("error applying entries of set %s, error: %v",
+				setname, err)
+		}
+
+		addCommands, err := buildRestoreAddArgs(setname, types[setname],
+			entriesBySet[setname])
+		if err != nil {
+			return err
+		}
+		commands = append(commands, addCommands...)
+	}
+
+	if len(commands) == 0 {
+		return nil
+	}
+
+	err := runner.locker.Lock()
+	if err != nil {
+		return err
+	}
+	defer runner.locker.Unlock()
+
+	err = runner.restore(commands, false)
+	if err != nil {
+		return fmt.Errorf("error applying sets, error: %w", err)
+	}
+
+	for _, set := range created {
+		runner.cacheSet(set)
+	}
+
+	return nil
+}
@@ -0,0 +1,785 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"testing"
+
+	"k8s.io/utils/exec"
+	fakeexec "k8s.io/utils/exec/testing"
+)
+
+func TestCreateSetAndAddEntries(t *testing.T) {
+	cases := []struct {
+		name              string
+		set               *IPSet
+		entries           []IPSetEntry
+		ignoreExistErr    bool
+		output            string
+		failed            bool
+		combinedOutputLog []string
+		expectedScript    string
+		expectedLine      int
+		expectedCommand   string
+	}{
+		{
+			name: "Create set with entries",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetType(HashIP),
+				IPSetWithComment(),
+			),
+			entries: []IPSetEntry{
+				{Element: "172.18.3.2", Comment: "ContainerID: deadbeaf"},
+				{Element: "172.18.3.3"},
+			},
+			combinedOutputLog: []string{"ipset", "restore"},
+			expectedScript: "create foo hash:ip family inet hashsize 1024 " +
+				"maxelem 65536 comment\n" +
+				"add foo 172.18.3.2 comment \"ContainerID: deadbeaf\"\n" +
+				"add foo 172.18.3.3\n",
+		},
+		{
+			name: "Create set without entries ignoring exist error",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetType(HashNet),
+			),
+			entries:           []IPSetEntry{},
+			ignoreExistErr:    true,
+			combinedOutputLog: []string{"ipset", "restore", "-exist"},
+			expectedScript:    "create foo hash:net hashsize 1024 maxelem 65536\n",
+		},
+		{
+			name: "Create set failure",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetType(HashIP),
+			),
+			entries: []IPSetEntry{
+				{Element: "172.18.3.2"},
+			},
+			output:          "ipset v7.6: Error in line 1: Set cannot be created: set with the same name already exists",
+			failed:          true,
+			expectedLine:    1,
+			expectedCommand: "create foo hash:ip family inet hashsize 1024 maxelem 65536",
+		},
+		{
+			name: "Add entry failure",
+			set: IPSetSpec(
+				IPSetName("foo"),
+				IPSetType(HashIP),
+			),
+			entries: []IPSetEntry{
+				{Element: "172.18.3.2"},
+				{Element: "172.18.3.2"},
+			},
+			output:          "ipset v7.6: Error in line 3: Element cannot be added to the set: it's already added",
+			failed:          true,
+			expectedLine:    3,
+			expectedCommand: "add foo 172.18.3.2",
+		},
+	}
+
+	for _, c := range cases {
+		var script []byte
+
+		fcmd := fakeexec.FakeCmd{}
+		fcmd.CombinedOutputScript = []fakeexec.FakeAction{
+			func() ([]byte, []byte, error) {
+				script, _ = ioutil.ReadAll(fcmd.Stdin)
+
+				if c.failed {
+					return []byte(c.output), nil, &fakeexec.FakeExitError{Status: 1}
+				}
+
+				return []byte{}, nil, nil
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		err := runner.CreateSetAndAddEntries(c.set, c.entries, c.ignoreExistErr)
+		if c.failed {
+			var restoreErr *RestoreError
+			if !errors.As(err, &restoreErr) {
+				t.Errorf("[%s] expected restore error, got: %v", c.name, err)
+				continue
+			}
+
+			if restoreErr.Line != c.expectedLine ||
+				restoreErr.Command != c.expectedCommand {
+				t.Errorf("[%s] expected failed line %d %q, got: %d %q", c.name,
+					c.expectedLine, c.expectedCommand, restoreErr.Line,
+					restoreErr.Command)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog[0], c.combinedOutputLog) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog[0])
+		}
+
+		if string(script) != c.expectedScript {
+			t.Errorf("[%s] expected script:\n%s\ngot:\n%s", c.name,
+				c.expectedScript, script)
+		}
+	}
+}
+
+func TestCreateSetAndAddEntriesInvalid(t *testing.T) {
+	fexec := fakeexec.FakeExec{}
+	runner := newInternal(&fexec, testIPSetLockfilePath)
+
+	err := runner.CreateSetAndAddEntries(
+		IPSetSpec(IPSetName("foo"), IPSetType(HashNet)),
+		[]IPSetEntry{{Element: "172.18.3.2/24"}}, false)
+	if err == nil {
+		t.Errorf("expected failure, got: nil")
+	}
+
+	if fexec.CommandCalls != 0 {
+		t.Errorf("expected 0 Command() calls, got: %d", fexec.CommandCalls)
+	}
+}
+
+func TestApplyBatch(t *testing.T) {
+	cases := []struct {
+		name           string
+		creates        []*IPSet
+		entriesBySet   map[string][]IPSetEntry
+		output         string
+		failed         bool
+		expectedScript string
+		expectedLine   int
+		expectedError  bool
+	}{
+		{
+			name: "Create sets and add entries",
+			creates: []*IPSet{
+				IPSetSpec(IPSetName("foo"), IPSetType(HashIP)),
+				IPSetSpec(IPSetName("bar"), IPSetType(HashNet)),
+			},
+			entriesBySet: map[string][]IPSetEntry{
+				"foo": {{Element: "172.18.3.2"}},
+				"bar": {{Element: "172.18.4.0/24"}},
+				"baz": {{Element: "foo"}},
+			},
+			expectedScript: "create foo hash:ip family inet hashsize 1024 " +
+				"maxelem 65536\n" +
+				"create bar hash:net hashsize 1024 maxelem 65536\n" +
+				"add bar 172.18.4.0/24\n" +
+				"add baz foo\n" +
+				"add foo 172.18.3.2\n",
+		},
+		{
+			name: "Restore failure",
+			creates: []*IPSet{
+				IPSetSpec(IPSetName("foo"), IPSetType(HashIP)),
+			},
+			entriesBySet: map[string][]IPSetEntry{
+				"foo": {{Element: "172.18.3.2"}},
+			},
+			output:       "ipset v7.6: Error in line 2: Element cannot be added to the set: it's already added",
+			failed:       true,
+			expectedLine: 2,
+		},
+		{
+			name: "Invalid entry of created set",
+			creates: []*IPSet{
+				IPSetSpec(IPSetName("foo"), IPSetType(HashIP)),
+			},
+			entriesBySet: map[string][]IPSetEntry{
+				"foo": {{Element: "172.18.4"}},
+			},
+			expectedError: true,
+		},
+		{
+			name: "Invalid set",
+			creates: []*IPSet{
+				IPSetSpec(IPSetName("foo"), IPSetHashSize(0)),
+			},
+			expectedError: true,
+		},
+		{
+			name: "Invalid set name of entries",
+			entriesBySet: map[string][]IPSetEntry{
+				"-foo": {{Element: "172.18.3.2"}},
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		var script []byte
+
+		fcmd := fakeexec.FakeCmd{}
+		fcmd.CombinedOutputScript = []fakeexec.FakeAction{
+			func() ([]byte, []byte, error) {
+				script, _ = ioutil.ReadAll(fcmd.Stdin)
+
+				if c.failed {
+					return []byte(c.output), nil, &fakeexec.FakeExitError{Status: 1}
+				}
+
+				return []byte{}, nil, nil
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		err := runner.ApplyBatch(c.creates, c.entriesBySet)
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+
+			if len(fcmd.CombinedOutputLog) != 0 {
+				t.Errorf("[%s] expected no command, got: %s", c.name,
+					fcmd.CombinedOutputLog)
+			}
+
+			continue
+		}
+
+		if c.failed {
+			var restoreErr *RestoreError
+			if !errors.As(err, &restoreErr) {
+				t.Errorf("[%s] expected restore error, got: %v", c.name, err)
+				continue
+			}
+
+			if restoreErr.Line != c.expectedLine {
+				t.Errorf("[%s] expected failed line %d, got: %d", c.name,
+					c.expectedLine, restoreErr.Line)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog[0],
+			[]string{"ipset", "restore"}) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog[0])
+		}
+
+		if string(script) != c.expectedScript {
+			t.Errorf("[%s] expected script:\n%s\ngot:\n%s", c.name,
+				c.expectedScript, script)
+		}
+	}
+}
+
+func TestAddEntryBatch(t *testing.T) {
+	cases := []struct {
+		name           string
+		entries        []IPSetEntry
+		output         string
+		failed         bool
+		expectedScript string
+		expectedLine   int
+		expectedError  bool
+	}{
+		{
+			name: "Entries with and without options",
+			entries: []IPSetEntry{
+				{Element: "172.18.3.2", Comment: "ContainerID: deadbeaf"},
+				{Element: "172.18.3.3", Timeout: 300},
+				{Element: "172.18.3.4", Timeout: 60, Comment: "owner"},
+				{Element: "172.18.3.5"},
+			},
+			expectedScript: "add foo 172.18.3.2 comment \"ContainerID: deadbeaf\"\n" +
+				"add foo 172.18.3.3 timeout 300\n" +
+				"add foo 172.18.3.4 timeout 60 comment owner\n" +
+				"add foo 172.18.3.5\n",
+		},
+		{
+			name: "Restore failure",
+			entries: []IPSetEntry{
+				{Element: "172.18.3.2"},
+				{Element: "172.18.3.300"},
+			},
+			output:       "ipset v7.6: Error in line 2: Syntax error: '172.18.3.300' is invalid as number",
+			failed:       true,
+			expectedLine: 2,
+		},
+		{
+			name: "Invalid entry",
+			entries: []IPSetEntry{
+				{Element: "172.18.3.2 172.18.3.3"},
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		var script []byte
+
+		fcmd := fakeexec.FakeCmd{}
+		fcmd.CombinedOutputScript = []fakeexec.FakeAction{
+			func() ([]byte, []byte, error) {
+				script, _ = ioutil.ReadAll(fcmd.Stdin)
+
+				if c.failed {
+					return []byte(c.output), nil, &fakeexec.FakeExitError{Status: 1}
+				}
+
+				return []byte{}, nil, nil
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		err := runner.AddEntryBatch("foo", c.entries)
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+
+			if fexec.CommandCalls != 0 {
+				t.Errorf("[%s] expected 0 Command() calls, got: %d", c.name,
+					fexec.CommandCalls)
+			}
+
+			continue
+		}
+
+		if c.failed {
+			var restoreErr *RestoreError
+			if !errors.As(err, &restoreErr) || restoreErr.Line != c.expectedLine {
+				t.Errorf("[%s] expected restore error of line %d, got: %v",
+					c.name, c.expectedLine, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog[0],
+			[]string{"ipset", "restore", "-exist"}) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog[0])
+		}
+
+		if string(script) != c.expectedScript {
+			t.Errorf("[%s] expected script:\n%s\ngot:\n%s", c.name,
+				c.expectedScript, script)
+		}
+	}
+}
+
+func TestDelEntries(t *testing.T) {
+	cases := []struct {
+		name              string
+		elements          []string
+		ignoreNotExistErr bool
+		output            string
+		failed            bool
+		expectedArgs      []string
+		expectedScript    string
+		expectedLine      int
+		expectedError     bool
+	}{
+		{
+			name:           "Delete elements",
+			elements:       []string{"172.18.3.2", "172.18.3.3"},
+			expectedArgs:   []string{"ipset", "restore"},
+			expectedScript: "del foo 172.18.3.2\ndel foo 172.18.3.3\n",
+		},
+		{
+			name:              "Ignore missing elements",
+			elements:          []string{"172.18.3.2", "172.18.3.3"},
+			ignoreNotExistErr: true,
+			expectedArgs:      []string{"ipset", "restore", "-exist"},
+			expectedScript:    "del foo 172.18.3.2\ndel foo 172.18.3.3\n",
+		},
+		{
+			name:     "Restore failure",
+			elements: []string{"172.18.3.2", "172.18.3.3"},
+			output: "ipset v7.6: Error in line 2: Element cannot be deleted " +
+				"from the set: it's not added",
+			failed:       true,
+			expectedLine: 2,
+		},
+		{
+			name:          "Invalid element",
+			elements:      []string{"172.18.3.2", ""},
+			expectedError: true,
+		},
+		{
+			name: "No elements",
+		},
+	}
+
+	for _, c := range cases {
+		var script []byte
+
+		fcmd := fakeexec.FakeCmd{}
+		fcmd.CombinedOutputScript = []fakeexec.FakeAction{
+			func() ([]byte, []byte, error) {
+				script, _ = ioutil.ReadAll(fcmd.Stdin)
+
+				if c.failed {
+					return []byte(c.output), nil, &fakeexec.FakeExitError{Status: 1}
+				}
+
+				return []byte{}, nil, nil
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		err := runner.DelEntries(c.elements, "foo", c.ignoreNotExistErr)
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+		} else if c.failed {
+			var restoreErr *RestoreError
+			if !errors.As(err, &restoreErr) || restoreErr.Line != c.expectedLine {
+				t.Errorf("[%s] expected restore error of line %d, got: %v",
+					c.name, c.expectedLine, err)
+			}
+
+			continue
+		} else if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if c.expectedArgs == nil {
+			if fexec.CommandCalls != 0 {
+				t.Errorf("[%s] expected 0 Command() calls, got: %d", c.name,
+					fexec.CommandCalls)
+			}
+
+			continue
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog[0], c.expectedArgs) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog[0])
+		}
+
+		if string(script) != c.expectedScript {
+			t.Errorf("[%s] expected script:\n%s\ngot:\n%s", c.name,
+				c.expectedScript, script)
+		}
+	}
+}
+
+func TestCreateSets(t *testing.T) {
+	sets := []*IPSet{
+		IPSetSpec(IPSetName("foo")),
+		IPSetSpec(IPSetName("bar"), IPSetType(HashNet), IPSetWithComment()),
+		IPSetSpec(IPSetName("baz"), IPSetType(BitmapPort),
+			IPSetRange("1024-65535")),
+	}
+
+	cases := []struct {
+		name           string
+		sets           []*IPSet
+		ignoreExistErr bool
+		output         string
+		failed         bool
+		expectedArgs   []string
+		expectedScript string
+		expectedErrors []string
+	}{
+		{
+			name:         "Create sets",
+			sets:         sets,
+			expectedArgs: []string{"ipset", "restore"},
+			expectedScript: "create foo hash:ip family inet hashsize " +
+				testDefaultHashSize + " maxelem " + testDefaultMaxElement +
+				"\n" +
+				"create bar hash:net hashsize " +
+				testDefaultHashSize + " maxelem " + testDefaultMaxElement +
+				" comment\n" +
+				"create baz bitmap:port range 1024-65535\n",
+		},
+		{
+			name:           "Create sets ignoring exist",
+			sets:           sets[:1],
+			ignoreExistErr: true,
+			expectedArgs:   []string{"ipset", "restore", "-exist"},
+			expectedScript: "create foo hash:ip family inet hashsize " +
+				testDefaultHashSize + " maxelem " + testDefaultMaxElement +
+				"\n",
+		},
+		{
+			name: "Restore failure",
+			sets: sets,
+			output: "ipset v7.6: Error in line 2: Set cannot be created: " +
+				"set with the same name already exists",
+			failed:         true,
+			expectedArgs:   []string{"ipset", "restore"},
+			expectedErrors: []string{"bar", "baz"},
+		},
+		{
+			name: "Invalid sets",
+			sets: []*IPSet{
+				IPSetSpec(IPSetName("foo"), IPSetHashSize(0)),
+				sets[1],
+				IPSetSpec(IPSetName("baz"), IPSetType(BitmapPort)),
+			},
+			expectedErrors: []string{"baz", "foo"},
+		},
+		{
+			name: "No sets",
+		},
+	}
+
+	for _, c := range cases {
+		var script []byte
+
+		fcmd := fakeexec.FakeCmd{}
+		fcmd.CombinedOutputScript = []fakeexec.FakeAction{
+			func() ([]byte, []byte, error) {
+				script, _ = ioutil.ReadAll(fcmd.Stdin)
+
+				if c.failed {
+					return []byte(c.output), nil, &fakeexec.FakeExitError{Status: 1}
+				}
+
+				return []byte{}, nil, nil
+			},
+		}
+
+		fexec := fakeexec.FakeExec{
+			CommandScript: []fakeexec.FakeCommandAction{
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				},
+			},
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		err := runner.CreateSets(c.sets, c.ignoreExistErr)
+		if c.expectedErrors != nil {
+			var setsErr *SetsError
+			if !errors.As(err, &setsErr) {
+				t.Errorf("[%s] expected sets error, got: %v", c.name, err)
+				continue
+			}
+
+			setnames := []string{}
+			for setname := range setsErr.Errors {
+				setnames = append(setnames, setname)
+			}
+			sort.Strings(setnames)
+
+			if !reflect.DeepEqual(setnames, c.expectedErrors) {
+				t.Errorf("[%s] expected errors of sets %v, got: %v", c.name,
+					c.expectedErrors, setsErr)
+			}
+		} else if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if c.failed {
+			var restoreErr *RestoreError
+			if !errors.As(err, &restoreErr) || restoreErr.Line != 2 {
+				t.Errorf("[%s] expected restore error of line 2, got: %v",
+					c.name, err)
+			}
+		}
+
+		if c.expectedArgs == nil {
+			if fexec.CommandCalls != 0 {
+				t.Errorf("[%s] expected 0 Command() calls, got: %d", c.name,
+					fexec.CommandCalls)
+			}
+
+			continue
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog[0], c.expectedArgs) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog[0])
+		}
+
+		if !c.failed && string(script) != c.expectedScript {
+			t.Errorf("[%s] expected script:\n%s\ngot:\n%s", c.name,
+				c.expectedScript, script)
+		}
+	}
+}
+
+func TestDestroySets(t *testing.T) {
+	cases := []struct {
+		name              string
+		setnames          []SetName
+		ignoreNotExistErr bool
+		listOutput        string
+		output            string
+		failed            bool
+		combinedOutputLog [][]string
+		expectedScript    string
+		expectedErrors    []string
+	}{
+		{
+			name:     "Destroy sets",
+			setnames: []SetName{"foo", "bar"},
+			combinedOutputLog: [][]string{
+				{"ipset", "restore"},
+			},
+			expectedScript: "destroy foo\ndestroy bar\n",
+		},
+		{
+			name:              "Destroy sets ignoring not exist",
+			setnames:          []SetName{"foo", "bar", "baz"},
+			ignoreNotExistErr: true,
+			listOutput:        "baz\nfoo\n",
+			combinedOutputLog: [][]string{
+				{"ipset", "list", "-n"},
+				{"ipset", "restore"},
+			},
+			expectedScript: "destroy foo\ndestroy baz\n",
+		},
+		{
+			name:              "Destroy missing sets ignoring not exist",
+			setnames:          []SetName{"foo"},
+			ignoreNotExistErr: true,
+			combinedOutputLog: [][]string{
+				{"ipset", "list", "-n"},
+			},
+		},
+		{
+			name:     "Restore failure",
+			setnames: []SetName{"foo", "bar", "baz"},
+			output: "ipset v7.6: Error in line 2: Set cannot be destroyed: " +
+				"it is in use by a kernel component",
+			failed: true,
+			combinedOutputLog: [][]string{
+				{"ipset", "restore"},
+			},
+			expectedScript: "destroy foo\ndestroy bar\ndestroy baz\n",
+			expectedErrors: []string{"bar", "baz"},
+		},
+		{
+			name:           "Invalid set names",
+			setnames:       []SetName{"foo", "", "-bar"},
+			expectedErrors: []string{"", "-bar"},
+		},
+		{
+			name: "No sets",
+		},
+	}
+
+	for _, c := range cases {
+		var script []byte
+
+		fcmd := fakeexec.FakeCmd{}
+		fcmd.CombinedOutputScript = []fakeexec.FakeAction{}
+		if c.ignoreNotExistErr {
+			fcmd.CombinedOutputScript = append(fcmd.CombinedOutputScript,
+				func() ([]byte, []byte, error) {
+					return []byte(c.listOutput), nil, nil
+				})
+		}
+		fcmd.CombinedOutputScript = append(fcmd.CombinedOutputScript,
+			func() ([]byte, []byte, error) {
+				script, _ = ioutil.ReadAll(fcmd.Stdin)
+
+				if c.failed {
+					return []byte(c.output), nil, &fakeexec.FakeExitError{Status: 1}
+				}
+
+				return []byte{}, nil, nil
+			})
+
+		fexec := fakeexec.FakeExec{}
+		for range fcmd.CombinedOutputScript {
+			fexec.CommandScript = append(fexec.CommandScript,
+				func(cmd string, args ...string) exec.Cmd {
+					return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+				})
+		}
+
+		runner := newInternal(&fexec, testIPSetLockfilePath)
+
+		err := runner.DestroySets(c.setnames, c.ignoreNotExistErr)
+		if c.expectedErrors != nil {
+			var setsErr *SetsError
+			if !errors.As(err, &setsErr) {
+				t.Errorf("[%s] expected sets error, got: %v", c.name, err)
+				continue
+			}
+
+			setnames := []string{}
+			for setname := range setsErr.Errors {
+				setnames = append(setnames, setname)
+			}
+			sort.Strings(setnames)
+
+			if !reflect.DeepEqual(setnames, c.expectedErrors) {
+				t.Errorf("[%s] expected errors of sets %v, got: %v", c.name,
+					c.expectedErrors, setsErr)
+			}
+		} else if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !reflect.DeepEqual(fcmd.CombinedOutputLog, c.combinedOutputLog) {
+			t.Errorf("[%s] wrong CombinedOutput() log, got: %s", c.name,
+				fcmd.CombinedOutputLog)
+		}
+
+		if string(script) != c.expectedScript {
+			t.Errorf("[%s] expected script:\n%s\ngot:\n%s", c.name,
+				c.expectedScript, script)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseSave parses the `ipset save` text format into the set specifications
+// and their entries keyed by set name, the sets are returned in the order
+// they are created.
+func ParseSave(data []byte) ([]*IPSet, map[string][]IPSetEntry, error) {
+	sets := []*IPSet{}
+	entries := map[string][]IPSetEntry{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineno := 0
+
+	for scanner.Scan() {
+		lineno++
+
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields, err := splitSaveLine(line)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error parsing line %d, error: %v",
+				lineno, err)
+		}
+
+		if len(fields) < 3 {
+			return nil, nil, fmt.Errorf("error parsing line %d, error: "+
+				"too few arguments", lineno)
+		}
+
+		switch fields[0] {
+		case "create":
+			set, err := parseSaveCreate(fields[1:])
+			if err != nil {
+				return nil, nil, fmt.Errorf("error parsing line %d, error: %v",
+					lineno, err)
+			}
+
+			if _, exists := entries[set.Name]; exists {
+				return nil, nil, fmt.Errorf("error parsing line %d, error: "+
+					"set %s already created", lineno, set.Name)
+			}
+
+			sets = append(sets, set)
+			entries[set.Name] = []IPSetEntry{}
+		case "add":
+			setname := fields[1]
+			if _, exists := entries[setname]; !exists {
+				return nil, nil, fmt.Errorf("error parsing line %d, error: "+
+					"set %s is not created", lineno, setname)
+			}
+
+			entry, err := parseSaveAdd(fields[2:])
+			if err != nil {
+				return nil, nil, fmt.Errorf("error parsing line %d, error: %v",
+					lineno, err)
+			}
+
+			entries[setname] = append(entries[setname], *entry)
+		default:
+			return nil, nil, fmt.Errorf("error parsing line %d, error: "+
+				"unsupported command %s", lineno, fields[0])
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading save data, error: %v", err)
+	}
+
+	return sets, entries, nil
+}
+
+// saveSkippedOptions are the known save options which the set specification
+// and the entry do not hold, the options of the value are true, e.g. the
+// random hash seed initval of ipset v7.11 or later.
+var saveSkippedOptions = map[string]bool{
+	"forceadd": false,
+	"initval":  true,
+	"skbprio":  true,
+	"skbqueue": true,
+}
+
+// parseSaveCreate parses the create line arguments following the command,
+// the size of the list:set is the maximum elements.
+func parseSaveCreate(fields []string) (*IPSet, error) {
+	set := &IPSet{
+		Name:    fields[0],
+		SetType: Type(fields[1]),
+	}
+
+	for idx := 2; idx < len(fields); idx++ {
+		option := fields[idx]
+
+		switch option {
+		case "counters":
+			set.WithCounters = true
+			continue
+		case "comment":
+			set.WithComment = true
+			continue
+		case "skbinfo":
+			set.WithSkbinfo = true
+			continue
+		}
+
+		hasValue, skipped := saveSkippedOptions[option]
+		if skipped && !hasValue {
+			continue
+		}
+
+		if idx+1 >= len(fields) {
+			return nil, fmt.Errorf("missing value of option %s", option)
+		}
+		idx++
+		value := fields[idx]
+
+		var err error
+		switch option {
+		case "family":
+			set.HashFamily = value
+		case "hashsize":
+			set.HashSize, err = strconv.Atoi(value)
+		case "maxelem":
+			set.MaxElement, err = strconv.Atoi(value)
+		case "bucketsize":
+			set.BucketSize, err = strconv.Atoi(value)
+		case "netmask":
+			set.Netmask, err = strconv.Atoi(value)
+		case "range":
+			set.Range = value
+		case "timeout":
+			set.Timeout, err = strconv.Atoi(value)
+		case "size":
+			set.MaxElement, err = strconv.Atoi(value)
+		default:
+			if !skipped {
+				return nil, fmt.Errorf("unsupported create option %s",
+					option)
+			}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %s", option, value)
+		}
+	}
+
+	return set, nil
+}
+
+// parseSaveAdd parses the add line arguments following the set name.
+func parseSaveAdd(fields []string) (*IPSetEntry, error) {
+	entry := &IPSetEntry{
+		Element: fields[0],
+	}
+
+	for idx := 1; idx < len(fields); idx++ {
+		option := fields[idx]
+
+		if option == "nomatch" {
+			entry.NoMatch = true
+			continue
+		}
+
+		hasValue, skipped := saveSkippedOptions[option]
+		if skipped && !hasValue {
+			continue
+		}
+
+		if idx+1 >= len(fields) {
+			return nil, fmt.Errorf("missing value of option %s", option)
+		}
+		idx++
+		value := fields[idx]
+
+		var err error
+		switch option {
+		case "comment":
+			entry.Comment = value
+		case "skbmark":
+			entry.SkbMark = value
+			_, _, err = ParseSkbmark(value)
+		case "timeout":
+			entry.Timeout, err = strconv.Atoi(value)
+		case "packets":
+			entry.Packets, err = strconv.ParseUint(value, 10, 64)
+		case "bytes":
+			entry.Bytes, err = strconv.ParseUint(value, 10, 64)
+		default:
+			if !skipped {
+				return nil, fmt.Errorf("unsupported add option %s", option)
+			}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value %s", option, value)
+		}
+	}
+
+	return entry, nil
+}
+
+// splitSaveLine splits a save line into fields, the double-quoted string is
+// kept as a single field without the quotes.
+func splitSaveLine(line string) ([]string, error) {
+	fields := []string{}
+	var field strings.Builder
+	inField, inQuote := false, false
+
+	for _, r := range line {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			inField = true
+		case (r == ' ' || r == '\t') && !inQuote:
+			if inField {
+				fields = append(fields, field.String())
+				field.Reset()
+				inField = false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+
+	if inQuote {
+		return nil, fmt.Errorf("unterminated quoted string")
+	}
+
+	if inField {
+		fields = append(fields, field.String())
+	}
+
+	return fields, nil
+}
@@ -0,0 +1,240 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"k8s.io/utils/exec"
+	fakeexec "k8s.io/utils/exec/testing"
+)
+
+func TestParseSave(t *testing.T) {
+	cases := []struct {
+		name            string
+		data            []byte
+		expectedSets    []*IPSet
+		expectedEntries map[string][]IPSetEntry
+		expectedError   bool
+	}{
+		{
+			name: "hash:ip and hash:net sets",
+			data: []byte(`
+create foo hash:ip family inet hashsize 1024 maxelem 65536 comment
+add foo 172.18.3.2 comment "ContainerID: deadbeaf"
+add foo 172.18.3.3
+create bar hash:net family inet6 hashsize 256 maxelem 128 bucketsize 4 timeout 300 counters
+add bar 2001:db8::/64 timeout 120 packets 10 bytes 840
+`),
+			expectedSets: []*IPSet{
+				{
+					Name:        "foo",
+					SetType:     HashIP,
+					HashFamily:  ProtocolFamilyIPv4,
+					HashSize:    1024,
+					MaxElement:  65536,
+					WithComment: true,
+				},
+				{
+					Name:         "bar",
+					SetType:      HashNet,
+					HashFamily:   ProtocolFamilyIPv6,
+					HashSize:     256,
+					MaxElement:   128,
+					BucketSize:   4,
+					Timeout:      300,
+					WithCounters: true,
+				},
+			},
+			expectedEntries: map[string][]IPSetEntry{
+				"foo": {
+					{Element: "172.18.3.2", Comment: "ContainerID: deadbeaf"},
+					{Element: "172.18.3.3"},
+				},
+				"bar": {
+					{Element: "2001:db8::/64", Timeout: 120, Packets: 10,
+						Bytes: 840},
+				},
+			},
+		},
+		{
+			name: "ipset v7.11 save output",
+			data: []byte(`create foo hash:ip family inet hashsize 1024 maxelem 65536 bucketsize 12 initval 0x5fd8b0f6
+add foo 172.18.3.2
+create bar hash:net family inet hashsize 1024 maxelem 65536 bucketsize 12 initval 0x1c2a4f03 forceadd
+add bar 10.0.0.0/8
+add bar 10.1.0.0/16 nomatch
+create baz list:set size 8
+add baz foo
+add baz bar
+`),
+			expectedSets: []*IPSet{
+				{
+					Name:       "foo",
+					SetType:    HashIP,
+					HashFamily: ProtocolFamilyIPv4,
+					HashSize:   1024,
+					MaxElement: 65536,
+					BucketSize: 12,
+				},
+				{
+					Name:       "bar",
+					SetType:    HashNet,
+					HashFamily: ProtocolFamilyIPv4,
+					HashSize:   1024,
+					MaxElement: 65536,
+					BucketSize: 12,
+				},
+				{
+					Name:       "baz",
+					SetType:    ListSet,
+					MaxElement: 8,
+				},
+			},
+			expectedEntries: map[string][]IPSetEntry{
+				"foo": {
+					{Element: "172.18.3.2"},
+				},
+				"bar": {
+					{Element: "10.0.0.0/8"},
+					{Element: "10.1.0.0/16", NoMatch: true},
+				},
+				"baz": {
+					{Element: "foo"},
+					{Element: "bar"},
+				},
+			},
+		},
+		{
+			name: "ipset v6 save output with skbinfo",
+			data: []byte(`create foo hash:ip family inet hashsize 1024 maxelem 65536 skbinfo
+add foo 172.18.3.2 skbmark 0x1/0xffffffff skbprio 1:10 skbqueue 2
+`),
+			expectedSets: []*IPSet{
+				{
+					Name:        "foo",
+					SetType:     HashIP,
+					HashFamily:  ProtocolFamilyIPv4,
+					HashSize:    1024,
+					MaxElement:  65536,
+					WithSkbinfo: true,
+				},
+			},
+			expectedEntries: map[string][]IPSetEntry{
+				"foo": {
+					{Element: "172.18.3.2", SkbMark: "0x1/0xffffffff"},
+				},
+			},
+		},
+		{
+			name:          "missing value of initval",
+			data:          []byte(`create foo hash:ip initval`),
+			expectedError: true,
+		},
+		{
+			name:            "empty data",
+			data:            []byte(``),
+			expectedSets:    []*IPSet{},
+			expectedEntries: map[string][]IPSetEntry{},
+		},
+		{
+			name:          "add to set that is not created",
+			data:          []byte(`add foo 172.18.3.2`),
+			expectedError: true,
+		},
+		{
+			name:          "unsupported create option",
+			data:          []byte(`create foo hash:ip markmask 0xffff`),
+			expectedError: true,
+		},
+		{
+			name:          "invalid hash size value",
+			data:          []byte(`create foo hash:ip hashsize big`),
+			expectedError: true,
+		},
+		{
+			name: "unterminated comment",
+			data: []byte(`
+create foo hash:ip comment
+add foo 172.18.3.2 comment "ContainerID: deadbeaf
+`),
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		sets, entries, err := ParseSave(c.data)
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if !reflect.DeepEqual(sets, c.expectedSets) {
+			t.Errorf("[%s] expected sets: %+v, got: %+v", c.name,
+				c.expectedSets, sets)
+		}
+
+		if !reflect.DeepEqual(entries, c.expectedEntries) {
+			t.Errorf("[%s] expected entries: %+v, got: %+v", c.name,
+				c.expectedEntries, entries)
+		}
+	}
+}
+
+func TestParseSaveCreateSets(t *testing.T) {
+	data := []byte(`
+create foo hash:ip family inet hashsize 1024 maxelem 65536
+create ports bitmap:port range 1024-65535
+add ports 8080
+create policy list:set size 8
+add policy foo
+`)
+
+	sets, _, err := ParseSave(data)
+	if err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+
+	var script []byte
+
+	fcmd := fakeexec.FakeCmd{}
+	fcmd.CombinedOutputScript = []fakeexec.FakeAction{
+		func() ([]byte, []byte, error) {
+			script, _ = ioutil.ReadAll(fcmd.Stdin)
+			return []byte{}, nil, nil
+		},
+	}
+
+	fexec := fakeexec.FakeExec{
+		CommandScript: []fakeexec.FakeCommandAction{
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			},
+		},
+	}
+
+	runner := newInternal(&fexec, testIPSetLockfilePath)
+
+	err = runner.CreateSets(sets, false)
+	if err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+
+	expected := "create foo hash:ip family inet hashsize 1024 maxelem 65536\n" +
+		"create ports bitmap:port range 1024-65535\n" +
+		"create policy list:set\n"
+	if string(script) != expected {
+		t.Errorf("expected script: %q, got: %q", expected, string(script))
+	}
+}
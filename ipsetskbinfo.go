@@ -0,0 +1,67 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultSkbmarkMask is the skbmark mask when it is omitted, e.g. 0x1.
+const DefaultSkbmarkMask uint32 = 0xffffffff
+
+// ParseSkbmark parses the skbmark string of the skbinfo set entry, e.g.
+// 0x1/0xffff, into the mark and the mask, the omitted mask is
+// DefaultSkbmarkMask. The values are hex with the 0x prefix or decimal.
+func ParseSkbmark(s string) (mark, mask uint32, err error) {
+	parts := strings.SplitN(s, "/", 2)
+
+	mark, err = parseSkbmarkValue(parts[0])
+	if err != nil {
+		return 0, 0, &EntryError{"skbmark", s, "invalid mark"}
+	}
+
+	mask = DefaultSkbmarkMask
+	if len(parts) == 2 {
+		mask, err = parseSkbmarkValue(parts[1])
+		if err != nil {
+			return 0, 0, &EntryError{"skbmark", s, "invalid mask"}
+		}
+	}
+
+	return mark, mask, nil
+}
+
+// parseSkbmarkValue parses the 32-bit mark or mask value.
+func parseSkbmarkValue(s string) (uint32, error) {
+	value, err := strconv.ParseUint(s, 0, 32)
+	return uint32(value), err
+}
+
+// FormatSkbmark returns the skbmark string of the mark and the mask, the
+// mask is omitted when it is DefaultSkbmarkMask the way ipset lists it.
+func FormatSkbmark(mark, mask uint32) string {
+	if mask == DefaultSkbmarkMask {
+		return fmt.Sprintf("0x%x", mark)
+	}
+
+	return fmt.Sprintf("0x%x/0x%x", mark, mask)
+}
+
+// SkbmarkValue returns the mark and the mask of the entry skbmark, the entry
+// without the skbmark is an error.
+func (entry *IPSetEntry) SkbmarkValue() (mark, mask uint32, err error) {
+	if len(entry.SkbMark) == 0 {
+		return 0, 0, &EntryError{"skbmark", entry.SkbMark, "not set"}
+	}
+
+	return ParseSkbmark(entry.SkbMark)
+}
+
+// SetSkbmark sets the entry skbmark of the mark and the mask.
+func (entry *IPSetEntry) SetSkbmark(mark, mask uint32) {
+	entry.SkbMark = FormatSkbmark(mark, mask)
+}
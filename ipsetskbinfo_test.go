@@ -0,0 +1,114 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSkbmark(t *testing.T) {
+	cases := []struct {
+		name          string
+		skbmark       string
+		mark          uint32
+		mask          uint32
+		formatted     string
+		expectedError bool
+	}{
+		{
+			name:      "Mark with mask",
+			skbmark:   "0x1/0xffff",
+			mark:      0x1,
+			mask:      0xffff,
+			formatted: "0x1/0xffff",
+		},
+		{
+			name:      "Mark without mask",
+			skbmark:   "0x10",
+			mark:      0x10,
+			mask:      DefaultSkbmarkMask,
+			formatted: "0x10",
+		},
+		{
+			name:      "Decimal mark",
+			skbmark:   "16/255",
+			mark:      16,
+			mask:      255,
+			formatted: "0x10/0xff",
+		},
+		{
+			name:          "Invalid mark",
+			skbmark:       "mark",
+			expectedError: true,
+		},
+		{
+			name:          "Invalid mask",
+			skbmark:       "0x1/",
+			expectedError: true,
+		},
+		{
+			name:          "Mark out of range",
+			skbmark:       "0x100000000",
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		entry := IPSetEntry{Element: "172.18.3.2", SkbMark: c.skbmark}
+
+		mark, mask, err := entry.SkbmarkValue()
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+
+			if entry.validate() == nil {
+				t.Errorf("[%s] expected invalid entry, got: nil", c.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if mark != c.mark || mask != c.mask {
+			t.Errorf("[%s] expected %#x/%#x, got: %#x/%#x", c.name, c.mark,
+				c.mask, mark, mask)
+		}
+
+		entry.SetSkbmark(mark, mask)
+		if entry.SkbMark != c.formatted {
+			t.Errorf("[%s] expected skbmark %s, got: %s", c.name, c.formatted,
+				entry.SkbMark)
+		}
+	}
+
+	_, _, err := (&IPSetEntry{Element: "172.18.3.2"}).SkbmarkValue()
+	if err == nil {
+		t.Errorf("expected failure of entry without skbmark, got: nil")
+	}
+}
+
+func TestSkbinfoArgs(t *testing.T) {
+	set := IPSetSpec(IPSetName("foo"), IPSetWithSkbinfo())
+
+	expected := []string{"create", "foo", "hash:ip", "family", "inet",
+		"hashsize", testDefaultHashSize, "maxelem", testDefaultMaxElement,
+		"skbinfo"}
+	if args := buildCreateArgs(set); !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected create args: %v, got: %v", expected, args)
+	}
+
+	entry := &IPSetEntry{Element: "172.18.3.2"}
+	entry.SetSkbmark(0x1, 0xffff)
+
+	expected = []string{"172.18.3.2", "skbmark", "0x1/0xffff"}
+	if args := buildEntryArgs(entry); !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected entry args: %v, got: %v", expected, args)
+	}
+}
@@ -4,8 +4,37 @@
 
 package ipset
 
+import "math"
+
 type IPSetSpecFunc func(*IPSet)
 
+const (
+	// DefaultHashSize is the IPSetSpec default hash size.
+	DefaultHashSize = 1024
+	// DefaultMaxElement is the IPSetSpec default maximum elements.
+	DefaultMaxElement = 65536
+	// DefaultFamily is the IPSetSpec default hash family.
+	DefaultFamily = ProtocolFamilyIPv4
+)
+
+const (
+	// hashSizeUnset and hashSizeAuto are the IPSetSpec hash size while the
+	// setters are applied, the hash size which is not set by IPSetHashSize
+	// is then the default one or derived from the maximum elements.
+	hashSizeUnset = math.MinInt32
+	hashSizeAuto  = math.MinInt32 + 1
+)
+
+// GetDefaultHashSize returns the IPSetSpec default hash size.
+func GetDefaultHashSize() int {
+	return DefaultHashSize
+}
+
+// GetDefaultMaxElement returns the IPSetSpec default maximum elements.
+func GetDefaultMaxElement() int {
+	return DefaultMaxElement
+}
+
 // IPSetName set the name.
 func IPSetName(name string) IPSetSpecFunc {
 	return func(set *IPSet) {
@@ -20,20 +49,32 @@ func IPSetType(setType Type) IPSetSpecFunc {
 	}
 }
 
-// IPSetHashFamily set the hash family.
+// IPSetHashFamily set the hash family, it takes precedence over the runner
+// default family, see WithDefaultFamily.
 func IPSetHashFamily(family string) IPSetSpecFunc {
 	return func(set *IPSet) {
 		set.HashFamily = family
 	}
 }
 
-// IPSetHashSize set the hash size.
+// IPSetHashSize set the hash size, it takes precedence over
+// IPSetAutoHashSize.
 func IPSetHashSize(size int) IPSetSpecFunc {
 	return func(set *IPSet) {
 		set.HashSize = size
 	}
 }
 
+// IPSetAutoHashSize derive the hash size from the maximum elements, unless
+// the hash size is set by IPSetHashSize.
+func IPSetAutoHashSize() IPSetSpecFunc {
+	return func(set *IPSet) {
+		if set.HashSize == hashSizeUnset {
+			set.HashSize = hashSizeAuto
+		}
+	}
+}
+
 // IPSetMaxElement set the maximum elements that set could hold.
 func IPSetMaxElement(max int) IPSetSpecFunc {
 	return func(set *IPSet) {
@@ -41,27 +82,124 @@ func IPSetMaxElement(max int) IPSetSpecFunc {
 	}
 }
 
-// IPSetWithComment enable the set creation with comment option.
+// IPSetBucketSize set the hash bucket size, it is only emitted when > 0.
+// The bucketsize option requires ipset v7.11 or later, older versions
+// reject the unknown argument.
+func IPSetBucketSize(size int) IPSetSpecFunc {
+	return func(set *IPSet) {
+		set.BucketSize = size
+	}
+}
+
+// IPSetNetmask set the netmask prefix length of the hash:ip set, the added
+// addresses are stored as their network of the prefix length, it is only
+// emitted when > 0.
+func IPSetNetmask(prefixLength int) IPSetSpecFunc {
+	return func(set *IPSet) {
+		set.Netmask = prefixLength
+	}
+}
+
+// IPSetRange set the range of the bitmap set, e.g. 1024-65535 of the
+// bitmap:port, see PortRange. The hash sets, e.g. hash:ip, have no range
+// option, use IPSetMaxElement with IPSetAutoHashSize to pre-size them for a
+// known address range.
+func IPSetRange(r string) IPSetSpecFunc {
+	return func(set *IPSet) {
+		set.Range = r
+	}
+}
+
+// IPSetTimeout set the default timeout value in seconds for the set entries.
+func IPSetTimeout(timeout int) IPSetSpecFunc {
+	return func(set *IPSet) {
+		set.Timeout = timeout
+	}
+}
+
+// IPSetWithCounters enable the set creation with counters option.
+func IPSetWithCounters() IPSetSpecFunc {
+	return func(set *IPSet) {
+		set.WithCounters = true
+	}
+}
+
+// IPSetWithComment enable the set creation with comment option, so each
+// entry could hold its comment, see IPSetEntry.Comment. The ipset create has
+// no description of the set itself, the comment option takes no value.
 func IPSetWithComment() IPSetSpecFunc {
 	return func(set *IPSet) {
 		set.WithComment = true
 	}
 }
 
+// IPSetWithoutComment disable the set creation with comment option, it is the
+// default, useful to override the option of the re-applied specification.
+func IPSetWithoutComment() IPSetSpecFunc {
+	return func(set *IPSet) {
+		set.WithComment = false
+	}
+}
+
+// IPSetWithSkbinfo enable the set creation with skbinfo option, the entries
+// could then hold the skbmark, see IPSetEntry.SetSkbmark.
+func IPSetWithSkbinfo() IPSetSpecFunc {
+	return func(set *IPSet) {
+		set.WithSkbinfo = true
+	}
+}
+
 // IPSetSpec provides the interface to setup the set specification with
 // default values
 func IPSetSpec(setters ...IPSetSpecFunc) *IPSet {
 	set := &IPSet{
-		SetType:     HashIP,
-		HashFamily:  ProtocolFamilyIPv4,
-		HashSize:    1024,
-		MaxElement:  65536,
-		WithComment: false,
+		SetType:      HashIP,
+		HashFamily:   DefaultFamily,
+		HashSize:     hashSizeUnset,
+		MaxElement:   DefaultMaxElement,
+		WithCounters: false,
+		WithComment:  false,
 	}
 
 	for _, setter := range setters {
 		setter(set)
 	}
 
+	switch set.HashSize {
+	case hashSizeUnset:
+		set.HashSize = DefaultHashSize
+	case hashSizeAuto:
+		set.HashSize = autoHashSize(set.MaxElement)
+	}
+
 	return set
 }
+
+const (
+	minAutoHashSize = 64
+	maxAutoHashSize = 1 << 20
+)
+
+// autoHashSize returns the next power of two of the half of the maximum
+// elements, clamped to 64-1048576.
+func autoHashSize(maxElement int) int {
+	size := minAutoHashSize
+	for size < maxElement/2 && size < maxAutoHashSize {
+		size <<= 1
+	}
+
+	return size
+}
+
+// IPSetSpecE is the same as IPSetSpec, the set specification is validated
+// and the error is returned as is from Validate.
+func IPSetSpecE(setters ...IPSetSpecFunc) (*IPSet, error) {
+	set := IPSetSpec(setters...)
+
+	err := set.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return set, nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// IPSetVersion represents the ipset release version and its kernel protocol
+// version, the Protocol is 0 when unknown.
+type IPSetVersion struct {
+	Major    int
+	Minor    int
+	Protocol int
+}
+
+var (
+	versionRelease  = regexp.MustCompile(`^(?:ipset )?v?(\d+)\.(\d+)`)
+	versionProtocol = regexp.MustCompile(`protocol version:? (\d+)`)
+)
+
+// ParseVersion parses the ipset version string, e.g. "v7.6" or the output of
+// the ipset version command "ipset v7.6, protocol version: 7".
+func ParseVersion(s string) (IPSetVersion, error) {
+	match := versionRelease.FindStringSubmatch(s)
+	if match == nil {
+		return IPSetVersion{}, fmt.Errorf("error parsing version %q", s)
+	}
+
+	var version IPSetVersion
+	version.Major, _ = strconv.Atoi(match[1])
+	version.Minor, _ = strconv.Atoi(match[2])
+
+	if match = versionProtocol.FindStringSubmatch(s); match != nil {
+		version.Protocol, _ = strconv.Atoi(match[1])
+	}
+
+	return version, nil
+}
+
+func (v IPSetVersion) String() string {
+	if v.Protocol > 0 {
+		return fmt.Sprintf("v%d.%d, protocol version: %d", v.Major, v.Minor,
+			v.Protocol)
+	}
+
+	return fmt.Sprintf("v%d.%d", v.Major, v.Minor)
+}
+
+// AtLeast checks if the version is the given major.minor or later, e.g.
+// AtLeast(6, 32) for the skbinfo support.
+func (v IPSetVersion) AtLeast(major, minor int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+
+	return v.Minor >= minor
+}
+
+// less compares the release versions, then the protocol versions.
+func (v IPSetVersion) less(other IPSetVersion) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+
+	if v.Minor != other.Minor {
+		return v.Minor < other.Minor
+	}
+
+	return v.Protocol < other.Protocol
+}
+
+// IPSetVersions implements sort.Interface to sort the versions in ascending
+// order.
+type IPSetVersions []IPSetVersion
+
+func (v IPSetVersions) Len() int           { return len(v) }
+func (v IPSetVersions) Less(i, j int) bool { return v[i].less(v[j]) }
+func (v IPSetVersions) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+
+// GetVersion returns the version of the installed ipset.
+func (runner *runner) GetVersion() (IPSetVersion, error) {
+	out, err := runner.run([]string{"version"})
+	if err != nil {
+		return IPSetVersion{}, fmt.Errorf("error getting version, error: %v",
+			err)
+	}
+
+	return ParseVersion(string(out))
+}
+
+// cachedVersion returns the version of the installed ipset, it is got once
+// and cached by the runner.
+func (runner *runner) cachedVersion() (IPSetVersion, error) {
+	runner.mu.RLock()
+	version := runner.version
+	runner.mu.RUnlock()
+
+	if version != nil {
+		return *version, nil
+	}
+
+	got, err := runner.GetVersion()
+	if err != nil {
+		return IPSetVersion{}, err
+	}
+
+	runner.mu.Lock()
+	runner.version = &got
+	runner.mu.Unlock()
+
+	return got, nil
+}
+
+// sortedListSupported checks if the installed ipset supports the -sorted list
+// flag, the unknown version is assumed not to support it.
+func (runner *runner) sortedListSupported() bool {
+	version, err := runner.cachedVersion()
+	return err == nil && version.AtLeast(6, 30)
+}
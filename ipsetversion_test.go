@@ -0,0 +1,150 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"k8s.io/utils/exec"
+	fakeexec "k8s.io/utils/exec/testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	cases := []struct {
+		name          string
+		version       string
+		expected      IPSetVersion
+		expectedError bool
+	}{
+		{
+			name:     "v6.32",
+			version:  "v6.32",
+			expected: IPSetVersion{Major: 6, Minor: 32},
+		},
+		{
+			name:     "v7.6 version command output",
+			version:  "ipset v7.6, protocol version: 7\n",
+			expected: IPSetVersion{Major: 7, Minor: 6, Protocol: 7},
+		},
+		{
+			name:     "v7.14 without v prefix",
+			version:  "7.14",
+			expected: IPSetVersion{Major: 7, Minor: 14},
+		},
+		{
+			name:          "Garbage",
+			version:       "ipset: command not found",
+			expectedError: true,
+		},
+	}
+
+	for _, c := range cases {
+		version, err := ParseVersion(c.version)
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if version != c.expected {
+			t.Errorf("[%s] expected version: %+v, got: %+v", c.name,
+				c.expected, version)
+		}
+
+		parsed, err := ParseVersion(version.String())
+		if err != nil || parsed != version {
+			t.Errorf("[%s] expected round trip of %s, got: %+v, %v", c.name,
+				version, parsed, err)
+		}
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	cases := []struct {
+		version  IPSetVersion
+		major    int
+		minor    int
+		expected bool
+	}{
+		{IPSetVersion{Major: 6, Minor: 32}, 6, 32, true},
+		{IPSetVersion{Major: 6, Minor: 30}, 6, 32, false},
+		{IPSetVersion{Major: 7, Minor: 6}, 6, 32, true},
+		{IPSetVersion{Major: 7, Minor: 6}, 7, 11, false},
+		{IPSetVersion{Major: 7, Minor: 14}, 7, 11, true},
+		{IPSetVersion{Major: 5, Minor: 40}, 6, 0, false},
+	}
+
+	for _, c := range cases {
+		if c.version.AtLeast(c.major, c.minor) != c.expected {
+			t.Errorf("expected %s at least %d.%d: %v", c.version, c.major,
+				c.minor, c.expected)
+		}
+	}
+}
+
+func TestSortVersions(t *testing.T) {
+	versions := IPSetVersions{
+		{Major: 7, Minor: 14},
+		{Major: 6, Minor: 32},
+		{Major: 7, Minor: 6, Protocol: 7},
+		{Major: 7, Minor: 6, Protocol: 6},
+	}
+
+	sort.Sort(versions)
+
+	expected := IPSetVersions{
+		{Major: 6, Minor: 32},
+		{Major: 7, Minor: 6, Protocol: 6},
+		{Major: 7, Minor: 6, Protocol: 7},
+		{Major: 7, Minor: 14},
+	}
+	if !reflect.DeepEqual(versions, expected) {
+		t.Errorf("expected versions: %v, got: %v", expected, versions)
+	}
+}
+
+func TestGetVersion(t *testing.T) {
+	fcmd := fakeexec.FakeCmd{
+		CombinedOutputScript: []fakeexec.FakeAction{
+			// Success
+			func() ([]byte, []byte, error) {
+				return []byte("ipset v7.6, protocol version: 7\n"), nil, nil
+			},
+		},
+	}
+
+	fexec := fakeexec.FakeExec{
+		CommandScript: []fakeexec.FakeCommandAction{
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			},
+		},
+	}
+
+	runner := newInternal(&fexec, testIPSetLockfilePath)
+
+	version, err := runner.GetVersion()
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	if !reflect.DeepEqual(fcmd.CombinedOutputLog[0],
+		[]string{"ipset", "version"}) {
+		t.Errorf("wrong CombinedOutput() log, got: %s",
+			fcmd.CombinedOutputLog[0])
+	}
+
+	if !version.AtLeast(7, 6) || version.Protocol != 7 {
+		t.Errorf("expected v7.6 protocol 7, got: %s", version)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// WatchSet polls the entries of the specified set name every interval and
+// sends the snapshot to ch when its membership differs from the previous
+// one, the first snapshot is always sent. The entries are compared by their
+// elements, the changes of the entry metadata, e.g. the remaining timeout or
+// the counters, are not sent. The ch is closed when WatchSet returns, which
+// is the context error once the context is done, or the listing error.
+func WatchSet(ctx context.Context, runner Interface, setname SetName,
+	interval time.Duration, ch chan<- []IPSetEntry) error {
+	defer close(ch)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var previous map[string]bool
+
+	for {
+		entries, err := runner.ListEntries(setname)
+		if err != nil {
+			return fmt.Errorf("error watching set %s, error: %w", setname,
+				err)
+		}
+
+		current := elementSet(entries)
+		if previous == nil || !sameElements(previous, current) {
+			select {
+			case ch <- entries:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			previous = current
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// sameElements checks if the element sets are equal.
+func sameElements(a, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for element := range a {
+		if !b[element] {
+			return false
+		}
+	}
+
+	return true
+}
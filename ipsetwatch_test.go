@@ -0,0 +1,102 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	ipset "github.com/neutronth/go-ipset"
+	"github.com/neutronth/go-ipset/ipsetfake"
+)
+
+func TestWatchSet(t *testing.T) {
+	fake := ipsetfake.NewFakeRunner()
+
+	err := fake.CreateSet(ipset.IPSetSpec(ipset.IPSetName("foo")), false)
+	if err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ch := make(chan []ipset.IPSetEntry)
+	done := make(chan error)
+
+	go func() {
+		done <- ipset.WatchSet(ctx, fake, "foo", 10*time.Millisecond, ch)
+	}()
+
+	expectSnapshot := func(expected []ipset.IPSetEntry) {
+		select {
+		case entries := <-ch:
+			if !reflect.DeepEqual(entries, expected) {
+				t.Errorf("expected snapshot: %+v, got: %+v", expected, entries)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected snapshot: %+v, got: none", expected)
+		}
+	}
+
+	expectNoSnapshot := func() {
+		select {
+		case entries := <-ch:
+			t.Errorf("expected no snapshot, got: %+v", entries)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	expectSnapshot([]ipset.IPSetEntry{})
+	expectNoSnapshot()
+
+	err = fake.AddEntry(&ipset.IPSetEntry{Element: "172.18.3.2"}, "foo", false)
+	if err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+
+	expectSnapshot([]ipset.IPSetEntry{{Element: "172.18.3.2"}})
+	expectNoSnapshot()
+
+	err = fake.DelEntry("172.18.3.2", "foo")
+	if err != nil {
+		t.Fatalf("expected success, got: %v", err)
+	}
+
+	expectSnapshot([]ipset.IPSetEntry{})
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected cancelled, got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected WatchSet to return")
+	}
+
+	if _, ok := <-ch; ok {
+		t.Errorf("expected closed channel")
+	}
+}
+
+func TestWatchSetListError(t *testing.T) {
+	fake := ipsetfake.NewFakeRunner()
+	ch := make(chan []ipset.IPSetEntry)
+
+	err := ipset.WatchSet(context.Background(), fake, "foo",
+		10*time.Millisecond, ch)
+	if !errors.Is(err, ipset.ErrSetNotFound) {
+		t.Errorf("expected set not found, got: %v", err)
+	}
+
+	if _, ok := <-ch; ok {
+		t.Errorf("expected closed channel")
+	}
+}
@@ -0,0 +1,19 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+// Metrics is an injectable interface for recording the ipset command
+// operations. Implementations must be goroutine-safe.
+type Metrics interface {
+	// RecordOperation records the ipset command name, e.g. "create", "add",
+	// its elapsed time in milliseconds and its error if any.
+	RecordOperation(op string, durationMs float64, err error)
+}
+
+// NopMetrics is the default Metrics which records nothing.
+type NopMetrics struct{}
+
+// RecordOperation does nothing.
+func (NopMetrics) RecordOperation(op string, durationMs float64, err error) {}
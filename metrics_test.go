@@ -0,0 +1,117 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"testing"
+
+	"k8s.io/utils/exec"
+	fakeexec "k8s.io/utils/exec/testing"
+)
+
+type testOperation struct {
+	op         string
+	durationMs float64
+	err        error
+}
+
+type testMetrics struct {
+	operations []testOperation
+}
+
+func (m *testMetrics) RecordOperation(op string, durationMs float64,
+	err error) {
+	m.operations = append(m.operations, testOperation{op, durationMs, err})
+}
+
+func TestMetrics(t *testing.T) {
+	fcmd := fakeexec.FakeCmd{
+		CombinedOutputScript: []fakeexec.FakeAction{
+			// Success
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+			// Failure
+			func() ([]byte, []byte, error) {
+				return []byte("ipset v7.6: Element cannot be added to the set: it's already added"), nil, &fakeexec.FakeExitError{Status: 1}
+			},
+		},
+	}
+
+	fexec := fakeexec.FakeExec{
+		CommandScript: []fakeexec.FakeCommandAction{
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			},
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			},
+		},
+	}
+
+	metrics := &testMetrics{}
+	runner := newInternal(&fexec, testIPSetLockfilePath, WithMetrics(metrics))
+
+	err := runner.CreateSet(IPSetSpec(IPSetName("foo")), false)
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+
+	err = runner.AddEntry(&IPSetEntry{Element: "172.18.3.2"}, "foo", false)
+	if err == nil {
+		t.Errorf("expected failure, got: nil")
+	}
+
+	if len(metrics.operations) != 2 {
+		t.Fatalf("expected 2 recorded operations, got: %d",
+			len(metrics.operations))
+	}
+
+	expected := []struct {
+		op     string
+		failed bool
+	}{
+		{op: "create", failed: false},
+		{op: "add", failed: true},
+	}
+
+	for idx, e := range expected {
+		operation := metrics.operations[idx]
+
+		if operation.op != e.op {
+			t.Errorf("expected operation %s, got: %s", e.op, operation.op)
+		}
+
+		if operation.durationMs <= 0 {
+			t.Errorf("[%s] expected non-zero duration, got: %f", e.op,
+				operation.durationMs)
+		}
+
+		if (operation.err != nil) != e.failed {
+			t.Errorf("[%s] unexpected recorded error: %v", e.op, operation.err)
+		}
+	}
+}
+
+func TestMetricsNil(t *testing.T) {
+	fcmd := fakeexec.FakeCmd{
+		CombinedOutputScript: []fakeexec.FakeAction{
+			func() ([]byte, []byte, error) { return []byte{}, nil, nil },
+		},
+	}
+
+	fexec := fakeexec.FakeExec{
+		CommandScript: []fakeexec.FakeCommandAction{
+			func(cmd string, args ...string) exec.Cmd {
+				return fakeexec.InitFakeCmd(&fcmd, cmd, args...)
+			},
+		},
+	}
+
+	runner := newInternal(&fexec, testIPSetLockfilePath, WithMetrics(nil))
+
+	err := runner.CreateSet(IPSetSpec(IPSetName("foo")), false)
+	if err != nil {
+		t.Errorf("expected success, got: %v", err)
+	}
+}
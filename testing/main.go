@@ -13,16 +13,20 @@ import (
 )
 
 func main() {
-	var setname = "foo"
+	setname, err := ipset.NewSetName("foo")
+	if err != nil {
+		log.Fatalf("Invalid set name, error %v", err)
+	}
+
 	runner := ipset.New(utilexec.New())
 
 	set := ipset.IPSetSpec(
-		ipset.IPSetName(setname),
+		ipset.IPSetName(string(setname)),
 		ipset.IPSetType(ipset.HashIP),
 		ipset.IPSetWithComment(),
 	)
 
-	err := runner.CreateSet(set, true)
+	err = runner.CreateSet(set, true)
 	if err != nil {
 		log.Fatalf("Could not create set %v: error %v", set, err)
 	}
@@ -5,6 +5,13 @@
 
 package ipset
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
 // Type represents the ipset type
 type Type string
 
@@ -14,8 +21,96 @@ const (
 
 	// HashNet represents the `hash:net` type ipset.
 	HashNet Type = "hash:net"
+
+	// HashNetPort represents the `hash:net,port` type ipset.
+	HashNetPort Type = "hash:net,port"
+
+	// HashIPPort represents the `hash:ip,port` type ipset.
+	HashIPPort Type = "hash:ip,port"
+
+	// HashIPPortIP represents the `hash:ip,port,ip` type ipset.
+	HashIPPortIP Type = "hash:ip,port,ip"
+
+	// HashMAC represents the `hash:mac` type ipset.
+	HashMAC Type = "hash:mac"
+
+	// HashNetNet represents the `hash:net,net` type ipset.
+	HashNetNet Type = "hash:net,net"
+
+	// HashNetIface represents the `hash:net,iface` type ipset.
+	HashNetIface Type = "hash:net,iface"
+
+	// BitmapPort represents the `bitmap:port` type ipset.
+	BitmapPort Type = "bitmap:port"
+
+	// ListSet represents the `list:set` type ipset.
+	ListSet Type = "list:set"
 )
 
+// ParseType returns the type of a given string, the unsupported type, see
+// ValidIPSetTypes, is an error.
+func ParseType(s string) (Type, error) {
+	t := Type(s)
+	if !t.IsValid() {
+		return "", fmt.Errorf("invalid Set Type %q", s)
+	}
+
+	return t, nil
+}
+
+func (t Type) String() string {
+	return string(t)
+}
+
+// IsValid checks if a given type is one of ValidIPSetTypes.
+func (t Type) IsValid() bool {
+	for _, valid := range ValidIPSetTypes {
+		if t == valid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isHash checks if a given type is one of the hash types.
+func (t Type) isHash() bool {
+	return strings.HasPrefix(string(t), "hash:")
+}
+
+// isBitmap checks if a given type is one of the bitmap types.
+func (t Type) isBitmap() bool {
+	return strings.HasPrefix(string(t), "bitmap:")
+}
+
+// hasRange checks if a given type accepts the range option, ipset supports it
+// for the bitmap types only, the hash types are sized by the maximum elements
+// and the hash size instead.
+func (t Type) hasRange() bool {
+	return t.isBitmap()
+}
+
+// hasFamily checks if a given type accepts the family option.
+func (t Type) hasFamily() bool {
+	return t != HashMAC
+}
+
+// allowsFamily checks if a given family could be used with the type, the
+// bitmap types are IPv4 only.
+func (t Type) allowsFamily(family string) bool {
+	if t.isBitmap() {
+		return family == ProtocolFamilyIPv4
+	}
+
+	return true
+}
+
+// familyOptional checks if a given type could be created without the family
+// option, the kernel then defaults to inet.
+func (t Type) familyOptional() bool {
+	return t == HashNet
+}
+
 const (
 	// ProtocolFamilyIPV4 represents IPv4 protocol.
 	ProtocolFamilyIPv4 = "inet"
@@ -23,8 +118,112 @@ const (
 	ProtocolFamilyIPv6 = "inet6"
 )
 
+// MaxSetNameLength defines the maximum length of the set name.
+const MaxSetNameLength = 31
+
+// ErrSameSetName is returned when the set is renamed to its own name.
+var ErrSameSetName = errors.New("old and new set names are the same")
+
+// ErrSetInUse is returned when the set is referenced by a kernel component,
+// e.g. an iptables rule or a list:set, and cannot be destroyed.
+var ErrSetInUse = errors.New("set is in use by a kernel component")
+
+// ErrSetNotFound is returned when the set does not exist.
+var ErrSetNotFound = errors.New("set does not exist")
+
+// ErrEmptySetName is returned when the set name argument is empty, it is
+// checked before any ipset command.
+var ErrEmptySetName = errors.New("setname must not be empty")
+
+// ErrEntryNotFound is returned when the entry is not in the set.
+var ErrEntryNotFound = errors.New("entry is not in set")
+
+// ErrCommentUnsupported is returned when the set is created with the comment
+// option which is not supported by the ipset or the kernel, the comment
+// extension requires ipset v6.22 or later.
+var ErrCommentUnsupported = errors.New("comment extension is not supported")
+
+// ErrXMLUnsupported is returned when the ipset is older than v6.0 which does
+// not support the XML output that the list methods rely on.
+var ErrXMLUnsupported = errors.New("ipset is too old, v6.0 or later is " +
+	"required for the XML output")
+
+// SetName represents the ipset set name.
+type SetName string
+
+// NewSetName returns the set name of a given string, the invalid name, see
+// ValidateSetName, is an error.
+func NewSetName(s string) (SetName, error) {
+	err := ValidateSetName(s)
+	if err != nil {
+		return "", err
+	}
+
+	return SetName(s), nil
+}
+
+// Validate checks if the set name is valid, see ValidateSetName.
+func (name SetName) Validate() error {
+	return ValidateSetName(string(name))
+}
+
+func (name SetName) String() string {
+	return string(name)
+}
+
+// ValidateSetName checks if a given set name is valid, it should be 1-31
+// characters without whitespaces or control characters and should not start
+// with "-" which is taken as an option.
+func ValidateSetName(name string) error {
+	if len(name) == 0 || len(name) > MaxSetNameLength {
+		return fmt.Errorf("invalid set name %q, should be 1-%d characters",
+			name, MaxSetNameLength)
+	}
+
+	if strings.HasPrefix(name, "-") {
+		return fmt.Errorf("invalid set name %q, should not start with -", name)
+	}
+
+	for _, r := range name {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return fmt.Errorf("invalid set name %q, should not contain "+
+				"whitespaces or control characters", name)
+		}
+	}
+
+	return nil
+}
+
 // ValidIPSetTypes defines the supported ip set type.
 var ValidIPSetTypes = []Type{
 	HashIP,
 	HashNet,
+	HashNetPort,
+	HashIPPort,
+	HashIPPortIP,
+	HashMAC,
+	HashNetNet,
+	HashNetIface,
+	BitmapPort,
+	ListSet,
+}
+
+// ValidateIPSetType checks if a given type is supported, see ValidIPSetTypes.
+func ValidateIPSetType(t Type) bool {
+	return t.IsValid()
+}
+
+// ValidateHashFamily checks if a given hash family is supported in ipset.
+func ValidateHashFamily(family string) bool {
+	return family == ProtocolFamilyIPv4 || family == ProtocolFamilyIPv6
+}
+
+// IsValidIPSetType is the same as ValidateIPSetType.
+func IsValidIPSetType(t Type) bool {
+	return ValidateIPSetType(t)
+}
+
+// IsValidHashFamily is the same as ValidateHashFamily.
+func IsValidHashFamily(family string) bool {
+	return ValidateHashFamily(family)
 }
@@ -0,0 +1,141 @@
+// Copyright 2020 Neutron Soutmun <neutron@neutron.in.th>
+//
+// SPDX-License-Identifier: Apache-2.0
+
+package ipset
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseType(t *testing.T) {
+	for _, valid := range ValidIPSetTypes {
+		parsed, err := ParseType(valid.String())
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", valid, err)
+		}
+
+		if parsed != valid || !parsed.IsValid() {
+			t.Errorf("[%s] expected valid type, got: %s", valid, parsed)
+		}
+	}
+
+	for _, invalid := range []string{"", "hash", "hash:foo", "HASH:IP",
+		"hash:ip "} {
+		_, err := ParseType(invalid)
+		if err == nil {
+			t.Errorf("[%q] expected failure, got: nil", invalid)
+		}
+
+		if Type(invalid).IsValid() {
+			t.Errorf("[%q] expected invalid type", invalid)
+		}
+	}
+}
+
+func TestValidateIPSetType(t *testing.T) {
+	for _, valid := range ValidIPSetTypes {
+		if !ValidateIPSetType(valid) || !IsValidIPSetType(valid) {
+			t.Errorf("[%s] expected valid type", valid)
+		}
+	}
+
+	for _, invalid := range []Type{"", "hash:foo", "bitmap:", "list:set,"} {
+		if ValidateIPSetType(invalid) || IsValidIPSetType(invalid) {
+			t.Errorf("[%q] expected invalid type", invalid)
+		}
+	}
+}
+
+func TestValidateHashFamily(t *testing.T) {
+	for _, valid := range []string{ProtocolFamilyIPv4, ProtocolFamilyIPv6} {
+		if !ValidateHashFamily(valid) || !IsValidHashFamily(valid) {
+			t.Errorf("[%s] expected valid family", valid)
+		}
+	}
+
+	for _, invalid := range []string{"", "inet4", "ipv6", "INET"} {
+		if ValidateHashFamily(invalid) || IsValidHashFamily(invalid) {
+			t.Errorf("[%q] expected invalid family", invalid)
+		}
+	}
+}
+
+func TestTypeAllowsFamily(t *testing.T) {
+	cases := []struct {
+		setType  Type
+		family   string
+		expected bool
+	}{
+		{HashIP, ProtocolFamilyIPv4, true},
+		{HashIP, ProtocolFamilyIPv6, true},
+		{HashNetIface, ProtocolFamilyIPv6, true},
+		{"bitmap:ip", ProtocolFamilyIPv4, true},
+		{"bitmap:ip", ProtocolFamilyIPv6, false},
+		{"bitmap:ip,mac", ProtocolFamilyIPv6, false},
+		{"bitmap:port", ProtocolFamilyIPv6, false},
+	}
+
+	for _, c := range cases {
+		if c.setType.allowsFamily(c.family) != c.expected {
+			t.Errorf("[%s %s] expected allowed: %v", c.setType, c.family,
+				c.expected)
+		}
+
+		set := IPSetSpec(IPSetName("foo"), IPSetType(c.setType),
+			IPSetHashFamily(c.family))
+		err := set.Validate()
+		if !c.expected && (err == nil ||
+			!strings.Contains(err.Error(), "IPv4 only")) {
+			t.Errorf("[%s %s] expected family error, got: %v", c.setType,
+				c.family, err)
+		}
+	}
+}
+
+func TestNewSetName(t *testing.T) {
+	cases := []struct {
+		name    string
+		setname string
+		invalid bool
+	}{
+		{name: "Valid name", setname: "foo"},
+		{name: "Maximum length", setname: strings.Repeat("a", MaxSetNameLength)},
+		{name: "Empty name", setname: "", invalid: true},
+		{name: "Too long name", setname: strings.Repeat("a", 32), invalid: true},
+		{name: "Name with whitespace", setname: "foo bar", invalid: true},
+		{name: "Name looks like an option", setname: "-exist", invalid: true},
+	}
+
+	for _, c := range cases {
+		setname, err := NewSetName(c.setname)
+		if c.invalid {
+			if err == nil {
+				t.Errorf("[%s] expected failure, got: nil", c.name)
+			}
+
+			if setname != "" {
+				t.Errorf("[%s] expected empty set name, got: %s", c.name,
+					setname)
+			}
+
+			// The string needs the explicit conversion, it is then checked
+			// by Validate.
+			if SetName(c.setname).Validate() == nil {
+				t.Errorf("[%s] expected invalid set name", c.name)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[%s] expected success, got: %v", c.name, err)
+		}
+
+		if setname.String() != c.setname || setname.Validate() != nil {
+			t.Errorf("[%s] expected valid set name %s, got: %s", c.name,
+				c.setname, setname)
+		}
+	}
+}